@@ -6,6 +6,7 @@ type AppConfigList struct {
 	BackendInstances       []string      `yaml:"backendInstances,flow"`
 	BackendHttpSchema      string        `yaml:"backendHttpSchema"`
 	ForbiddenRoomNames     []string      `yaml:"forbiddenRoomNames,flow"`
+	TrustedProxies         []string      `yaml:"trustedProxies,flow"`
 	ShutdownWaitTimeoutSec time.Duration `yaml:"shutdownWaitTimeoutSec"`
 	ClientAgreementVersion string        `yaml:"clientAgreementVersion"`
 	UserDrawingEnabled     bool          `yaml:"userDrawingEnabled"`
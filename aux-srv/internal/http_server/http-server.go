@@ -42,6 +42,12 @@ const DirectMessagesLimitParam = "l"
 const DirectMessagesIdParam = "id"
 const DirectMessagesQuiteModeParam = "quite"
 const DirectMessagesResponseFormatParam = "format"
+const DirectSearchTextParam = "q"
+const DirectSearchUserNameParam = "u"
+const DirectSearchFromParam = "from"
+const DirectSearchToParam = "to"
+const DirectSearchBeforeIdParam = "before"
+const TargetBackendURLParam = "targetBackend"
 
 const WinAppVersion = "1"
 
@@ -169,8 +175,11 @@ func StartServer() {
 	router.HandleFunc("/app-win-version", middleware(returnAppWinVersionHandler, loggingWrapper))
 	router.HandleFunc("/pick_backend", middleware(pickBackendForRoomHandler, loggingWrapper, noCacheWrapper))
 	router.HandleFunc("/control_page_proxy", middleware(renderControlPageProxyHandler, basicAuthWrapper, loggingWrapper))
+	router.HandleFunc("/ctrl_migrate_room", middleware(migrateRoomHandler, basicAuthWrapper, loggingWrapper, noCacheWrapper))
 	router.HandleFunc("/r/{query_path:.*}", middleware(directlyRetrieveRoomMessagesHandler, loggingWrapper, noCacheWrapper))
 	router.HandleFunc("/s/{query_path:.*}", middleware(directlySendRoomMessagesHandler, loggingWrapper, noCacheWrapper))
+	router.HandleFunc("/q/{query_path:.*}", middleware(directlySearchRoomMessagesHandler, loggingWrapper, noCacheWrapper))
+	router.HandleFunc("/e/{query_path:.*}", middleware(directlyExportRoomTranscriptHandler, loggingWrapper, noCacheWrapper))
 	router.HandleFunc("/{query_path:.*}", middleware(renderRoomPageHandler, loggingWrapper, noCacheWrapper))
 
 	cert, err := tls.LoadX509KeyPair("/etc/ssl/ssl-bundle.crt", "/etc/ssl/cert.key")
@@ -400,7 +409,7 @@ func directlyRetrieveRoomMessages(r *http.Request, responseFormat string) []byte
 		url.QueryEscape(requestedRoom), url.QueryEscape(roomPassword),
 		url.QueryEscape(messageLimit), url.QueryEscape(messageId), url.QueryEscape(quiteMode), url.QueryEscape(responseFormat))
 
-	backendResponse, err := backendDirectCallClient.Get(requestURL)
+	backendResponse, err := callBackendDirectly(r, requestURL)
 
 	if err != nil {
 		util.LogSevere("Failed to query backend '%s' room '%s' for 'directly retrieve messages': '%s'",
@@ -430,6 +439,187 @@ func directlyRetrieveRoomMessages(r *http.Request, responseFormat string) []byte
 	return respBody
 }
 
+func directlySearchRoomMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	responseFormat := util.GetUnescapedParamValueUnsafe(r, DirectMessagesResponseFormatParam)
+	responseFormat = strings.TrimSpace(responseFormat)
+
+	responseTextBytes := directlySearchRoomMessages(r, responseFormat)
+
+	var contentType = "text/plain; charset=utf-8"
+
+	if responseFormat == "json" {
+		contentType = "application/json"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, err := w.Write(responseTextBytes)
+
+	if err != nil {
+		util.LogWarn("Failed to write response for 'directly search messages' request. err: '%s'", err)
+	}
+}
+
+func directlySearchRoomMessages(r *http.Request, responseFormat string) []byte {
+	requestedRoom := strings.ToLower(mux.Vars(r)["query_path"])
+	requestedRoom = strings.TrimSpace(requestedRoom)
+
+	pickBackendRequested.Inc()
+
+	validateRoomAndPickBackendResponse := load_balancing.ValidateRoomAndPickBackend(requestedRoom)
+	pickBackendError := validateRoomAndPickBackendResponse.ErrorMessage
+
+	if pickBackendError != "" {
+		util.LogWarn("Room name validation error for 'directly search messages': '%s'", pickBackendError)
+
+		return util.BuildDirectRoomMessagesErrorResponse(fmt.Sprintf("error: %s", pickBackendError), responseFormat)
+	}
+
+	requestedRoom, _ = url.QueryUnescape(requestedRoom)
+
+	pickBackendResponse := load_balancing.GetRoomBackend(requestedRoom)
+	backendInstanceAddr := pickBackendResponse.BackendInstanceAddr
+
+	if pickBackendResponse.BackendInstanceAddr == "" {
+		util.LogWarn("Failed to pick backend instance for 'directly search messages': '%s'", pickBackendResponse.ErrorMessage)
+
+		return util.BuildDirectRoomMessagesErrorResponse("error: room not found", responseFormat)
+	}
+
+	query := url.Values{}
+	query.Set("roomName", requestedRoom)
+	query.Set(DirectMessagesResponseFormatParam, responseFormat)
+
+	for _, paramName := range []string{DirectMessagesRoomPasswordURLParam, DirectSearchTextParam, DirectSearchUserNameParam,
+		DirectSearchFromParam, DirectSearchToParam, DirectMessagesLimitParam, DirectSearchBeforeIdParam, DirectMessagesQuiteModeParam} {
+		query.Set(paramName, strings.TrimSpace(util.GetUnescapedParamValueUnsafe(r, paramName)))
+	}
+
+	requestURL := fmt.Sprintf("%s://%s/direct_search?%s", config.AppConfig.BackendHttpSchema, backendInstanceAddr, query.Encode())
+
+	backendResponse, err := callBackendDirectly(r, requestURL)
+
+	if err != nil {
+		util.LogSevere("Failed to query backend '%s' room '%s' for 'directly search messages': '%s'",
+			backendInstanceAddr, requestedRoom, err)
+
+		return util.BuildDirectRoomMessagesErrorResponse("error: failed to search room messages - internal error", responseFormat)
+	}
+
+	defer backendResponse.Body.Close()
+
+	if backendResponse.StatusCode != 200 {
+		util.LogSevere("Got error from backend '%s' room '%s' for 'directly search messages'. Status: '%d'",
+			backendInstanceAddr, requestedRoom, backendResponse.StatusCode)
+
+		return util.BuildDirectRoomMessagesErrorResponse("error: failed to search room messages - internal error", responseFormat)
+	}
+
+	respBody, err := io.ReadAll(backendResponse.Body)
+
+	if err != nil {
+		util.LogSevere("Failed to read response from backend '%s' room '%s' for 'directly search messages': '%s'",
+			backendInstanceAddr, requestedRoom, err)
+
+		return util.BuildDirectRoomMessagesErrorResponse("error: failed to search room messages - internal error", responseFormat)
+	}
+
+	return respBody
+}
+
+// transcript is streamed from backend as is - it may be large, so it is never read into memory here
+func directlyExportRoomTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	transcriptFormat := util.GetUnescapedParamValueUnsafe(r, DirectMessagesResponseFormatParam)
+	transcriptFormat = strings.TrimSpace(transcriptFormat)
+
+	errorResponseFormat := ""
+
+	if transcriptFormat == "json" {
+		errorResponseFormat = "json"
+	}
+
+	writeErrorResponse := func(errorText string) {
+		var contentType = "text/plain; charset=utf-8"
+
+		if errorResponseFormat == "json" {
+			contentType = "application/json"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		_, err := w.Write(util.BuildDirectRoomMessagesErrorResponse(errorText, errorResponseFormat))
+
+		if err != nil {
+			util.LogWarn("Failed to write response for 'directly export transcript' request. err: '%s'", err)
+		}
+	}
+
+	requestedRoom := strings.ToLower(mux.Vars(r)["query_path"])
+	requestedRoom = strings.TrimSpace(requestedRoom)
+
+	pickBackendRequested.Inc()
+
+	validateRoomAndPickBackendResponse := load_balancing.ValidateRoomAndPickBackend(requestedRoom)
+	pickBackendError := validateRoomAndPickBackendResponse.ErrorMessage
+
+	if pickBackendError != "" {
+		util.LogWarn("Room name validation error for 'directly export transcript': '%s'", pickBackendError)
+		writeErrorResponse(fmt.Sprintf("error: %s", pickBackendError))
+
+		return
+	}
+
+	requestedRoom, _ = url.QueryUnescape(requestedRoom)
+
+	pickBackendResponse := load_balancing.GetRoomBackend(requestedRoom)
+	backendInstanceAddr := pickBackendResponse.BackendInstanceAddr
+
+	if pickBackendResponse.BackendInstanceAddr == "" {
+		util.LogWarn("Failed to pick backend instance for 'directly export transcript': '%s'", pickBackendResponse.ErrorMessage)
+		writeErrorResponse("error: room not found")
+
+		return
+	}
+
+	query := url.Values{}
+	query.Set("roomName", requestedRoom)
+	query.Set(DirectMessagesResponseFormatParam, transcriptFormat)
+	query.Set(DirectMessagesRoomPasswordURLParam, strings.TrimSpace(util.GetUnescapedParamValueUnsafe(r, DirectMessagesRoomPasswordURLParam)))
+
+	requestURL := fmt.Sprintf("%s://%s/direct_export?%s", config.AppConfig.BackendHttpSchema, backendInstanceAddr, query.Encode())
+
+	backendResponse, err := callBackendDirectly(r, requestURL)
+
+	if err != nil {
+		util.LogSevere("Failed to query backend '%s' room '%s' for 'directly export transcript': '%s'",
+			backendInstanceAddr, requestedRoom, err)
+		writeErrorResponse("error: failed to export room transcript - internal error")
+
+		return
+	}
+
+	defer backendResponse.Body.Close()
+
+	if backendResponse.StatusCode != 200 {
+		util.LogSevere("Got error from backend '%s' room '%s' for 'directly export transcript'. Status: '%d'",
+			backendInstanceAddr, requestedRoom, backendResponse.StatusCode)
+		writeErrorResponse("error: failed to export room transcript - internal error")
+
+		return
+	}
+
+	for _, headerName := range []string{"Content-Type", "Content-Disposition"} {
+		if headerValue := backendResponse.Header.Get(headerName); headerValue != "" {
+			w.Header().Set(headerName, headerValue)
+		}
+	}
+
+	_, err = io.Copy(w, backendResponse.Body)
+
+	if err != nil {
+		util.LogWarn("Failed to stream response from backend '%s' room '%s' for 'directly export transcript': '%s'",
+			backendInstanceAddr, requestedRoom, err)
+	}
+}
+
 func directlySendRoomMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	responseFormat := util.GetUnescapedParamValueUnsafe(r, DirectMessagesResponseFormatParam)
 	responseFormat = strings.TrimSpace(responseFormat)
@@ -493,7 +683,7 @@ func directlySendRoomMessages(r *http.Request, responseFormat string) []byte {
 		config.AppConfig.BackendHttpSchema, backendInstanceAddr,
 		url.QueryEscape(requestedRoom), url.QueryEscape(messageText), url.QueryEscape(roomPassword), url.QueryEscape(responseFormat))
 
-	backendResponse, err := backendDirectCallClient.Get(requestURL)
+	backendResponse, err := callBackendDirectly(r, requestURL)
 
 	if err != nil {
 		util.LogSevere("Failed to query backend '%s' room '%s' for 'directly send message': '%s'",
@@ -523,6 +713,19 @@ func directlySendRoomMessages(r *http.Request, responseFormat string) []byte {
 	return respBody
 }
 
+// backend limits direct sending rate and wrong password attempts per client IP, so it is passed along
+func callBackendDirectly(r *http.Request, requestURL string) (*http.Response, error) {
+	backendRequest, err := http.NewRequest(http.MethodGet, requestURL, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	backendRequest.Header.Set("X-Real-IP", util.GetClientIp(r))
+
+	return backendDirectCallClient.Do(backendRequest)
+}
+
 func renderControlPageProxyHandler(w http.ResponseWriter, r *http.Request) {
 	vars := map[string]interface{}{
 		"backendInstances": config.AppConfig.BackendInstances,
@@ -535,6 +738,42 @@ func renderControlPageProxyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func migrateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	roomName := strings.TrimSpace(util.GetUnescapedParamValueUnsafe(r, RoomNameURLParam))
+	targetBackend := strings.TrimSpace(util.GetUnescapedParamValueUnsafe(r, TargetBackendURLParam))
+
+	response := map[string]string{
+		"roomName":      roomName,
+		"targetBackend": targetBackend,
+		"result":        "ok",
+		"errorMessage":  "",
+	}
+
+	if roomName == "" || targetBackend == "" {
+		response["result"] = ""
+		response["errorMessage"] = "room name and target backend are required"
+	} else if err := load_balancing.MigrateRoom(roomName, targetBackend); err != nil {
+		util.LogSevere("Failed to migrate room '%s' to backend '%s': '%s'", roomName, targetBackend, err)
+
+		response["result"] = ""
+		response["errorMessage"] = err.Error()
+	}
+
+	jsonData, err := json.Marshal(response)
+
+	if err != nil {
+		util.LogSevere("Failed to serialize structure for 'migrate room' request. err: '%s'", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(jsonData)
+
+	if err != nil {
+		util.LogWarn("Failed to write response for 'migrate room' request. err: '%s'", err)
+	}
+}
+
 func pickBackendForRoomHandler(w http.ResponseWriter, r *http.Request) {
 	pickBackendRequested.Inc()
 
@@ -728,6 +967,12 @@ func loadAppConfigs() {
 	UserDrawingEnabled = config.AppConfig.UserDrawingEnabled
 	ClientAgreementVersion = config.AppConfig.ClientAgreementVersion
 
+	err = util.SetTrustedProxies(config.AppConfig.TrustedProxies)
+	if err != nil {
+		log.Printf("[SEVERE] Invalid trusted proxies in app config: '%s'", config.AppConfig.TrustedProxies)
+		panic(err)
+	}
+
 	envCtrlAuthLogin := os.Getenv("CTRL_AUTH_LOGIN")
 	if envCtrlAuthLogin != "" {
 		config.AppConfig.CtrlAuthLogin = envCtrlAuthLogin
@@ -755,6 +1000,7 @@ func loadAppConfigs() {
 	log.Printf("app config: HttpSchema='%s'", HttpSchema)
 	log.Printf("app config: CookiesIsSecure='%t'", CookiesIsSecure)
 	log.Printf("app config: Domain='%s'", Domain)
+	log.Printf("app config: TrustedProxies='%s'", config.AppConfig.TrustedProxies)
 	log.Printf("app config: UserDrawingEnabled='%t'", UserDrawingEnabled)
 	log.Printf("app config: ClientAgreementVersion='%s'", ClientAgreementVersion)
 	log.Printf("app config: UnsecureTestMode='%t'", UnsecureTestMode)
@@ -762,6 +1008,7 @@ func loadAppConfigs() {
 	//init http clients
 	initDirectCallHttpClient(UnsecureTestMode)
 	load_balancing.InitBackendHwStatusHttpClient(UnsecureTestMode)
+	load_balancing.InitBackendCtrlHttpClient(UnsecureTestMode)
 }
 
 func setupMetrics() {
@@ -34,6 +34,7 @@ type HwStatusInfo struct {
 	LastRamUsagePerc      float64 `json:"ram"`
 	UsersOnline           int64   `json:"uo"`
 	RequestedRoomFound    bool    `json:"rf"`
+	IsDraining            bool    `json:"dr"`
 }
 
 /* Constants */
@@ -455,10 +456,19 @@ func getHwInfoStatusFromBackend(backendInstance string, roomName string) (HwStat
 }
 
 func findLeastLoadedBackend(hwStatusResponsesByInstance *map[string]HwStatusInfo) (string, error) {
+	//draining backends still serve rooms they already have, but never get new ones
+	acceptingHwStatusResponsesByInstance := make(map[string]HwStatusInfo, len(*hwStatusResponsesByInstance))
+
+	for backendInstance, hwStatus := range *hwStatusResponsesByInstance {
+		if !hwStatus.IsDraining {
+			acceptingHwStatusResponsesByInstance[backendInstance] = hwStatus
+		}
+	}
+
 	//list is sorted by RAM, then if equal - by CPU
 	//RAM values are ceiled to 10th during sorting, so e.g. 16 becomes 20 etc.
 	//needed to average RAM load a bit and allow picking node with lower CPU between several nodes with close RAM load values
-	backendInfoPairList := sortMapByValue(hwStatusResponsesByInstance)
+	backendInfoPairList := sortMapByValue(&acceptingHwStatusResponsesByInstance)
 
 	var loadMarginStep float64 = 10
 
@@ -0,0 +1,227 @@
+package load_balancing
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"instantchat.rooms/instantchat/aux-srv/internal/config"
+	"instantchat.rooms/instantchat/aux-srv/internal/util"
+)
+
+/* Constants */
+
+const BackendRoomExportEndpointPath = "ctrl_room_export"
+const BackendRoomImportEndpointPath = "ctrl_room_import"
+const BackendRoomMigratedEndpointPath = "ctrl_room_migrated"
+const BackendRoomReleaseEndpointPath = "ctrl_room_release"
+const BackendRoomUnfreezeEndpointPath = "ctrl_room_unfreeze"
+
+const BackendRoomNameURLParam = "roomName"
+const BackendTargetBackendURLParam = "targetBackend"
+
+/* Variables */
+
+var backendCtrlClient *http.Client = nil
+
+func InitBackendCtrlHttpClient(unsecureTestMode bool) {
+	var tlsConfig *tls.Config = nil
+
+	if unsecureTestMode {
+		log.Printf("WARNING: unsecure http client enabled")
+
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS10,
+		}
+	}
+
+	backendCtrlClient = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+			}).DialContext,
+
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: 5 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			TLSClientConfig:       tlsConfig,
+		},
+	}
+}
+
+// moves room with all its data from backend it currently lives on to target backend.
+// Room name cache item stays locked during whole migration, so clients asking for room backend meanwhile
+// will wait and then get target backend
+func MigrateRoom(roomName string, targetBackendInstanceAddr string) error {
+	if !util.ArrayContains(config.AppConfig.BackendInstances, targetBackendInstanceAddr) {
+		return fmt.Errorf("unknown target backend '%s'", targetBackendInstanceAddr)
+	}
+
+	roomNamesMutex.Lock()
+
+	cacheItem, found := roomNamesCache[roomName]
+
+	if !found {
+		cacheItem = &RoomNameCacheItem{
+			roomNameLock:        sync.Mutex{},
+			lastCheckTimestamp:  time.Now().UnixNano(),
+			backendInstanceAddr: "",
+		}
+
+		roomNamesCache[roomName] = cacheItem
+	}
+
+	roomNamesMutex.Unlock()
+
+	cacheItem.roomNameLock.Lock()
+	defer cacheItem.roomNameLock.Unlock()
+
+	sourceBackendInstanceAddr, err := findRoomBackend(roomName, cacheItem.backendInstanceAddr)
+
+	if err != nil {
+		return err
+	}
+
+	if sourceBackendInstanceAddr == targetBackendInstanceAddr {
+		return errors.New("room already lives on target backend")
+	}
+
+	util.LogInfo("Migrating room '%s' from backend '%s' to backend '%s'", roomName, sourceBackendInstanceAddr, targetBackendInstanceAddr)
+
+	roomQuery := url.Values{BackendRoomNameURLParam: {roomName}}
+
+	/* Freeze and export room on source backend */
+
+	roomSnapshotJson, err := callBackendCtrlEndpoint(http.MethodPost, sourceBackendInstanceAddr, BackendRoomExportEndpointPath, roomQuery, nil)
+
+	if err != nil {
+		return fmt.Errorf("failed to export room: %w", err)
+	}
+
+	/* Import room on target backend */
+
+	_, err = callBackendCtrlEndpoint(http.MethodPost, targetBackendInstanceAddr, BackendRoomImportEndpointPath, nil, roomSnapshotJson)
+
+	if err != nil {
+		unfreezeRoom(roomName, sourceBackendInstanceAddr)
+
+		return fmt.Errorf("failed to import room: %w", err)
+	}
+
+	/* Keep source copy frozen for good - it must not come back to life by freeze timeout */
+
+	_, err = callBackendCtrlEndpoint(http.MethodPost, sourceBackendInstanceAddr, BackendRoomMigratedEndpointPath, roomQuery, nil)
+
+	if err != nil {
+		//release below removes source copy anyway - it is only a problem if release fails too
+		util.LogSevere("ERROR: room '%s' imported by '%s' but failed to mark it as migrated on source backend '%s': '%s'",
+			roomName, targetBackendInstanceAddr, sourceBackendInstanceAddr, err)
+	}
+
+	/* Point room to target backend, then drop it from source one (connected users get redirected) */
+
+	cacheItem.backendInstanceAddr = targetBackendInstanceAddr
+	cacheItem.lastCheckTimestamp = time.Now().UnixNano()
+
+	releaseQuery := url.Values{
+		BackendRoomNameURLParam:      {roomName},
+		BackendTargetBackendURLParam: {targetBackendInstanceAddr},
+	}
+
+	_, err = callBackendCtrlEndpoint(http.MethodPost, sourceBackendInstanceAddr, BackendRoomReleaseEndpointPath, releaseQuery, nil)
+
+	if err != nil {
+		//room is already served by target backend, source copy stays frozen so it cannot diverge
+		util.LogSevere("ERROR: room '%s' migrated to '%s' but failed to release it on source backend '%s': '%s'",
+			roomName, targetBackendInstanceAddr, sourceBackendInstanceAddr, err)
+
+		return fmt.Errorf("room migrated, but failed to release it on source backend: %w", err)
+	}
+
+	util.LogInfo("Migrated room '%s' from backend '%s' to backend '%s'", roomName, sourceBackendInstanceAddr, targetBackendInstanceAddr)
+
+	return nil
+}
+
+// returns backend room lives on. Cached backend is verified, if room is unknown - all backends are asked
+func findRoomBackend(roomName string, cachedBackendInstanceAddr string) (string, error) {
+	if cachedBackendInstanceAddr != "" {
+		hwStatusResponse, err := getHwInfoStatusFromBackend(cachedBackendInstanceAddr, roomName)
+
+		if err == nil && hwStatusResponse.RequestedRoomFound {
+			return cachedBackendInstanceAddr, nil
+		}
+	}
+
+	for _, backendInstance := range config.AppConfig.BackendInstances {
+		hwStatusResponse, err := getHwInfoStatusFromBackend(backendInstance, roomName)
+
+		if err == nil && hwStatusResponse.RequestedRoomFound {
+			return backendInstance, nil
+		}
+	}
+
+	return "", errors.New("room not found on any backend")
+}
+
+func unfreezeRoom(roomName string, backendInstanceAddr string) {
+	_, err := callBackendCtrlEndpoint(http.MethodPost, backendInstanceAddr, BackendRoomUnfreezeEndpointPath,
+		url.Values{BackendRoomNameURLParam: {roomName}}, nil)
+
+	if err != nil {
+		util.LogSevere("ERROR: failed to unfreeze room '%s' on backend '%s' after failed migration: '%s'", roomName, backendInstanceAddr, err)
+	}
+}
+
+func callBackendCtrlEndpoint(method string, backendInstance string, path string, query url.Values, body []byte) ([]byte, error) {
+	requestURL := fmt.Sprintf("%s://%s/%s?%s", config.AppConfig.BackendHttpSchema, backendInstance, path, query.Encode())
+
+	var bodyReader io.Reader = nil
+
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	request, err := http.NewRequest(method, requestURL, bodyReader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.SetBasicAuth(config.AppConfig.CtrlAuthLogin, config.AppConfig.CtrlAuthPasswd)
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := backendCtrlClient.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	respBody, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("backend '%s' responded to '%s' with status '%d': %s", backendInstance, path, response.StatusCode, respBody)
+	}
+
+	return respBody, nil
+}
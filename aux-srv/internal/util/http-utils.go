@@ -2,8 +2,10 @@ package util
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 func GetUnescapedParamValueUnsafe(r *http.Request, paramName string) string {
@@ -39,3 +41,69 @@ func BuildDirectRoomMessagesErrorResponse(errorMessage string, responseFormat st
 		return []byte(errorMessage)
 	}
 }
+
+// peers (nginx, aux-srv) that are allowed to report real client IP in 'X-Real-IP' header. Nobody is trusted by default
+var trustedProxyNets []*net.IPNet
+
+// accepts single IPs and CIDR ranges
+func SetTrustedProxies(trustedProxies []string) error {
+	trustedNets := make([]*net.IPNet, 0, len(trustedProxies))
+
+	for _, trustedProxy := range trustedProxies {
+		trustedProxy = strings.TrimSpace(trustedProxy)
+
+		if !strings.Contains(trustedProxy, "/") {
+			if ip := net.ParseIP(trustedProxy); ip != nil && ip.To4() != nil {
+				trustedProxy += "/32"
+			} else {
+				trustedProxy += "/128"
+			}
+		}
+
+		_, trustedNet, err := net.ParseCIDR(trustedProxy)
+
+		if err != nil {
+			return err
+		}
+
+		trustedNets = append(trustedNets, trustedNet)
+	}
+
+	trustedProxyNets = trustedNets
+
+	return nil
+}
+
+// peer address of request. 'X-Real-IP' header is taken into account only if peer is trusted proxy - otherwise
+// any client could pretend to be somebody else
+func GetClientIp(r *http.Request) string {
+	remoteIp, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		remoteIp = r.RemoteAddr
+	}
+
+	if isTrustedProxy(remoteIp) {
+		if realIp := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIp != "" {
+			return realIp
+		}
+	}
+
+	return remoteIp
+}
+
+func isTrustedProxy(ipStr string) bool {
+	ip := net.ParseIP(ipStr)
+
+	if ip == nil {
+		return false
+	}
+
+	for _, trustedNet := range trustedProxyNets {
+		if trustedNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
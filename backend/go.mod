@@ -8,6 +8,7 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
 	github.com/prometheus/client_golang v1.11.0
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/yaml.v2 v2.4.0
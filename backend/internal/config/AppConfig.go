@@ -8,6 +8,8 @@ type AppConfigList struct {
 	ShutdownWaitTimeoutSec time.Duration `yaml:"shutdownWaitTimeoutSec"`
 	ForbiddenRoomNames     []string      `yaml:"forbiddenRoomNames,flow"`
 	AllowedOrigins         []string      `yaml:"allowedOrigins,flow"`
+	TrustedProxies         []string      `yaml:"trustedProxies,flow"`
+	MessageReactions       []string      `yaml:"messageReactions,flow"`
 
 	Server struct {
 		HttpPort       string        `yaml:"port"`
@@ -20,6 +22,29 @@ type AppConfigList struct {
 		LogMaxFileAgeDays int `yaml:"logMaxFileAgeDays"`
 	} `yaml:"logging"`
 
+	RoomStore struct {
+		Type         string `yaml:"type"`
+		BoltFilePath string `yaml:"boltFilePath"`
+	} `yaml:"roomStore"`
+
+	RoomSnapshot struct {
+		Enabled     bool          `yaml:"enabled"`
+		DirPath     string        `yaml:"dirPath"`
+		IntervalSec time.Duration `yaml:"intervalSec"`
+	} `yaml:"roomSnapshot"`
+
+	RoomSettings struct {
+		MaxUsers         RoomSettingBounds `yaml:"maxUsers"`
+		MessagesLimit    RoomSettingBounds `yaml:"messagesLimit"`
+		InactiveTtlSec   RoomSettingBounds `yaml:"inactiveTtlSec"`
+		MaxMessageLength RoomSettingBounds `yaml:"maxMessageLength"`
+	} `yaml:"roomSettings"`
+
+	MessageRateLimit       RateLimit `yaml:"messageRateLimit"`
+	DirectSendingRateLimit RateLimit `yaml:"directSendingRateLimit"`
+
+	MessageFilters []MessageFilter `yaml:"messageFilters"`
+
 	CtrlAuthLogin  string `yaml:"ctrlAuthLogin"`
 	CtrlAuthPasswd string `yaml:"ctrlAuthPasswd"`
 
@@ -27,4 +52,26 @@ type AppConfigList struct {
 	HttpSchema string `yaml:"httpSchema"`
 }
 
+// values room owner may choose for single room setting. Default is used for new rooms
+type RoomSettingBounds struct {
+	Min     int `yaml:"min"`
+	Default int `yaml:"default"`
+	Max     int `yaml:"max"`
+}
+
+// token bucket - burst of messages is allowed, then one message per refill interval
+type RateLimit struct {
+	Burst             int           `yaml:"burst"`
+	RefillIntervalSec time.Duration `yaml:"refillIntervalSec"`
+}
+
+// single step of inbound message filter chain. Words/patterns are for 'profanity' filter, allowed domains - for 'links' one
+type MessageFilter struct {
+	Type           string   `yaml:"type"`
+	Action         string   `yaml:"action"`
+	Words          []string `yaml:"words,flow"`
+	Patterns       []string `yaml:"patterns,flow"`
+	AllowedDomains []string `yaml:"allowedDomains,flow"`
+}
+
 var AppConfig AppConfigList
@@ -0,0 +1,157 @@
+package domain_structures
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// inverted index of message words - lets search avoid scanning whole room history. Texts are expected decoded
+// (not url-escaped). Not thread-safe
+type MessageSearchIndex struct {
+	messageIdsByToken map[string]map[int64]bool
+	tokensByMessageId map[int64][]string
+	//all indexed tokens, sorted - prefix lookup does binary search instead of scanning whole vocabulary
+	sortedTokens []string
+}
+
+func NewMessageSearchIndex() *MessageSearchIndex {
+	return &MessageSearchIndex{
+		messageIdsByToken: make(map[string]map[int64]bool),
+		tokensByMessageId: make(map[int64][]string),
+	}
+}
+
+// indexes message text, replacing previously indexed text of same message (if any)
+func (idx *MessageSearchIndex) Add(messageId int64, text string) {
+	idx.Remove(messageId)
+
+	tokens := TokenizeSearchText(text)
+
+	for _, token := range tokens {
+		messageIds, found := idx.messageIdsByToken[token]
+
+		if !found {
+			messageIds = make(map[int64]bool)
+			idx.messageIdsByToken[token] = messageIds
+
+			idx.insertSortedToken(token)
+		}
+
+		messageIds[messageId] = true
+	}
+
+	idx.tokensByMessageId[messageId] = tokens
+}
+
+func (idx *MessageSearchIndex) Remove(messageId int64) {
+	for _, token := range idx.tokensByMessageId[messageId] {
+		messageIds := idx.messageIdsByToken[token]
+
+		delete(messageIds, messageId)
+
+		if len(messageIds) == 0 {
+			delete(idx.messageIdsByToken, token)
+
+			idx.removeSortedToken(token)
+		}
+	}
+
+	delete(idx.tokensByMessageId, messageId)
+}
+
+// ids of messages that contain all tokens. Last token also matches words it is prefix of - so incomplete
+// last word (user is still typing) finds messages too
+func (idx *MessageSearchIndex) Find(tokens []string) map[int64]bool {
+	if len(tokens) == 0 {
+		return map[int64]bool{}
+	}
+
+	var foundMessageIds map[int64]bool
+
+	for i, token := range tokens {
+		var tokenMessageIds map[int64]bool
+
+		if i == len(tokens)-1 {
+			tokenMessageIds = idx.findByPrefix(token)
+		} else {
+			tokenMessageIds = idx.messageIdsByToken[token]
+		}
+
+		if foundMessageIds == nil {
+			foundMessageIds = make(map[int64]bool, len(tokenMessageIds))
+
+			for messageId := range tokenMessageIds {
+				foundMessageIds[messageId] = true
+			}
+
+			continue
+		}
+
+		for messageId := range foundMessageIds {
+			if !tokenMessageIds[messageId] {
+				delete(foundMessageIds, messageId)
+			}
+		}
+
+		if len(foundMessageIds) == 0 {
+			break
+		}
+	}
+
+	return foundMessageIds
+}
+
+func (idx *MessageSearchIndex) findByPrefix(prefix string) map[int64]bool {
+	messageIds := make(map[int64]bool)
+
+	//tokens with given prefix follow each other in sorted list, starting from first one not less than prefix
+	for i := sort.SearchStrings(idx.sortedTokens, prefix); i < len(idx.sortedTokens); i++ {
+		token := idx.sortedTokens[i]
+
+		if !strings.HasPrefix(token, prefix) {
+			break
+		}
+
+		for messageId := range idx.messageIdsByToken[token] {
+			messageIds[messageId] = true
+		}
+	}
+
+	return messageIds
+}
+
+func (idx *MessageSearchIndex) insertSortedToken(token string) {
+	i := sort.SearchStrings(idx.sortedTokens, token)
+
+	idx.sortedTokens = append(idx.sortedTokens, "")
+	copy(idx.sortedTokens[i+1:], idx.sortedTokens[i:])
+	idx.sortedTokens[i] = token
+}
+
+func (idx *MessageSearchIndex) removeSortedToken(token string) {
+	i := sort.SearchStrings(idx.sortedTokens, token)
+
+	if i < len(idx.sortedTokens) && idx.sortedTokens[i] == token {
+		idx.sortedTokens = append(idx.sortedTokens[:i], idx.sortedTokens[i+1:]...)
+	}
+}
+
+// splits text into unique lowercase words (letters and digits), in order of appearance
+func TokenizeSearchText(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := make([]string, 0, len(words))
+	seenTokens := make(map[string]bool, len(words))
+
+	for _, word := range words {
+		if !seenTokens[word] {
+			seenTokens[word] = true
+			tokens = append(tokens, word)
+		}
+	}
+
+	return tokens
+}
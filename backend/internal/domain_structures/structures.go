@@ -13,7 +13,9 @@ type WebSocket struct {
 	Socket              *websocket.Conn
 	SocketUUID          string //id of this socket
 	SessionUUID         string //user secret token
-	LastKeepAliveSignal int64
+	ClientIp            string
+	LastKeepAliveSignal int64                     //accessed atomically - keep-alives are handled without room lock
+	IsPresenceStale     int32                     //accessed atomically, 1 - user is shown 'away' since keep-alives stopped coming
 	OutMessagesPutCh    chan<- *OutMessageWrapper //input side of channel pair - here engine puts new messages that must be sent to user
 	OutMessagesGetCh    <-chan *OutMessageWrapper //output side of channel pair - here 'client socket message writing routine' takes messages and sends to user
 
@@ -66,6 +68,42 @@ type InMessageFrame struct {
 	SupportOrRejectMessage bool `json:"srM"`
 	//for keep-alive messages
 	KeepAliveBeacon string `json:"kA"`
+
+	//for paginated history - on join: receive only latest N messages (0 - all), on history request: page size and id to load messages before
+	HistoryLimit    int   `json:"hL"`
+	HistoryBeforeId int64 `json:"hB"`
+
+	//for delta resync on re-join - sequence number of last room event user has seen (0 - full sync)
+	LastSeenSeq int64 `json:"lSq"`
+
+	//for moderation commands - user (in scope of room) command is applied to
+	TargetUserInRoomUUID string `json:"tU"`
+	//for reclaiming room ownership from new session
+	OwnerRecoveryKey string `json:"oK"`
+	//for toggling message reaction
+	Reaction string `json:"re"`
+	//for typing indicator - whether user started (or keeps) typing or stopped it
+	IsTyping bool `json:"iT"`
+	//for presence status reported by client (active/idle/away)
+	Presence string `json:"pr"`
+	//for room creation or settings change by room owner (zero fields - default values on creation, unchanged values on change)
+	RoomSettings *RoomSettings `json:"rS"`
+	//for turning announcement mode on/off
+	IsAnnouncementMode bool `json:"aM"`
+	//for slow mode - min interval between messages of same user (0 - slow mode is off)
+	SlowModeIntervalSec int `json:"sMI"`
+	//for message edit policy - time after posting during which messages can be edited (0 - no limit, -1 - editing is disabled)
+	MessageEditWindowSec int `json:"eW"`
+	//for message search. Page size and id to search messages before are taken from HistoryLimit/HistoryBeforeId
+	SearchQuery MessageSearchQuery `json:"sQ"`
+}
+
+// search criteria - all given ones must match. Text and author name are matched case-insensitively
+type MessageSearchQuery struct {
+	Text     string `json:"t"`    //words message must contain (last one may be incomplete)
+	UserName string `json:"uN"`   //part of author's current name
+	FromSec  int64  `json:"from"` //! timestamp in seconds, 0 - unbounded
+	ToSec    int64  `json:"to"`   //! timestamp in seconds, 0 - unbounded
 }
 
 type OutMessageFrame struct {
@@ -85,6 +123,45 @@ type OutMessageFrame struct {
 
 	CurrentBuildNumber *string `json:"bN,omitempty"`
 	ServerStatus       *string `json:"sS,omitempty"`
+
+	//for redirecting users to backend instance room was moved to
+	BackendInstanceAddr *string `json:"bA,omitempty"`
+
+	//for paginated history - whether room has older messages than ones returned
+	HasMoreHistory *bool `json:"hM,omitempty"`
+
+	//room event sequence number - for frames that change room state or for room state snapshots (state as of this seq)
+	EventSeq *int64 `json:"sq,omitempty"`
+
+	//one-time key to reclaim room ownership, sent only to room owner
+	OwnerRecoveryKey *string `json:"oK,omitempty"`
+
+	//for typing indicator
+	IsTyping *bool `json:"iT,omitempty"`
+
+	//for read receipts - latest read message id by user-in-room id (only ones changed since previous update)
+	ReadMarkers *map[string]int64 `json:"rM,omitempty"`
+
+	//for presence updates - only users whose presence changed
+	PresenceChanges *[]RoomUserPresenceDTO `json:"pC,omitempty"`
+
+	//for room settings chosen by room owner
+	RoomSettings *RoomSettings `json:"rS,omitempty"`
+
+	//whether only room owner and moderators can post to room
+	IsAnnouncementMode *bool `json:"aM,omitempty"`
+
+	//min interval between messages of same user set by room owner (0 - slow mode is off)
+	SlowModeIntervalSec *int `json:"sMI,omitempty"`
+
+	//time after posting during which messages can be edited, set by room owner (0 - no limit, -1 - editing is disabled)
+	MessageEditWindowSec *int `json:"eW,omitempty"`
+
+	//for message revisions - previous versions of message text, oldest first (message itself is in Message)
+	MessageRevisions *[]RoomMessageRevision `json:"mRv,omitempty"`
+
+	//for message search - total number of matching messages (page is in Message)
+	SearchHitsCount *int `json:"sHC,omitempty"`
 }
 
 // struct to distribute message between all client socket routines
@@ -107,22 +184,48 @@ const (
 	RoomCreate              Command = "R_C"
 	RoomJoin                Command = "R_J"
 	RoomChangeDescription   Command = "R_CH_D"
+	RoomChangeSettings      Command = "R_CH_S"
+	RoomAnnouncementMode    Command = "R_ANNOUNCE"
+	RoomSlowMode            Command = "R_SLOW_MODE"
+	RoomMessageEditPolicy   Command = "R_EDIT_POLICY"
 	RoomChangeUserName      Command = "R_CH_UN"
 	RoomMembersChanged      Command = "R_M_CH"
+	RoomMoved               Command = "R_MOVED"
+	RoomKickUser            Command = "R_KICK"
+	RoomBanUser             Command = "R_BAN"
+	RoomUnbanUser           Command = "R_UNBAN"
+	RoomBannedUsers         Command = "R_BANNED"
+	RoomGrantModerator      Command = "R_MOD_GRANT"
+	RoomRevokeModerator     Command = "R_MOD_REVOKE"
+	RoomTransferOwnership   Command = "R_OWNER_TRANSFER"
+	RoomReclaimOwnership    Command = "R_OWNER_RECLAIM"
+	RoomOwnerRecoveryKey    Command = "R_OWNER_KEY"
+	RoomPinnedMessages      Command = "R_PINNED"
 
 	TextMessage                Command = "TM"
 	TextMessageEdit            Command = "TM_E"
 	TextMessageDelete          Command = "TM_D"
 	TextMessageSupportOrReject Command = "TM_S_R"
 	AllTextMessages            Command = "ALL_TM"
+	TextMessageHistory         Command = "TM_HISTORY"
+	TextMessagePin             Command = "TM_PIN"
+	TextMessageUnpin           Command = "TM_UNPIN"
+	TextMessageReaction        Command = "TM_REACT"
+	TextMessageWhisper         Command = "TM_W"
+	TextMessageSearch          Command = "TM_SEARCH"
+	TextMessageRevisions       Command = "TM_REVISIONS"
 
 	UserDrawingMessage Command = "DM"
+	UserTyping         Command = "TYPING"
+	UserReadMarker     Command = "READ"
+	UserPresence       Command = "PRESENCE"
 
 	Error            Command = "ER"
 	RequestProcessed Command = "RP"
 
 	NotifyMessagesLimitApproaching Command = "N_M_LIMIT_A"
 	NotifyMessagesLimitReached     Command = "N_M_LIMIT_R"
+	NotifyMentioned                Command = "N_MENTION"
 )
 
 /* rooms */
@@ -133,9 +236,14 @@ type RoomInfo struct {
 }
 
 type RoomUser struct {
-	UserInRoomUUID string `json:"uId"` //user id in scope of room (public)
-	UserName       string `json:"n"`
-	IsAnonName     bool   `json:"an"`
+	UserInRoomUUID    string `json:"uId"` //user id in scope of room (public)
+	UserName          string `json:"n"`
+	IsAnonName        bool   `json:"an"`
+	LastReadMessageId int64  `json:"lR,omitempty"` //id of latest message user has seen (0 - none)
+	LastSeenAt        int64  `json:"lS,omitempty"` //time of latest keep-alive or status signal from user
+
+	DeclaredPresence string `json:"-"` //runtime-only, status reported by user's client
+	Presence         string `json:"-"` //runtime-only, effective status last sent to room members
 }
 
 type RoomUserDTO struct {
@@ -143,6 +251,20 @@ type RoomUserDTO struct {
 	UserName       *string `json:"n"`
 	IsAnonName     *bool   `json:"an"`
 	IsOnlineInRoom *bool   `json:"o"`
+	Role           *string `json:"rl,omitempty"`
+
+	LastReadMessageId *int64 `json:"lR,omitempty"`
+
+	Presence   *string `json:"pr,omitempty"` //only for users online in room
+	LastSeenAt *int64  `json:"lS,omitempty"`
+}
+
+// incremental presence change of single user
+type RoomUserPresenceDTO struct {
+	UserInRoomUUID string `json:"uId"`
+	IsOnlineInRoom bool   `json:"o"`
+	Presence       string `json:"pr,omitempty"`
+	LastSeenAt     int64  `json:"lS"`
 }
 
 type RoomMessage struct {
@@ -156,6 +278,26 @@ type RoomMessage struct {
 	ReplyToMessageId *int64  `json:"rM"`
 	UserInRoomUUID   string  `json:"uId"`
 	CreatedAtSec     int64   `json:"cAt"` //! timestamp in seconds
+
+	ReactionCounts map[string]int `json:"re,omitempty"` //counts of reactions except support/reject ones (those are in SupportedCount/RejectedCount)
+
+	RecipientUserInRoomUUID string `json:"to,omitempty"` //for whispers - the only user (besides author) message is visible to
+
+	TtlSec       int64 `json:"ttl,omitempty"` //for self-destructing messages - lifetime requested by author (0 - message does not expire)
+	ExpiresAtSec int64 `json:"eAt,omitempty"` //! timestamp in seconds, message is deleted at this time or once all current members read it
+
+	IsFlagged bool `json:"fl,omitempty"` //message was let through by message filters, but marked as suspicious
+
+	MentionedUserInRoomUUIDs []string `json:"mn,omitempty"` //users mentioned with '@name' - ids, so mentions survive name changes
+
+	Revisions      []RoomMessageRevision `json:"rv,omitempty"`  //latest previous versions of message text, oldest first
+	RevisionsCount int                   `json:"rvC,omitempty"` //number of all edits, may be greater than number of kept revisions
+}
+
+// previous version of message text
+type RoomMessageRevision struct {
+	Text      string `json:"t"`
+	CreatedAt int64  `json:"cAt"` //! timestamp in nanoseconds, when this version was posted or edited in
 }
 
 // DTO is needed for sending RoomMessage to users, because some fields may be empty in case of different Commands,
@@ -171,6 +313,27 @@ type RoomMessageDTO struct {
 	ReplyToMessageId *int64  `json:"rM,omitempty"`
 	UserInRoomUUID   *string `json:"uId,omitempty"`
 	CreatedAtSec     *int64  `json:"cAt,omitempty"`
+
+	//for deleted messages - who removed message (author, room owner or moderator)
+	RemovedByUserInRoomUUID *string `json:"rB,omitempty"`
+
+	//reaction counts by reaction (including support/reject ones). For reaction changes - only changed reactions
+	Reactions map[string]int `json:"re,omitempty"`
+
+	//for whispers - recipient of private message
+	RecipientUserInRoomUUID *string `json:"to,omitempty"`
+
+	//for self-destructing messages
+	ExpiresAtSec *int64 `json:"eAt,omitempty"`
+
+	//message was marked as suspicious by message filters
+	IsFlagged *bool `json:"fl,omitempty"`
+
+	//users mentioned in message text
+	MentionedUserInRoomUUIDs *[]string `json:"mn,omitempty"`
+
+	//for edited messages - number of previous versions of message text
+	RevisionsCount *int `json:"rvC,omitempty"`
 }
 
 type RoomCtrlInfo struct {
@@ -178,22 +341,30 @@ type RoomCtrlInfo struct {
 	Name               string `json:"id"`
 	StartedAt          string `json:"startedAt"`
 	ActiveRoomUsersNum int    `json:"activeRoomUsersNum"`
+	IsFrozen           bool   `json:"isFrozen"`
+
+	FailedPasswordAttempts int    `json:"failedPasswordAttempts"`
+	PasswordLockedUntil    string `json:"passwordLockedUntil"`
 }
 
 type RoomMessageVotes struct {
-	SupportVotesBySessionUUID map[string]bool
-	RejectVotesBySessionUUID  map[string]bool
+	SupportVotesBySessionUUID map[string]bool            `json:"s"`
+	RejectVotesBySessionUUID  map[string]bool            `json:"r"`
+	ReactionsBySessionUUID    map[string]map[string]bool `json:"re,omitempty"` //reaction -> sessions that reacted (except support/reject ones)
 }
 
 // room
 type Room struct {
 	sync.Mutex
 	IsDeleted            bool
+	IsFrozen             bool  //room is being moved to another backend instance - all changes are rejected
+	FrozenUntil          int64 //room is unfrozen at this time if migration is not completed by then (e.g. aux-srv died mid-way)
+	IsMigrated           bool  //room is already imported by target backend - it stays frozen until released
 	Id                   string
 	Name                 string
 	PasswordHash         string
 	Description          string
-	CreatedBySessionUUID string
+	CreatedBySessionUUID string //room owner. Initially - room creator, may be changed by ownership transfer or reclaim
 	StartedAt            int64
 	LastActiveAt         int64
 	NextMessageId        int64 //gets incremented for every next message
@@ -206,6 +377,56 @@ type Room struct {
 	RoomMessages            map[int64]*RoomMessage //all room messages
 	RoomMessagesLen         int
 	MessageVotesByMessageId map[int64]*RoomMessageVotes //user votes (support/reject) for messages or this room
+
+	WhisperMessages map[int64]*RoomMessage //private messages between two room users. Share id sequence with RoomMessages
+
+	BannedUserInRoomUUIDs    map[string]bool //users banned by room owner or moderators - they can not join room anymore
+	ModeratorUserInRoomUUIDs map[string]bool //users granted moderator role by room owner
+	OwnerRecoveryKeyHash     string          //hash of one-time key that allows to reclaim ownership from another session
+
+	PinnedMessageIds []int64 //messages pinned by room owner or moderators, in order of pinning. Never removed by messages shrink
+
+	Settings             RoomSettings //limits chosen by room owner within bounds set by admin
+	IsAnnouncementMode   bool         //only room owner and moderators can post messages, others can only read and react
+	SlowModeIntervalSec  int          //min interval between messages of same user (except room owner and moderators). 0 - slow mode is off
+	MessageEditWindowSec int          //time after posting during which messages can be edited (except by room owner and moderators). 0 - no limit, -1 - editing is disabled
+
+	LastEventSeq int64        //sequence number of last event sent to room members
+	EventLog     []*RoomEvent //bounded log of latest events - to replay them for re-joining users
+
+	TypingUsersByUserInRoomUUID map[string]*RoomUserTypingState //runtime-only, users currently typing a message
+
+	PendingReadMarkerUserInRoomUUIDs map[string]bool //runtime-only, users whose read markers changed since last 'seen by' broadcast
+
+	MessageExpiryTimer     *time.Timer //runtime-only, fires when earliest self-destructing message expires. Nil if room has none
+	NextMessageExpiryAtSec int64       //runtime-only, time MessageExpiryTimer fires at
+
+	MessageRateLimitersBySessionUUID map[string]*TokenBucket //runtime-only, limits how fast each user can send messages
+	EditRateLimitersBySessionUUID    map[string]*TokenBucket //runtime-only, limits how fast each user can edit messages
+
+	SearchIndex *MessageSearchIndex //runtime-only, words of room messages (not whispers). Built on first search, then kept up to date
+}
+
+// room-level limits. Each value is kept within bounds from app config
+type RoomSettings struct {
+	MaxUsers         int `json:"mU"`  //max users being in room at the same time
+	MessagesLimit    int `json:"mL"`  //messages history size. Older half of history is dropped once it is reached
+	InactiveTtlSec   int `json:"iT"`  //room without active users is deleted after being inactive for this period
+	MaxMessageLength int `json:"mML"` //max length of message text (in characters)
+}
+
+// short-lived typing state, dropped by timer unless user keeps typing
+type RoomUserTypingState struct {
+	LastBroadcastAt int64
+	ExpiryTimer     *time.Timer
+}
+
+// state-changing frame that was sent to room members
+type RoomEvent struct {
+	Seq   int64
+	Frame *OutMessageFrame
+
+	AudienceUserInRoomUUIDs []string //if set - event was sent only to these users (e.g. whisper participants)
 }
 
 func (r *Room) CopyActiveClientSocketMap() (*map[string]*WebSocket, int64) {
@@ -226,6 +447,41 @@ func (r *Room) CopyActiveClientSocketMapNonLocking() *map[string]*WebSocket {
 	return &activeClientSocketsByUUIDCopy
 }
 
+/* room snapshots */
+
+// serializable copy of room state (everything except runtime-only data like sockets and active users)
+type RoomSnapshot struct {
+	Id                   string `json:"id"`
+	Name                 string `json:"name"`
+	PasswordHash         string `json:"passwordHash"`
+	Description          string `json:"description"`
+	CreatedBySessionUUID string `json:"createdBy"`
+	StartedAt            int64  `json:"startedAt"`
+	LastActiveAt         int64  `json:"lastActiveAt"`
+	NextMessageId        int64  `json:"nextMessageId"`
+
+	BannedUserInRoomUUIDs    map[string]bool `json:"bannedUsers,omitempty"`
+	ModeratorUserInRoomUUIDs map[string]bool `json:"moderators,omitempty"`
+	OwnerRecoveryKeyHash     string          `json:"ownerRecoveryKeyHash,omitempty"`
+	PinnedMessageIds         []int64         `json:"pinnedMessageIds,omitempty"`
+	Settings                 *RoomSettings   `json:"settings,omitempty"`
+	IsAnnouncementMode       bool            `json:"announcementMode,omitempty"`
+	SlowModeIntervalSec      int             `json:"slowModeIntervalSec,omitempty"`
+	MessageEditWindowSec     int             `json:"messageEditWindowSec,omitempty"`
+
+	AllRoomAuthorizedUsersBySessionUUID map[string]*RoomUser        `json:"authorizedUsers"`
+	RoomMessages                        []*RoomMessage              `json:"messages"`
+	MessageVotesByMessageId             map[int64]*RoomMessageVotes `json:"messageVotes"`
+	WhisperMessages                     []*RoomMessage              `json:"whispers,omitempty"`
+}
+
+// on-disk snapshot file format. Version must be incremented on any incompatible change of RoomSnapshot
+type RoomsSnapshot struct {
+	Version   int            `json:"version"`
+	CreatedAt int64          `json:"createdAt"`
+	Rooms     []RoomSnapshot `json:"rooms"`
+}
+
 //active rooms map
 
 type ActiveRoomsByName struct {
@@ -290,6 +546,7 @@ type WsError struct {
 var WsServerError = WsError{Name: "WsServerError", Code: 101, Text: "server error"}
 var WsConnectionError = WsError{Name: "WsConnectionError", Code: 102, Text: "connection error"}
 var WsInvalidInput = WsError{Name: "WsInvalidInput", Code: 103, Text: "invalid input"}
+var WsServerDraining = WsError{Name: "WsServerDraining", Code: 104, Text: "server is not accepting new rooms at the moment, please try again in a minute"}
 
 var WsRoomExists = WsError{Name: "WsRoomExists", Code: 201, Text: "room with this name already exists"}
 var WsRoomNotFound = WsError{Name: "WsRoomNotFound", Code: 202, Text: "room not found"}
@@ -300,8 +557,22 @@ var WsRoomNotAuthorized = WsError{Name: "WsRoomNotAuthorized", Code: 206, Text:
 var WsRoomMessageTooLargeError = WsError{Name: "WsRoomMessageTooLargeError", Code: 207, Text: "message is too long"}
 var WsRoomIsFullError = WsError{Name: "WsRoomIsFullError", Code: 208, Text: "room is full"}
 var WsRoomUserDuplication = WsError{Name: "WsRoomUserDuplication", Code: 209, Text: "user connected to this room from another browser tab"}
+var WsRoomIsMigrating = WsError{Name: "WsRoomIsMigrating", Code: 210, Text: "room is being moved to another server, please wait"}
+var WsRoomUserKicked = WsError{Name: "WsRoomUserKicked", Code: 211, Text: "you were removed from this room by its owner or moderator"}
+var WsRoomUserBanned = WsError{Name: "WsRoomUserBanned", Code: 212, Text: "you are banned from this room"}
+var WsRoomUserNotFound = WsError{Name: "WsRoomUserNotFound", Code: 213, Text: "user not found in this room"}
+var WsRoomNotPermitted = WsError{Name: "WsRoomNotPermitted", Code: 214, Text: "not enough rights in this room"}
+var WsRoomInvalidOwnerRecoveryKey = WsError{Name: "WsRoomInvalidOwnerRecoveryKey", Code: 215, Text: "invalid room owner recovery key"}
+var WsRoomPinnedMessagesLimit = WsError{Name: "WsRoomPinnedMessagesLimit", Code: 216, Text: "pinned messages limit reached"}
+var WsRoomAnnouncementOnly = WsError{Name: "WsRoomAnnouncementOnly", Code: 217, Text: "only room owner and moderators can post to this room"}
+var WsRoomRateLimited = WsError{Name: "WsRoomRateLimited", Code: 218, Text: "too many messages, please slow down"}
+var WsRoomPasswordAttemptsLocked = WsError{Name: "WsRoomPasswordAttemptsLocked", Code: 219, Text: "too many wrong password attempts, please try again later"}
+var WsRoomMessageRejected = WsError{Name: "WsRoomMessageRejected", Code: 220, Text: "message was rejected by content filter"}
+var WsRoomMessageEditDisabled = WsError{Name: "WsRoomMessageEditDisabled", Code: 221, Text: "message editing is disabled in this room"}
+var WsRoomMessageEditWindowExpired = WsError{Name: "WsRoomMessageEditWindowExpired", Code: 222, Text: "message can not be edited anymore"}
 
 var WsRoomCredsValidationErrorBadLength = WsError{Name: "WsRoomCredsValidationErrorBadLength", Code: 301, Text: "invalid room name length"}
 var WsRoomCredsValidationErrorNameForbidden = WsError{Name: "WsRoomCredsValidationErrorNameForbidden", Code: 302, Text: "room name is forbidden"}
 var WsRoomCredsValidationErrorNameHasBadChars = WsError{Name: "WsRoomCredsValidationErrorNameHasBadChars", Code: 303, Text: "room name contains bad characters"}
 var WsRoomValidationErrorBadDescriptionLength = WsError{Name: "WsRoomValidationErrorBadDescriptionLength", Code: 304, Text: "invalid room description length"}
+var WsRoomValidationErrorBadSettings = WsError{Name: "WsRoomValidationErrorBadSettings", Code: 305, Text: "room settings are out of allowed bounds"}
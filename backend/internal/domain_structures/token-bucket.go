@@ -0,0 +1,54 @@
+package domain_structures
+
+import "time"
+
+// classic token bucket: holds up to Capacity tokens, one token is added every RefillInterval. Not thread-safe
+type TokenBucket struct {
+	Capacity       int
+	RefillInterval time.Duration
+
+	tokens       float64
+	lastRefillAt int64
+}
+
+func NewTokenBucket(capacity int, refillInterval time.Duration) *TokenBucket {
+	return &TokenBucket{
+		Capacity:       capacity,
+		RefillInterval: refillInterval,
+		tokens:         float64(capacity),
+		lastRefillAt:   time.Now().UnixNano(),
+	}
+}
+
+// takes one token if available. Otherwise returns false and time after which next token is available
+func (b *TokenBucket) Take(now int64) (bool, time.Duration) {
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) * float64(b.RefillInterval))
+}
+
+// bucket that is full again is no different from new one, so it may be dropped
+func (b *TokenBucket) IsFull(now int64) bool {
+	b.refill(now)
+
+	return b.tokens >= float64(b.Capacity)
+}
+
+func (b *TokenBucket) refill(now int64) {
+	if now <= b.lastRefillAt {
+		return
+	}
+
+	b.tokens += float64(now-b.lastRefillAt) / float64(b.RefillInterval)
+	b.lastRefillAt = now
+
+	if b.tokens > float64(b.Capacity) {
+		b.tokens = float64(b.Capacity)
+	}
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -12,7 +13,6 @@ import (
 
 const HouseKeeperRunInterval = 60 * time.Second
 const RoomEmptyTTL = 5 * time.Minute
-const RoomInactiveTTL = 12 * time.Hour
 
 var stopTimers = false
 
@@ -22,6 +22,11 @@ func StartSocketHouseKeeper(room *domain_structures.Room) {
 	timer := time.NewTimer(HouseKeeperRunInterval)
 
 	go runTimer(room, timer)
+
+	//restored rooms may already have self-destructing messages
+	room.Lock()
+	rescheduleRoomMessagesExpiryNonLocking(room)
+	room.Unlock()
 }
 
 func runTimer(room *domain_structures.Room, timer *time.Timer) {
@@ -43,7 +48,7 @@ func runTimer(room *domain_structures.Room, timer *time.Timer) {
 	if len(*roomActiveClientSocketsByUUID) <= 0 {
 		//if room is empty, old enough and has been inactive for enough time - delete it
 		if (time.Now().UnixNano()-room.StartedAt) >= RoomEmptyTTL.Nanoseconds() &&
-			(time.Now().UnixNano()-room.LastActiveAt) >= RoomInactiveTTL.Nanoseconds() {
+			(time.Now().UnixNano()-room.LastActiveAt) >= getRoomInactiveTtl(room).Nanoseconds() {
 			deleted := tryDeleteEmptyRoom(room, roomActiveClientSocketsByUUID)
 
 			if deleted {
@@ -68,7 +73,7 @@ func runTimer(room *domain_structures.Room, timer *time.Timer) {
 			go func() {
 				defer waitGroup.Done()
 
-				var lastKeepAliveSec = (time.Now().UnixNano() - clSocket.LastKeepAliveSignal) / time.Second.Nanoseconds()
+				var lastKeepAliveSec = (time.Now().UnixNano() - atomic.LoadInt64(&clSocket.LastKeepAliveSignal)) / time.Second.Nanoseconds()
 
 				if clSocket.IsDead() {
 					util.LogTrace("SocketHouseKeeper found dead socket. Last keep alive ago: '%d's Room '%s' / '%s', socket '%s'",
@@ -103,7 +108,7 @@ func runTimer(room *domain_structures.Room, timer *time.Timer) {
 
 		//if all sockets disconnected AND room has been inactive for enough time - delete room (wont delete if new sockets opened during check)
 		if len(foundDeadSocketsById) == len(*roomActiveClientSocketsByUUID) &&
-			(time.Now().UnixNano()-room.LastActiveAt) >= RoomInactiveTTL.Nanoseconds() {
+			(time.Now().UnixNano()-room.LastActiveAt) >= getRoomInactiveTtl(room).Nanoseconds() {
 
 			deleted := tryDeleteEmptyRoom(room, roomActiveClientSocketsByUUID)
 			if deleted {
@@ -137,6 +142,8 @@ func runTimer(room *domain_structures.Room, timer *time.Timer) {
 						delete(room.ActiveRoomUserUUIDBySessionUUID, clSocket.SessionUUID)
 						room.ActiveRoomUsersLen = len(room.ActiveRoomUserUUIDBySessionUUID)
 
+						markUserOfflineNonLocking(room, clSocket.SessionUUID, clSocket)
+
 						membersListChanged = true
 					}
 				}
@@ -148,6 +155,9 @@ func runTimer(room *domain_structures.Room, timer *time.Timer) {
 				writeMembersListChangedFrameToActiveRoomMembers(room, nil)
 			}
 		}
+
+		//users whose clients stopped sending keep-alives become 'away'
+		refreshRoomUsersPresence(room)
 	}
 
 	util.LogTrace("Restarting SocketHouseKeeper for room '%s' / '%s'", room.Id, room.Name)
@@ -159,10 +169,13 @@ func runTimer(room *domain_structures.Room, timer *time.Timer) {
 
 func tryDeleteEmptyRoom(room *domain_structures.Room, roomActiveClientSocketsByUUID *map[string]*domain_structures.WebSocket) bool {
 	room.Lock()
-	//check no new sockets were added to room while we were checking original list
-	if util.MapKeySetsAreEqual(roomActiveClientSocketsByUUID, &room.ActiveClientSocketsByUUID) {
+	//check no new sockets were added to room while we were checking original list. Room that is being migrated is removed by migration flow
+	if !room.IsFrozen && util.MapKeySetsAreEqual(roomActiveClientSocketsByUUID, &room.ActiveClientSocketsByUUID) {
 		room.ActiveRoomUsersLen = 0
 		room.IsDeleted = true
+
+		ActiveRoomStore.DeleteRoom(room)
+
 		room.Unlock()
 
 		ActiveRoomsByNameMap.Delete(room.Name)
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+// turns announcement mode on/off. Only room owner is allowed to do it
+func processRoomAnnouncementModeCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	if !isRoomOwner(room, clSocket.SessionUUID) {
+		room.Unlock()
+
+		util.LogWarn("failed to change announcement mode - user '%s' is not an owner of room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotPermitted, inFrame.RequestId)
+
+		return
+	}
+
+	if room.IsAnnouncementMode == inFrame.IsAnnouncementMode {
+		room.Unlock()
+
+		writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+		return
+	}
+
+	util.LogTrace("user '%s' is turning announcement mode '%t' for room '%s'", clSocket.SessionUUID, inFrame.IsAnnouncementMode, room.Name)
+
+	room.IsAnnouncementMode = inFrame.IsAnnouncementMode
+
+	ActiveRoomStore.SaveRoomInfo(room)
+
+	announcementModeDispatchingFrame := makeRoomAnnouncementModeFrame(room)
+
+	recordRoomEvent(room, announcementModeDispatchingFrame)
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	writeFrameToActiveRoomMembers(announcementModeDispatchingFrame, room, roomActiveClientSocketsByUUID)
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// in announcement mode only room owner and moderators can post messages (votes, reactions and whispers are still allowed to all).
+// Must be executed under room lock
+func canPostToRoom(room *domain_structures.Room, sessionUUID string) bool {
+	return !room.IsAnnouncementMode || isRoomOwnerOrModerator(room, sessionUUID)
+}
+
+// must be executed under room lock
+func makeRoomAnnouncementModeFrame(room *domain_structures.Room) *domain_structures.OutMessageFrame {
+	isAnnouncementMode := room.IsAnnouncementMode
+	createdAt := time.Now().UnixNano()
+
+	return &domain_structures.OutMessageFrame{
+		Command:            domain_structures.RoomAnnouncementMode,
+		CreatedAtNano:      &createdAt,
+		IsAnnouncementMode: &isAnnouncementMode,
+	}
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// max events kept per room. Users that missed more events than this get full room state on re-join
+const RoomEventLogLimit = 300
+
+// room event sequence starts from current time in microseconds (when room is created or loaded by backend), then grows by 1 with every event.
+// This keeps sequence growing across backend restarts and room migrations without persisting it, so user can never get
+// events of previous room 'incarnation' replayed. Microseconds (not nanos) keep values within JS safe integer range
+func initialRoomEventSeq() int64 {
+	return time.Now().UnixNano() / int64(time.Microsecond)
+}
+
+// assigns next sequence number to frame and puts it into room event log. Must be executed under room lock, before frame is sent
+func recordRoomEvent(room *domain_structures.Room, frame *domain_structures.OutMessageFrame) {
+	recordPrivateRoomEvent(room, frame, nil)
+}
+
+// same as recordRoomEvent, but event is replayed only to given users (nil - to everyone).
+// Other users just see a gap in sequence numbers. Must be executed under room lock, before frame is sent
+func recordPrivateRoomEvent(room *domain_structures.Room, frame *domain_structures.OutMessageFrame, audienceUserInRoomUUIDs []string) {
+	room.LastEventSeq++
+
+	eventSeq := room.LastEventSeq
+	frame.EventSeq = &eventSeq
+
+	room.EventLog = append(room.EventLog, &domain_structures.RoomEvent{
+		Seq:                     eventSeq,
+		Frame:                   frame,
+		AudienceUserInRoomUUIDs: audienceUserInRoomUUIDs,
+	})
+
+	if len(room.EventLog) > RoomEventLogLimit {
+		//drop oldest event, release reference to it
+		copy(room.EventLog, room.EventLog[1:])
+		room.EventLog[len(room.EventLog)-1] = nil
+		room.EventLog = room.EventLog[:len(room.EventLog)-1]
+	}
+}
+
+// returns events (that are worth replaying) user missed since lastSeenSeq. Second value is false if some missed events are
+// no longer in log (or seq is unknown to this room) - then full sync is required. Must be executed under room lock
+func findMissedRoomEvents(room *domain_structures.Room, lastSeenSeq int64, userInRoomUUID string) ([]*domain_structures.RoomEvent, bool) {
+	if lastSeenSeq > room.LastEventSeq {
+		return nil, false
+	}
+
+	if lastSeenSeq == room.LastEventSeq {
+		return []*domain_structures.RoomEvent{}, true
+	}
+
+	if len(room.EventLog) == 0 || room.EventLog[0].Seq > lastSeenSeq+1 {
+		return nil, false
+	}
+
+	missedEvents := make([]*domain_structures.RoomEvent, 0, room.LastEventSeq-lastSeenSeq)
+
+	for _, event := range room.EventLog {
+		if event.Seq > lastSeenSeq && isReplayableRoomEvent(event) && isRoomEventVisibleToUser(event, userInRoomUUID) {
+			missedEvents = append(missedEvents, event)
+		}
+	}
+
+	return missedEvents, true
+}
+
+// members list, description (together with settings, announcement and slow modes, edit policy) and pinned messages are sent in full to every joining user anyway, so replaying their older versions is pointless
+func isReplayableRoomEvent(event *domain_structures.RoomEvent) bool {
+	return event.Frame.Command != domain_structures.RoomMembersChanged &&
+		event.Frame.Command != domain_structures.RoomChangeDescription &&
+		event.Frame.Command != domain_structures.RoomChangeSettings &&
+		event.Frame.Command != domain_structures.RoomAnnouncementMode &&
+		event.Frame.Command != domain_structures.RoomSlowMode &&
+		event.Frame.Command != domain_structures.RoomMessageEditPolicy &&
+		event.Frame.Command != domain_structures.RoomPinnedMessages
+}
+
+func isRoomEventVisibleToUser(event *domain_structures.RoomEvent, userInRoomUUID string) bool {
+	return event.AudienceUserInRoomUUIDs == nil || util.ArrayContainsString(event.AudienceUserInRoomUUIDs, userInRoomUUID)
+}
@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const MentionMarker = '@'
+
+// mentions beyond this count are ignored - so single message can not ping whole room
+const MessageMentionsLimit = 10
+
+// finds '@name' mentions of room users in url-escaped message text. Names may contain spaces, so longest matching name wins.
+// Mentions are stored as user ids - renamed users stay mentioned in older messages.
+// Author, banned and technical users are never mentioned. Must be executed under room lock
+func resolveMessageMentionsNonLocking(room *domain_structures.Room, escapedText string, authorUserInRoomUUID string) []string {
+	text, err := url.QueryUnescape(escapedText)
+
+	if err != nil || strings.IndexRune(text, MentionMarker) < 0 || strings.HasPrefix(text, DrawingMessageMetaMarker) {
+		return nil
+	}
+
+	userNameByUserInRoomUUID := make(map[string]string)
+
+	for _, user := range room.AllRoomAuthorizedUsersBySessionUUID {
+		if user.UserInRoomUUID == authorUserInRoomUUID || user.UserInRoomUUID == ExternalUserUUID ||
+			room.BannedUserInRoomUUIDs[user.UserInRoomUUID] {
+			continue
+		}
+
+		userName, err := url.QueryUnescape(user.UserName)
+
+		if err == nil && userName != "" {
+			userNameByUserInRoomUUID[user.UserInRoomUUID] = userName
+		}
+	}
+
+	var mentionedUserInRoomUUIDs []string
+	seenUserInRoomUUIDs := make(map[string]bool)
+
+	for i, r := range text {
+		if r != MentionMarker || len(mentionedUserInRoomUUIDs) >= MessageMentionsLimit {
+			continue
+		}
+
+		//e-mails and the like are not mentions
+		if i > 0 {
+			runeBefore, _ := utf8.DecodeLastRuneInString(text[:i])
+
+			if isWordRune(runeBefore) {
+				continue
+			}
+		}
+
+		mentionedUserInRoomUUID := findMentionedUser(text[i+1:], userNameByUserInRoomUUID)
+
+		if mentionedUserInRoomUUID != "" && !seenUserInRoomUUIDs[mentionedUserInRoomUUID] {
+			seenUserInRoomUUIDs[mentionedUserInRoomUUID] = true
+			mentionedUserInRoomUUIDs = append(mentionedUserInRoomUUIDs, mentionedUserInRoomUUID)
+		}
+	}
+
+	return mentionedUserInRoomUUIDs
+}
+
+// returns id of user with longest name text starts with (case-insensitive, name must end at word boundary)
+func findMentionedUser(text string, userNameByUserInRoomUUID map[string]string) string {
+	mentionedUserInRoomUUID := ""
+	mentionedUserNameLen := 0
+
+	for userInRoomUUID, userName := range userNameByUserInRoomUUID {
+		if len(userName) <= mentionedUserNameLen || len(userName) > len(text) || !strings.EqualFold(text[:len(userName)], userName) {
+			continue
+		}
+
+		if len(userName) < len(text) {
+			runeAfter, _ := utf8.DecodeRuneInString(text[len(userName):])
+
+			if isWordRune(runeAfter) {
+				continue
+			}
+		}
+
+		mentionedUserInRoomUUID = userInRoomUUID
+		mentionedUserNameLen = len(userName)
+	}
+
+	return mentionedUserInRoomUUID
+}
+
+// users mentioned in new version of message, but not in previous one - only they are notified about edit
+func getNewlyMentionedUserInRoomUUIDs(previousMentionedUserInRoomUUIDs []string, mentionedUserInRoomUUIDs []string) []string {
+	var newlyMentionedUserInRoomUUIDs []string
+
+	for _, mentionedUserInRoomUUID := range mentionedUserInRoomUUIDs {
+		if !util.ArrayContainsString(previousMentionedUserInRoomUUIDs, mentionedUserInRoomUUID) {
+			newlyMentionedUserInRoomUUIDs = append(newlyMentionedUserInRoomUUIDs, mentionedUserInRoomUUID)
+		}
+	}
+
+	return newlyMentionedUserInRoomUUIDs
+}
+
+// sockets of given users (all their tabs/devices). Must be executed under room lock
+func copyMentionedUsersSocketMapNonLocking(room *domain_structures.Room, mentionedUserInRoomUUIDs []string) *map[string]*domain_structures.WebSocket {
+	mentionedUsersSocketsByUUID := make(map[string]*domain_structures.WebSocket)
+
+	if len(mentionedUserInRoomUUIDs) == 0 {
+		return &mentionedUsersSocketsByUUID
+	}
+
+	for socketUUID, userSocket := range room.ActiveClientSocketsByUUID {
+		socketUserInRoomUUID := room.ActiveRoomUserUUIDBySessionUUID[userSocket.SessionUUID]
+
+		if util.ArrayContainsString(mentionedUserInRoomUUIDs, socketUserInRoomUUID) {
+			mentionedUsersSocketsByUUID[socketUUID] = userSocket
+		}
+	}
+
+	return &mentionedUsersSocketsByUUID
+}
+
+// mention notification is sent regardless of user's presence - client alerts user even if room tab is in background.
+// It is not a room event - mentions of missed messages are part of those messages
+func writeMentionNotificationToSockets(
+	room *domain_structures.Room,
+	mentioningMessageDTO domain_structures.RoomMessageDTO,
+	mentionedUsersSocketsByUUID *map[string]*domain_structures.WebSocket,
+) {
+	if len(*mentionedUsersSocketsByUUID) == 0 {
+		return
+	}
+
+	createdAt := time.Now().UnixNano()
+
+	mentionDispatchingFrame := &domain_structures.OutMessageFrame{
+		Command:       domain_structures.NotifyMentioned,
+		CreatedAtNano: &createdAt,
+		Message:       &[]domain_structures.RoomMessageDTO{mentioningMessageDTO},
+	}
+
+	writeFrameToActiveRoomMembers(mentionDispatchingFrame, room, mentionedUsersSocketsByUUID)
+}
+
+func copyMentionedUserInRoomUUIDs(orig *domain_structures.RoomMessage) *[]string {
+	mentionedUserInRoomUUIDs := make([]string, len(orig.MentionedUserInRoomUUIDs))
+
+	copy(mentionedUserInRoomUUIDs, orig.MentionedUserInRoomUUIDs)
+
+	return &mentionedUserInRoomUUIDs
+}
@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// only latest revisions are kept - so frequently edited messages do not bloat room history
+const MessageRevisionsLimit = 5
+
+const RoomMessageEditsDisabled = -1
+const RoomMessageEditMaxWindowSec = 7 * 24 * 60 * 60
+
+// sets time after posting during which messages can be edited (zero - no limit, -1 - editing is disabled).
+// Only room owner is allowed to do it
+func processRoomMessageEditPolicyCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	messageEditWindowSec := inFrame.MessageEditWindowSec
+
+	if messageEditWindowSec < RoomMessageEditsDisabled || messageEditWindowSec > RoomMessageEditMaxWindowSec {
+		util.LogTrace("failed to change message edit policy - invalid edit window '%d'", messageEditWindowSec)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+
+		return
+	}
+
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	if !isRoomOwner(room, clSocket.SessionUUID) {
+		room.Unlock()
+
+		util.LogWarn("failed to change message edit policy - user '%s' is not an owner of room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotPermitted, inFrame.RequestId)
+
+		return
+	}
+
+	if room.MessageEditWindowSec == messageEditWindowSec {
+		room.Unlock()
+
+		writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+		return
+	}
+
+	util.LogTrace("user '%s' is setting message edit window '%d' for room '%s'", clSocket.SessionUUID, messageEditWindowSec, room.Name)
+
+	room.MessageEditWindowSec = messageEditWindowSec
+
+	ActiveRoomStore.SaveRoomInfo(room)
+
+	messageEditPolicyDispatchingFrame := makeRoomMessageEditPolicyFrame(room)
+
+	recordRoomEvent(room, messageEditPolicyDispatchingFrame)
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	writeFrameToActiveRoomMembers(messageEditPolicyDispatchingFrame, room, roomActiveClientSocketsByUUID)
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// checks whether message may still be edited by user according to room's edit policy. Room owner and moderators
+// are not affected by it. Must be executed under room lock
+func checkMessageEditAllowedNonLocking(
+	room *domain_structures.Room,
+	sessionUUID string,
+	message *domain_structures.RoomMessage,
+) (domain_structures.WsError, bool) {
+	if room.MessageEditWindowSec == 0 || isRoomOwnerOrModerator(room, sessionUUID) {
+		return domain_structures.WsError{}, true
+	}
+
+	if room.MessageEditWindowSec == RoomMessageEditsDisabled {
+		return domain_structures.WsRoomMessageEditDisabled, false
+	}
+
+	if time.Now().Unix()-message.CreatedAtSec > int64(room.MessageEditWindowSec) {
+		return domain_structures.WsRoomMessageEditWindowExpired, false
+	}
+
+	return domain_structures.WsError{}, true
+}
+
+// returns previous versions of message text. Whisper revisions are visible to whisper participants only
+func processMessageRevisionsCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, true)
+
+	if room == nil {
+		return
+	}
+
+	userInRoomUUID := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
+
+	message, messageFound := findRoomOrWhisperMessage(room, inFrame.Message.Id)
+
+	if !messageFound || (message.RecipientUserInRoomUUID != "" &&
+		message.UserInRoomUUID != userInRoomUUID && message.RecipientUserInRoomUUID != userInRoomUUID) {
+		room.Unlock()
+
+		util.LogTrace("failed to get revisions - message '%d' not found in room '%s'", inFrame.Message.Id, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+
+		return
+	}
+
+	messageDTO := copyMessageAsDTO(message)
+	messageRevisionsCopy := copyMessageRevisions(message)
+
+	room.Unlock()
+
+	createdAt := time.Now().UnixNano()
+
+	writeFrameToSocket(clSocket, &domain_structures.OutMessageFrame{
+		Command:          domain_structures.TextMessageRevisions,
+		RequestId:        inFrame.RequestId,
+		CreatedAtNano:    &createdAt,
+		Message:          &[]domain_structures.RoomMessageDTO{messageDTO},
+		MessageRevisions: &messageRevisionsCopy,
+	})
+}
+
+// keeps current message text as revision, dropping oldest ones over limit. Revisions list is never changed in place -
+// room snapshots may share it. Must be executed under room lock
+func addMessageRevisionNonLocking(message *domain_structures.RoomMessage) {
+	revisionCreatedAt := time.Unix(message.CreatedAtSec, 0).UnixNano()
+
+	if message.LastEditedAt != nil {
+		revisionCreatedAt = *message.LastEditedAt
+	}
+
+	keptRevisions := message.Revisions
+
+	if len(keptRevisions) >= MessageRevisionsLimit {
+		keptRevisions = keptRevisions[len(keptRevisions)-MessageRevisionsLimit+1:]
+	}
+
+	revisions := make([]domain_structures.RoomMessageRevision, 0, len(keptRevisions)+1)
+	revisions = append(revisions, keptRevisions...)
+	revisions = append(revisions, domain_structures.RoomMessageRevision{
+		Text:      message.Text,
+		CreatedAt: revisionCreatedAt,
+	})
+
+	message.Revisions = revisions
+	message.RevisionsCount++
+}
+
+func copyMessageRevisions(orig *domain_structures.RoomMessage) []domain_structures.RoomMessageRevision {
+	messageRevisions := make([]domain_structures.RoomMessageRevision, len(orig.Revisions))
+
+	copy(messageRevisions, orig.Revisions)
+
+	return messageRevisions
+}
+
+// must be executed under room lock
+func makeRoomMessageEditPolicyFrame(room *domain_structures.Room) *domain_structures.OutMessageFrame {
+	messageEditWindowSec := room.MessageEditWindowSec
+	createdAt := time.Now().UnixNano()
+
+	return &domain_structures.OutMessageFrame{
+		Command:              domain_structures.RoomMessageEditPolicy,
+		CreatedAtNano:        &createdAt,
+		MessageEditWindowSec: &messageEditWindowSec,
+	}
+}
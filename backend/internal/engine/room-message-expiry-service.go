@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// longer TTL requested by client is cut to this value
+const MessageMaxTtlSec = int64(7 * 24 * 60 * 60)
+
+// expiry is postponed by this delay while room is being migrated
+const MessageExpiryFrozenRoomRetryDelay = 5 * time.Second
+
+// makes message self-destructing if positive ttl is given
+func setMessageTtl(message *domain_structures.RoomMessage, ttlSec int64) {
+	if ttlSec <= 0 {
+		return
+	}
+
+	if ttlSec > MessageMaxTtlSec {
+		ttlSec = MessageMaxTtlSec
+	}
+
+	message.TtlSec = ttlSec
+	message.ExpiresAtSec = message.CreatedAtSec + ttlSec
+}
+
+// makes sure expiry timer fires no later than given message expires. Must be executed under room lock
+func scheduleMessageExpiryNonLocking(room *domain_structures.Room, message *domain_structures.RoomMessage) {
+	if message.ExpiresAtSec <= 0 {
+		return
+	}
+
+	if room.MessageExpiryTimer != nil && room.NextMessageExpiryAtSec <= message.ExpiresAtSec {
+		return
+	}
+
+	startMessageExpiryTimerNonLocking(room, message.ExpiresAtSec)
+}
+
+// (re)starts expiry timer for earliest expiring message, stops it if room has none. Must be executed under room lock
+func rescheduleRoomMessagesExpiryNonLocking(room *domain_structures.Room) {
+	nextMessageExpiryAtSec := int64(0)
+
+	for _, messages := range []map[int64]*domain_structures.RoomMessage{room.RoomMessages, room.WhisperMessages} {
+		for _, message := range messages {
+			if message.ExpiresAtSec > 0 && (nextMessageExpiryAtSec == 0 || message.ExpiresAtSec < nextMessageExpiryAtSec) {
+				nextMessageExpiryAtSec = message.ExpiresAtSec
+			}
+		}
+	}
+
+	if nextMessageExpiryAtSec == 0 {
+		if room.MessageExpiryTimer != nil {
+			room.MessageExpiryTimer.Stop()
+			room.MessageExpiryTimer = nil
+		}
+
+		return
+	}
+
+	startMessageExpiryTimerNonLocking(room, nextMessageExpiryAtSec)
+}
+
+// must be executed under room lock
+func startMessageExpiryTimerNonLocking(room *domain_structures.Room, expiresAtSec int64) {
+	if room.MessageExpiryTimer != nil {
+		room.MessageExpiryTimer.Stop()
+	}
+
+	room.NextMessageExpiryAtSec = expiresAtSec
+	room.MessageExpiryTimer = time.AfterFunc(time.Until(time.Unix(expiresAtSec, 0)), func() {
+		expireRoomMessages(room)
+	})
+}
+
+// deletes self-destructing messages that expired or were read by all current room members, tells message audience to drop them
+func expireRoomMessages(room *domain_structures.Room) {
+	room.Lock()
+
+	if room.IsDeleted {
+		room.Unlock()
+
+		return
+	}
+
+	//changes are not allowed during migration - check again later (room is deleted here if migration succeeds)
+	if room.IsFrozen {
+		room.MessageExpiryTimer = time.AfterFunc(MessageExpiryFrozenRoomRetryDelay, func() {
+			expireRoomMessages(room)
+		})
+		room.Unlock()
+
+		return
+	}
+
+	now := time.Now().Unix()
+
+	var expiredMessages []*domain_structures.RoomMessage
+
+	for _, messages := range []map[int64]*domain_structures.RoomMessage{room.RoomMessages, room.WhisperMessages} {
+		for _, message := range messages {
+			if message.ExpiresAtSec > 0 && (message.ExpiresAtSec <= now || isMessageReadByAllNonLocking(room, message)) {
+				expiredMessages = append(expiredMessages, message)
+			}
+		}
+	}
+
+	if len(expiredMessages) == 0 {
+		rescheduleRoomMessagesExpiryNonLocking(room)
+		room.Unlock()
+
+		return
+	}
+
+	expiredMessageIds := make([]int64, 0, len(expiredMessages))
+
+	for _, expiredMessage := range expiredMessages {
+		expiredMessageIds = append(expiredMessageIds, expiredMessage.Id)
+	}
+
+	ActiveRoomStore.DeleteMessages(room, expiredMessageIds)
+
+	//frames and their audiences are prepared under lock, sent after unlock
+	messageDeleteDispatchingFrames := make([]*domain_structures.OutMessageFrame, 0, len(expiredMessages))
+	messageAudiencesSocketsByUUID := make([]*map[string]*domain_structures.WebSocket, 0, len(expiredMessages))
+	pinsChanged := false
+
+	for _, expiredMessage := range expiredMessages {
+		expiredMessageId := expiredMessage.Id
+
+		messageDeleteDispatchingFrame := &domain_structures.OutMessageFrame{
+			Command: domain_structures.TextMessageDelete,
+			Message: &[]domain_structures.RoomMessageDTO{{Id: &expiredMessageId}},
+		}
+
+		recordMessageEvent(room, expiredMessage, messageDeleteDispatchingFrame)
+
+		messageDeleteDispatchingFrames = append(messageDeleteDispatchingFrames, messageDeleteDispatchingFrame)
+		messageAudiencesSocketsByUUID = append(messageAudiencesSocketsByUUID, copyMessageAudienceSocketMapNonLocking(room, expiredMessage))
+
+		if unpinMessageNonLocking(room, expiredMessageId) {
+			pinsChanged = true
+		}
+	}
+
+	var pinnedMessagesDispatchingFrame *domain_structures.OutMessageFrame = nil
+
+	if pinsChanged {
+		ActiveRoomStore.SaveRoomInfo(room)
+
+		pinnedMessagesDispatchingFrame = makePinnedMessagesFrame(room)
+
+		recordRoomEvent(room, pinnedMessagesDispatchingFrame)
+	}
+
+	rescheduleRoomMessagesExpiryNonLocking(room)
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	util.LogTrace("deleted '%d' expired messages in room '%s' / '%s'", len(expiredMessages), room.Id, room.Name)
+
+	for i, messageDeleteDispatchingFrame := range messageDeleteDispatchingFrames {
+		writeFrameToActiveRoomMembers(messageDeleteDispatchingFrame, room, messageAudiencesSocketsByUUID[i])
+	}
+
+	if pinnedMessagesDispatchingFrame != nil {
+		writeFrameToActiveRoomMembers(pinnedMessagesDispatchingFrame, room, roomActiveClientSocketsByUUID)
+	}
+}
+
+// message is read by all if every user (except author) who can see it and is in room now has read it.
+// Whisper is read once its recipient has read it. Must be executed under room lock
+func isMessageReadByAllNonLocking(room *domain_structures.Room, message *domain_structures.RoomMessage) bool {
+	if message.RecipientUserInRoomUUID != "" {
+		recipientUser, found := room.AllRoomAuthorizedUsersBySessionUUID[findSessionUUIDByUserInRoomUUID(room, message.RecipientUserInRoomUUID)]
+
+		return found && recipientUser.LastReadMessageId >= message.Id
+	}
+
+	readersCount := 0
+
+	for sessionUUID, userInRoomUUID := range room.ActiveRoomUserUUIDBySessionUUID {
+		if userInRoomUUID == message.UserInRoomUUID {
+			continue
+		}
+
+		roomUser, found := room.AllRoomAuthorizedUsersBySessionUUID[sessionUUID]
+
+		if !found || roomUser.LastReadMessageId < message.Id {
+			return false
+		}
+
+		readersCount++
+	}
+
+	//nobody else is in room - message waits for its ttl
+	return readersCount > 0
+}
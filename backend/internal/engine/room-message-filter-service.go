@@ -0,0 +1,409 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"instantchat.rooms/instantchat/backend/internal/config"
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const MessageFilterTypeLength = "length"
+const MessageFilterTypeControlChars = "control_chars"
+const MessageFilterTypeProfanity = "profanity"
+const MessageFilterTypeLinks = "links"
+
+// what filter does with message that matched it
+const MessageFilterActionMask = "mask"
+const MessageFilterActionFlag = "flag"
+const MessageFilterActionReject = "reject"
+
+const MessageFilterLinkMask = "[link removed]"
+
+var UnknownMessageFilterType = errors.New("unknown message filter type")
+var UnknownMessageFilterAction = errors.New("unknown message filter action")
+
+/* Variables */
+
+var linkRegexp = regexp.MustCompile(`(?i)\b(?:(?:https?|ftp)://|www\.)[^\s<>"]+`)
+
+// every message is run through this chain (in this order) before it is stored (may be overridden by app config)
+var MessageFilters = []MessageFilter{
+	&ControlCharsMessageFilter{},
+	&LengthMessageFilter{},
+}
+
+// outcome of filtering single message. Text is passed to next filter in chain
+type MessageFilterResult struct {
+	Text         string
+	IsFlagged    bool                       //message is stored, but marked as suspicious for room members
+	RejectError  *domain_structures.WsError //non-nil - message must not be stored
+	RejectReason string
+}
+
+// filters see decoded (not url-escaped) message text. Must be safe for concurrent use
+type MessageFilter interface {
+	Name() string
+	Apply(room *domain_structures.Room, text string) MessageFilterResult
+}
+
+// rejects empty messages and ones longer than room's max message length
+type LengthMessageFilter struct{}
+
+func (f *LengthMessageFilter) Name() string {
+	return MessageFilterTypeLength
+}
+
+func (f *LengthMessageFilter) Apply(room *domain_structures.Room, text string) MessageFilterResult {
+	if strings.TrimSpace(text) == "" {
+		return rejectMessage(text, domain_structures.WsInvalidInput, "message is empty")
+	}
+
+	if len([]rune(text)) > room.Settings.MaxMessageLength {
+		return rejectMessage(text, domain_structures.WsRoomMessageTooLargeError, "message is too long")
+	}
+
+	return MessageFilterResult{Text: text}
+}
+
+// strips unicode control and invisible formatting characters (e.g. text direction overrides). Line breaks, tabs
+// and characters emoji sequences are built of are kept
+type ControlCharsMessageFilter struct{}
+
+func (f *ControlCharsMessageFilter) Name() string {
+	return MessageFilterTypeControlChars
+}
+
+func (f *ControlCharsMessageFilter) Apply(room *domain_structures.Room, text string) MessageFilterResult {
+	return MessageFilterResult{Text: strings.Map(func(r rune) rune {
+		if isStrippedControlChar(r) {
+			return -1
+		}
+
+		return r
+	}, text)}
+}
+
+func isStrippedControlChar(r rune) bool {
+	switch {
+	case r == '\n' || r == '\r' || r == '\t':
+		return false
+	case r == '\u200c' || r == '\u200d': //zero width (non-)joiner
+		return false
+	case r >= 0xE0020 && r <= 0xE007F: //tag characters of flag emojis
+		return false
+	}
+
+	return unicode.IsControl(r) || unicode.In(r, unicode.Cf)
+}
+
+// matches configured words (whole words, case-insensitive) and regex patterns
+type ProfanityMessageFilter struct {
+	Action       string
+	WordPatterns []*regexp.Regexp
+	Patterns     []*regexp.Regexp
+}
+
+func (f *ProfanityMessageFilter) Name() string {
+	return MessageFilterTypeProfanity
+}
+
+func (f *ProfanityMessageFilter) Apply(room *domain_structures.Room, text string) MessageFilterResult {
+	var matchedRanges [][]int
+
+	for _, wordPattern := range f.WordPatterns {
+		for _, matchedRange := range wordPattern.FindAllStringIndex(text, -1) {
+			if isWholeWordMatch(text, matchedRange) {
+				matchedRanges = append(matchedRanges, matchedRange)
+			}
+		}
+	}
+
+	for _, pattern := range f.Patterns {
+		matchedRanges = append(matchedRanges, pattern.FindAllStringIndex(text, -1)...)
+	}
+
+	if f.Action == MessageFilterActionMask {
+		text = maskTextRanges(text, matchedRanges)
+	}
+
+	return applyMessageFilterAction(f.Action, len(matchedRanges) > 0, text, "message contains forbidden words")
+}
+
+// regexp '\b' knows ASCII letters only, so word boundaries are checked separately
+func isWholeWordMatch(text string, matchedRange []int) bool {
+	if matchedRange[0] > 0 {
+		runeBefore, _ := utf8.DecodeLastRuneInString(text[:matchedRange[0]])
+
+		if isWordRune(runeBefore) {
+			return false
+		}
+	}
+
+	if matchedRange[1] < len(text) {
+		runeAfter, _ := utf8.DecodeRuneInString(text[matchedRange[1]:])
+
+		if isWordRune(runeAfter) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// replaces every rune within given byte ranges (may overlap) with '*'
+func maskTextRanges(text string, ranges [][]int) string {
+	if len(ranges) == 0 {
+		return text
+	}
+
+	var sb strings.Builder
+
+	for i, r := range text {
+		masked := false
+
+		for _, maskedRange := range ranges {
+			if i >= maskedRange[0] && i < maskedRange[1] {
+				masked = true
+
+				break
+			}
+		}
+
+		if masked {
+			sb.WriteRune('*')
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+
+	return sb.String()
+}
+
+// matches links, except ones pointing to allowed domains (or their subdomains)
+type LinksMessageFilter struct {
+	Action         string
+	AllowedDomains []string
+}
+
+func (f *LinksMessageFilter) Name() string {
+	return MessageFilterTypeLinks
+}
+
+func (f *LinksMessageFilter) Apply(room *domain_structures.Room, text string) MessageFilterResult {
+	matched := false
+
+	filteredText := linkRegexp.ReplaceAllStringFunc(text, func(link string) string {
+		if f.isLinkAllowed(link) {
+			return link
+		}
+
+		matched = true
+
+		return MessageFilterLinkMask
+	})
+
+	if f.Action == MessageFilterActionMask {
+		text = filteredText
+	}
+
+	return applyMessageFilterAction(f.Action, matched, text, "message contains links")
+}
+
+func (f *LinksMessageFilter) isLinkAllowed(link string) bool {
+	if strings.HasPrefix(strings.ToLower(link), "www.") {
+		link = "http://" + link
+	}
+
+	parsedLink, err := url.Parse(link)
+
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(parsedLink.Hostname())
+
+	for _, allowedDomain := range f.AllowedDomains {
+		if host == allowedDomain || strings.HasSuffix(host, "."+allowedDomain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// builds filter chain from app config. Length filter is mandatory (room settings rely on it) - it is added first if missing
+func MakeMessageFilters(filterConfigs []config.MessageFilter) ([]MessageFilter, error) {
+	var messageFilters []MessageFilter
+	hasLengthFilter := false
+
+	for _, filterConfig := range filterConfigs {
+		messageFilter, err := makeMessageFilter(filterConfig)
+
+		if err != nil {
+			return nil, fmt.Errorf("message filter '%s': %w", filterConfig.Type, err)
+		}
+
+		if messageFilter.Name() == MessageFilterTypeLength {
+			hasLengthFilter = true
+		}
+
+		messageFilters = append(messageFilters, messageFilter)
+	}
+
+	if !hasLengthFilter {
+		messageFilters = append([]MessageFilter{&LengthMessageFilter{}}, messageFilters...)
+	}
+
+	return messageFilters, nil
+}
+
+func makeMessageFilter(filterConfig config.MessageFilter) (MessageFilter, error) {
+	switch filterConfig.Type {
+	case MessageFilterTypeLength:
+		return &LengthMessageFilter{}, nil
+
+	case MessageFilterTypeControlChars:
+		return &ControlCharsMessageFilter{}, nil
+
+	case MessageFilterTypeProfanity:
+		if !isValidMessageFilterAction(filterConfig.Action) {
+			return nil, UnknownMessageFilterAction
+		}
+
+		var wordPatterns []*regexp.Regexp
+		var patterns []*regexp.Regexp
+
+		for _, word := range filterConfig.Words {
+			word = strings.TrimSpace(word)
+
+			if word != "" {
+				wordPatterns = append(wordPatterns, regexp.MustCompile("(?i)"+regexp.QuoteMeta(word)))
+			}
+		}
+
+		for _, patternStr := range filterConfig.Patterns {
+			pattern, err := regexp.Compile(patternStr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			patterns = append(patterns, pattern)
+		}
+
+		return &ProfanityMessageFilter{Action: filterConfig.Action, WordPatterns: wordPatterns, Patterns: patterns}, nil
+
+	case MessageFilterTypeLinks:
+		if !isValidMessageFilterAction(filterConfig.Action) {
+			return nil, UnknownMessageFilterAction
+		}
+
+		var allowedDomains []string
+
+		for _, allowedDomain := range filterConfig.AllowedDomains {
+			allowedDomains = append(allowedDomains, strings.ToLower(strings.TrimSpace(allowedDomain)))
+		}
+
+		return &LinksMessageFilter{Action: filterConfig.Action, AllowedDomains: allowedDomains}, nil
+	}
+
+	return nil, UnknownMessageFilterType
+}
+
+func isValidMessageFilterAction(action string) bool {
+	return action == MessageFilterActionMask || action == MessageFilterActionFlag || action == MessageFilterActionReject
+}
+
+// runs url-escaped message text through filter chain. Returned text is url-escaped as well.
+// Must be executed under room lock
+func filterMessageText(room *domain_structures.Room, escapedText string) MessageFilterResult {
+	text, err := url.QueryUnescape(escapedText)
+
+	if err != nil {
+		return rejectMessage(escapedText, domain_structures.WsInvalidInput, "message is not properly escaped")
+	}
+
+	result := MessageFilterResult{Text: text}
+
+	for _, messageFilter := range MessageFilters {
+		filterResult := messageFilter.Apply(room, result.Text)
+
+		if filterResult.RejectError != nil {
+			util.LogTrace("message for room '%s' is rejected by '%s' filter: %s", room.Name, messageFilter.Name(), filterResult.RejectReason)
+
+			return filterResult
+		}
+
+		if filterResult.IsFlagged {
+			util.LogTrace("message for room '%s' is flagged by '%s' filter", room.Name, messageFilter.Name())
+		}
+
+		result.Text = filterResult.Text
+		result.IsFlagged = result.IsFlagged || filterResult.IsFlagged
+	}
+
+	if result.Text == text {
+		//untouched messages are stored exactly as they came
+		result.Text = escapedText
+	} else {
+		result.Text = escapeClientText(result.Text)
+	}
+
+	return result
+}
+
+// escapes text the way clients do before sending it. Spaces are escaped as '%20' - clients decode with decodeURIComponent
+func escapeClientText(text string) string {
+	return strings.ReplaceAll(url.QueryEscape(text), "+", "%20")
+}
+
+func applyMessageFilterAction(action string, matched bool, text string, rejectReason string) MessageFilterResult {
+	if !matched {
+		return MessageFilterResult{Text: text}
+	}
+
+	switch action {
+	case MessageFilterActionReject:
+		return rejectMessage(text, domain_structures.WsRoomMessageRejected, rejectReason)
+
+	case MessageFilterActionFlag:
+		return MessageFilterResult{Text: text, IsFlagged: true}
+	}
+
+	return MessageFilterResult{Text: text}
+}
+
+func rejectMessage(text string, rejectError domain_structures.WsError, rejectReason string) MessageFilterResult {
+	return MessageFilterResult{Text: text, RejectError: &rejectError, RejectReason: rejectReason}
+}
+
+// writes error to socket if message is rejected. Must be executed under room lock
+func filterMessageTextForSocket(
+	room *domain_structures.Room,
+	clSocket *domain_structures.WebSocket,
+	escapedText string,
+	requestId *string,
+) (MessageFilterResult, bool) {
+	result := filterMessageText(room, escapedText)
+
+	if result.RejectError != nil {
+		writeErrorMessageWithDetailsToSocket(clSocket, *result.RejectError, requestId, &result.RejectReason)
+
+		return result, false
+	}
+
+	return result, true
+}
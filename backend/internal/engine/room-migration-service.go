@@ -0,0 +1,249 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// time given to clients to reconnect by themselves after 'room moved' command, before their sockets are closed
+const MovedRoomSocketsCloseDelay = 5 * time.Second
+
+// frozen room is unfrozen after this time if migration is neither completed nor cancelled
+const MigrationFreezeTimeout = 5 * time.Minute
+
+var MigrationRoomNotFound = errors.New("room not found")
+var MigrationRoomNotFrozen = errors.New("room is not frozen for migration")
+var MigrationRoomAlreadyExists = errors.New("room with same name already exists")
+var MigrationBadSnapshot = errors.New("migration snapshot must contain exactly one room")
+var MigrationBackendDraining = errors.New("backend is draining and does not accept new rooms")
+var MigrationRoomAlreadyMigrated = errors.New("room is already imported by target backend")
+
+/*
+Room migration flow (driven by aux-srv):
+1. source backend: ExportRoomForMigration - room gets frozen (all changes rejected), its full state is returned
+2. target backend: ImportMigratedRoom - room is registered from exported state
+3. source backend: MarkRoomMigrated - room can not be unfrozen anymore, so two copies of it never diverge
+4. aux-srv points room name to target backend
+5. source backend: ReleaseMigratedRoom - room is removed, connected users are told to reconnect to target backend
+On import failure - aux-srv calls UnfreezeRoom on source backend. If aux-srv never does - room unfreezes by timeout
+(unless it is marked as migrated)
+*/
+
+// freezes room and returns its state serialized in snapshot format (single-room snapshot)
+func ExportRoomForMigration(roomName string) ([]byte, error) {
+	room := ActiveRoomsByNameMap.Get(roomName)
+
+	if room == nil {
+		return nil, MigrationRoomNotFound
+	}
+
+	room.Lock()
+
+	if room.IsDeleted {
+		room.Unlock()
+
+		return nil, MigrationRoomNotFound
+	}
+
+	if room.IsMigrated {
+		room.Unlock()
+
+		return nil, MigrationRoomAlreadyMigrated
+	}
+
+	room.IsFrozen = true
+	room.FrozenUntil = time.Now().Add(MigrationFreezeTimeout).UnixNano()
+
+	roomSnapshot := makeRoomSnapshot(room)
+
+	room.Unlock()
+
+	time.AfterFunc(MigrationFreezeTimeout, func() {
+		unfreezeRoomOnTimeout(room)
+	})
+
+	util.LogInfo("room '%s' / '%s' frozen and exported for migration", room.Id, room.Name)
+
+	return json.Marshal(domain_structures.RoomsSnapshot{
+		Version:   RoomsSnapshotFormatVersion,
+		CreatedAt: time.Now().UnixNano(),
+		Rooms:     []domain_structures.RoomSnapshot{roomSnapshot},
+	})
+}
+
+// registers room exported by other backend instance
+func ImportMigratedRoom(snapshotJson []byte) error {
+	if IsDraining() {
+		return MigrationBackendDraining
+	}
+
+	var roomsSnapshot domain_structures.RoomsSnapshot
+
+	err := json.Unmarshal(snapshotJson, &roomsSnapshot)
+
+	if err != nil {
+		return err
+	}
+
+	if roomsSnapshot.Version != RoomsSnapshotFormatVersion {
+		return fmt.Errorf("%w: got '%d', expected '%d'", RoomsSnapshotVersionMismatch, roomsSnapshot.Version, RoomsSnapshotFormatVersion)
+	}
+
+	if len(roomsSnapshot.Rooms) != 1 {
+		return MigrationBadSnapshot
+	}
+
+	room := restoreRoomFromSnapshot(&roomsSnapshot.Rooms[0])
+
+	if !registerRestoredRoom(room) {
+		return MigrationRoomAlreadyExists
+	}
+
+	room.Lock()
+	ActiveRoomStore.SaveRoom(room)
+	room.Unlock()
+
+	util.LogInfo("imported migrated room '%s' / '%s' with '%d' messages", room.Id, room.Name, room.RoomMessagesLen)
+
+	return nil
+}
+
+// room imported by target backend stays frozen here until it is released - neither timeout nor UnfreezeRoom bring it back
+func MarkRoomMigrated(roomName string) error {
+	room := ActiveRoomsByNameMap.Get(roomName)
+
+	if room == nil {
+		return MigrationRoomNotFound
+	}
+
+	room.Lock()
+
+	if room.IsDeleted {
+		room.Unlock()
+
+		return MigrationRoomNotFound
+	}
+
+	if !room.IsFrozen {
+		room.Unlock()
+
+		return MigrationRoomNotFrozen
+	}
+
+	room.IsMigrated = true
+	room.Unlock()
+
+	util.LogInfo("room '%s' / '%s' marked as migrated", room.Id, room.Name)
+
+	return nil
+}
+
+// removes frozen room from this backend and tells its active users to reconnect to target backend
+func ReleaseMigratedRoom(roomName string, targetBackendInstanceAddr string) error {
+	room := ActiveRoomsByNameMap.Get(roomName)
+
+	if room == nil {
+		return MigrationRoomNotFound
+	}
+
+	room.Lock()
+
+	if room.IsDeleted {
+		room.Unlock()
+
+		return MigrationRoomNotFound
+	}
+
+	if !room.IsFrozen {
+		room.Unlock()
+
+		return MigrationRoomNotFrozen
+	}
+
+	room.ActiveRoomUsersLen = 0
+	room.IsDeleted = true
+
+	ActiveRoomStore.DeleteRoom(room)
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	ActiveRoomsByNameMap.Delete(room.Name)
+	RoomsOnlineGauge.Dec()
+
+	util.LogInfo("room '%s' / '%s' moved to backend '%s', notifying '%d' sockets",
+		room.Id, room.Name, targetBackendInstanceAddr, len(*roomActiveClientSocketsByUUID))
+
+	createdAt := time.Now().UnixNano()
+
+	roomMovedDispatchingFrame := &domain_structures.OutMessageFrame{
+		Command:             domain_structures.RoomMoved,
+		CreatedAtNano:       &createdAt,
+		BackendInstanceAddr: &targetBackendInstanceAddr,
+	}
+
+	writeFrameToActiveRoomMembers(roomMovedDispatchingFrame, room, roomActiveClientSocketsByUUID)
+
+	//clients that did not reconnect by themselves will do it once their socket is closed
+	time.AfterFunc(MovedRoomSocketsCloseDelay, func() {
+		for _, clSocket := range *roomActiveClientSocketsByUUID {
+			clSocket.Terminate()
+		}
+	})
+
+	return nil
+}
+
+// cancels migration - room continues to work on this backend
+func UnfreezeRoom(roomName string) error {
+	room := ActiveRoomsByNameMap.Get(roomName)
+
+	if room == nil {
+		return MigrationRoomNotFound
+	}
+
+	room.Lock()
+
+	if room.IsDeleted {
+		room.Unlock()
+
+		return MigrationRoomNotFound
+	}
+
+	if room.IsMigrated {
+		room.Unlock()
+
+		return MigrationRoomAlreadyMigrated
+	}
+
+	room.IsFrozen = false
+	room.Unlock()
+
+	util.LogInfo("room '%s' / '%s' unfrozen", room.Id, room.Name)
+
+	return nil
+}
+
+// room exported again in the meantime got later deadline - only its own timer unfreezes it
+func unfreezeRoomOnTimeout(room *domain_structures.Room) {
+	room.Lock()
+
+	if room.IsDeleted || !room.IsFrozen || room.IsMigrated || time.Now().UnixNano() < room.FrozenUntil {
+		room.Unlock()
+
+		return
+	}
+
+	room.IsFrozen = false
+	room.Unlock()
+
+	util.LogWarn("room '%s' / '%s' unfrozen - migration was not completed in '%s'", room.Id, room.Name, MigrationFreezeTimeout)
+}
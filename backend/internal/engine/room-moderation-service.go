@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// kicked user's socket is closed under room lock, so error message delivery must not take long
+const ModerationErrorWriteTimeout = 2 * time.Second
+
+// processes kick/ban/unban/banned-list commands. Only room owner and moderators are allowed to moderate room
+func processRoomModerationCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, inFrame.Command == domain_structures.RoomBannedUsers)
+
+	if room == nil {
+		return
+	}
+
+	if !isRoomOwnerOrModerator(room, clSocket.SessionUUID) {
+		room.Unlock()
+
+		util.LogWarn("failed to process '%s' - user '%s' is not an owner or moderator of room '%s'", inFrame.Command, clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotPermitted, inFrame.RequestId)
+
+		return
+	}
+
+	targetUserInRoomUUID := inFrame.TargetUserInRoomUUID
+
+	switch inFrame.Command {
+	case domain_structures.RoomBannedUsers:
+		bannedUsersFrame := makeBannedUsersFrame(room, inFrame.RequestId)
+
+		room.Unlock()
+
+		writeFrameToSocket(clSocket, bannedUsersFrame)
+
+	case domain_structures.RoomUnbanUser:
+		if !room.BannedUserInRoomUUIDs[targetUserInRoomUUID] {
+			room.Unlock()
+
+			util.LogTrace("failed to unban user '%s' - user is not banned in room '%s'", targetUserInRoomUUID, room.Name)
+			writeErrorMessageToSocket(clSocket, domain_structures.WsRoomUserNotFound, inFrame.RequestId)
+
+			return
+		}
+
+		delete(room.BannedUserInRoomUUIDs, targetUserInRoomUUID)
+
+		ActiveRoomStore.SaveRoomInfo(room)
+
+		bannedUsersFrame := makeBannedUsersFrame(room, nil)
+
+		room.Unlock()
+
+		util.LogInfo("user '%s' unbanned in room '%s' / '%s'", targetUserInRoomUUID, room.Id, room.Name)
+
+		writeFrameToSocket(clSocket, bannedUsersFrame)
+		writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+	case domain_structures.RoomKickUser, domain_structures.RoomBanUser:
+		targetSessionUUID := findSessionUUIDByUserInRoomUUID(room, targetUserInRoomUUID)
+
+		if targetSessionUUID == "" {
+			room.Unlock()
+
+			util.LogTrace("failed to process '%s' - user '%s' not found in room '%s'", inFrame.Command, targetUserInRoomUUID, room.Name)
+			writeErrorMessageToSocket(clSocket, domain_structures.WsRoomUserNotFound, inFrame.RequestId)
+
+			return
+		}
+
+		//owner and technical users can not be removed, moderators can be removed only by owner
+		if targetSessionUUID == room.CreatedBySessionUUID || targetSessionUUID == ExternalUserSessionUUID ||
+			(room.ModeratorUserInRoomUUIDs[targetUserInRoomUUID] && !isRoomOwner(room, clSocket.SessionUUID)) {
+			room.Unlock()
+
+			util.LogWarn("failed to process '%s' - user '%s' can not be removed from room '%s'", inFrame.Command, targetUserInRoomUUID, room.Name)
+			writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotPermitted, inFrame.RequestId)
+
+			return
+		}
+
+		var bannedUsersFrame *domain_structures.OutMessageFrame = nil
+		wasActive := false
+
+		if inFrame.Command == domain_structures.RoomBanUser {
+			room.BannedUserInRoomUUIDs[targetUserInRoomUUID] = true
+			delete(room.ModeratorUserInRoomUUIDs, targetUserInRoomUUID)
+
+			ActiveRoomStore.SaveRoomInfo(room)
+
+			bannedUsersFrame = makeBannedUsersFrame(room, nil)
+
+			wasActive = removeUserFromRoomNonLocking(room, targetSessionUUID, domain_structures.WsRoomUserBanned)
+		} else {
+			wasActive = removeUserFromRoomNonLocking(room, targetSessionUUID, domain_structures.WsRoomUserKicked)
+
+			if !wasActive {
+				room.Unlock()
+
+				util.LogTrace("failed to kick user '%s' - user is not active in room '%s'", targetUserInRoomUUID, room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomUserNotFound, inFrame.RequestId)
+
+				return
+			}
+		}
+
+		room.Unlock()
+
+		util.LogInfo("'%s' applied to user '%s' in room '%s' / '%s'", inFrame.Command, targetUserInRoomUUID, room.Id, room.Name)
+
+		if wasActive {
+			go writeMembersListChangedFrameToActiveRoomMembers(room, nil)
+		}
+
+		if bannedUsersFrame != nil {
+			writeFrameToSocket(clSocket, bannedUsersFrame)
+		}
+
+		writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+	}
+}
+
+// returns locked room if user is active in it and room accepts changes (or allowFrozen is set), otherwise writes error and returns nil.
+// Common preamble for commands that are processed outside of WsEntry
+func lockRoomForActiveUserCommand(
+	clSocket *domain_structures.WebSocket,
+	inFrame *domain_structures.InMessageFrame,
+	allowFrozen bool,
+) *domain_structures.Room {
+	room := ActiveRoomsByNameMap.Get(inFrame.Room.Name)
+
+	if room == nil {
+		util.LogTrace("room '%s' not found", inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotFound, inFrame.RequestId)
+
+		return nil
+	}
+
+	room.Lock()
+
+	room.LastActiveAt = time.Now().UnixNano()
+
+	if room.IsDeleted {
+		room.Unlock()
+
+		util.LogInfo("failed to process '%s' for user '%s' - room '%s' was deleted", inFrame.Command, clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotFound, inFrame.RequestId)
+
+		return nil
+	}
+
+	if room.IsFrozen && !allowFrozen {
+		room.Unlock()
+
+		util.LogInfo("failed to process '%s' for user '%s' - room '%s' is being migrated", inFrame.Command, clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomIsMigrating, inFrame.RequestId)
+
+		return nil
+	}
+
+	_, userFound := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
+
+	if !userFound {
+		room.Unlock()
+
+		util.LogInfo("failed to process '%s' - user '%s' not active for room '%s'", inFrame.Command, clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotAuthorized, inFrame.RequestId)
+
+		return nil
+	}
+
+	clientSocketForThisRoom, socketFound := room.ActiveClientSocketsByUUID[clSocket.SocketUUID]
+
+	if !socketFound || clientSocketForThisRoom.IsDead() {
+		room.Unlock()
+
+		util.LogInfo("failed to process '%s' - socket '%s' not active for room '%s'", inFrame.Command, clSocket.SocketUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsConnectionError, inFrame.RequestId)
+
+		return nil
+	}
+
+	return room
+}
+
+// must be executed under room lock
+func findSessionUUIDByUserInRoomUUID(room *domain_structures.Room, userInRoomUUID string) string {
+	if userInRoomUUID == "" {
+		return ""
+	}
+
+	for sessionUUID, user := range room.AllRoomAuthorizedUsersBySessionUUID {
+		if user.UserInRoomUUID == userInRoomUUID {
+			return sessionUUID
+		}
+	}
+
+	return ""
+}
+
+// removes user from room's active users, tells him why and closes his sockets. Returns false if user was not active.
+// Must be executed under room lock
+func removeUserFromRoomNonLocking(room *domain_structures.Room, sessionUUID string, reason domain_structures.WsError) bool {
+	_, wasActive := room.ActiveRoomUserUUIDBySessionUUID[sessionUUID]
+
+	delete(room.ActiveRoomUserUUIDBySessionUUID, sessionUUID)
+	room.ActiveRoomUsersLen = len(room.ActiveRoomUserUUIDBySessionUUID)
+
+	if wasActive {
+		markUserOfflineNonLocking(room, sessionUUID, findSocketBySessionUUID(&room.ActiveClientSocketsByUUID, sessionUUID))
+	}
+
+	for socketUUID, userSocket := range room.ActiveClientSocketsByUUID {
+		if userSocket.SessionUUID != sessionUUID {
+			continue
+		}
+
+		delete(room.ActiveClientSocketsByUUID, socketUUID)
+
+		writeTimeout := ModerationErrorWriteTimeout
+		doWriteErrorMessageToSocket(userSocket, reason, nil, nil, true, &writeTimeout)
+
+		userSocket.Terminate()
+	}
+
+	return wasActive
+}
+
+// must be executed under room lock
+func makeBannedUsersFrame(room *domain_structures.Room, requestId *string) *domain_structures.OutMessageFrame {
+	bannedUsers := make([]domain_structures.RoomUserDTO, 0, len(room.BannedUserInRoomUUIDs))
+
+	for _, user := range room.AllRoomAuthorizedUsersBySessionUUID {
+		if !room.BannedUserInRoomUUIDs[user.UserInRoomUUID] {
+			continue
+		}
+
+		//safe copy of current variable values
+		userInRoomUUID := user.UserInRoomUUID
+		userName := user.UserName
+		isAnonName := user.IsAnonName
+		isOnlineInRoom := false
+
+		bannedUsers = append(bannedUsers, domain_structures.RoomUserDTO{
+			UserInRoomUUID: &userInRoomUUID,
+			UserName:       &userName,
+			IsAnonName:     &isAnonName,
+			IsOnlineInRoom: &isOnlineInRoom,
+		})
+	}
+
+	createdAt := time.Now().UnixNano()
+
+	return &domain_structures.OutMessageFrame{
+		Command:       domain_structures.RoomBannedUsers,
+		RequestId:     requestId,
+		CreatedAtNano: &createdAt,
+		AllRoomUsers:  &bannedUsers,
+	}
+}
@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// wrong passwords allowed before lockout starts - per client IP (any rooms) and per room (any clients).
+// Room threshold is higher since many legit users may mistype password of same room
+const PasswordFreeFailedAttemptsPerClientIp = 5
+const PasswordFreeFailedAttemptsPerRoom = 20
+
+// every next wrong password after free ones doubles lockout, starting from base one. Room lockout is just a short
+// throttle for guessing from many IPs - it never applies to clients that have not failed on this room
+const PasswordLockoutBaseDuration = 2 * time.Second
+const PasswordLockoutMaxDuration = 15 * time.Minute
+const PasswordRoomLockoutMaxDuration = 30 * time.Second
+
+// failed attempts are forgotten after this period without new ones
+const PasswordFailedAttemptsResetAfter = time.Hour
+
+// forgotten entries are swept once there are more entries than this
+const PasswordFailedAttemptsSweepThreshold = 10000
+
+/* Variables */
+
+var RoomPasswordFailedAttemptsCounter prometheus.Counter
+
+type passwordFailedAttempts struct {
+	Count        int
+	LastFailedAt int64
+	LockedUntil  int64
+
+	FailedClientIps map[string]bool //for room attempts - clients room lockout applies to
+}
+
+var passwordGuard = struct {
+	sync.Mutex
+	failedAttemptsByRoomId   map[string]*passwordFailedAttempts
+	failedAttemptsByClientIp map[string]*passwordFailedAttempts
+}{
+	failedAttemptsByRoomId:   make(map[string]*passwordFailedAttempts),
+	failedAttemptsByClientIp: make(map[string]*passwordFailedAttempts),
+}
+
+// time left until password of room may be tried again by client (0 - attempt is allowed).
+// Must be called before password hash check - so locked out attempts cost no CPU. Room lockout applies only
+// to clients that already failed on this room - so guessing can not lock out users that know password
+func getPasswordAttemptLockout(roomId string, clientIp string) time.Duration {
+	passwordGuard.Lock()
+	defer passwordGuard.Unlock()
+
+	now := time.Now().UnixNano()
+	lockedUntil := int64(0)
+
+	if roomFailedAttempts, found := passwordGuard.failedAttemptsByRoomId[roomId]; found && roomFailedAttempts.FailedClientIps[clientIp] {
+		lockedUntil = roomFailedAttempts.LockedUntil
+	}
+
+	if clientFailedAttempts, found := passwordGuard.failedAttemptsByClientIp[clientIp]; found && clientFailedAttempts.LockedUntil > lockedUntil {
+		lockedUntil = clientFailedAttempts.LockedUntil
+	}
+
+	if lockedUntil <= now {
+		return 0
+	}
+
+	return time.Duration(lockedUntil - now)
+}
+
+func registerFailedPasswordAttempt(roomId string, clientIp string) {
+	RoomPasswordFailedAttemptsCounter.Inc()
+
+	passwordGuard.Lock()
+	defer passwordGuard.Unlock()
+
+	now := time.Now().UnixNano()
+
+	if len(passwordGuard.failedAttemptsByRoomId)+len(passwordGuard.failedAttemptsByClientIp) >= PasswordFailedAttemptsSweepThreshold {
+		sweepForgottenPasswordFailedAttempts(now)
+	}
+
+	roomFailedAttempts := addPasswordFailedAttempt(passwordGuard.failedAttemptsByRoomId, roomId,
+		PasswordFreeFailedAttemptsPerRoom, PasswordRoomLockoutMaxDuration, now)
+	clientFailedAttempts := addPasswordFailedAttempt(passwordGuard.failedAttemptsByClientIp, clientIp,
+		PasswordFreeFailedAttemptsPerClientIp, PasswordLockoutMaxDuration, now)
+
+	if roomFailedAttempts.FailedClientIps == nil {
+		roomFailedAttempts.FailedClientIps = make(map[string]bool)
+	}
+
+	roomFailedAttempts.FailedClientIps[clientIp] = true
+
+	if roomFailedAttempts.LockedUntil > now || clientFailedAttempts.LockedUntil > now {
+		util.LogWarn("password attempts locked out for room '%s' (%d failed) / client '%s' (%d failed)",
+			roomId, roomFailedAttempts.Count, clientIp, clientFailedAttempts.Count)
+	}
+}
+
+// client that knows password of room is not throttled on it anymore. Its failures on other rooms (per client IP counter)
+// are kept until they are forgotten - otherwise logging into own room between guesses would reset lockout
+func registerSuccessfulPasswordAttempt(roomId string, clientIp string) {
+	passwordGuard.Lock()
+	defer passwordGuard.Unlock()
+
+	roomFailedAttempts, found := passwordGuard.failedAttemptsByRoomId[roomId]
+
+	if !found {
+		return
+	}
+
+	delete(roomFailedAttempts.FailedClientIps, clientIp)
+
+	if len(roomFailedAttempts.FailedClientIps) == 0 {
+		delete(passwordGuard.failedAttemptsByRoomId, roomId)
+	}
+}
+
+// returns failed attempts count and lockout end (0 - not locked) of room, for ctrl page
+func GetRoomPasswordFailedAttempts(roomId string) (int, int64) {
+	passwordGuard.Lock()
+	defer passwordGuard.Unlock()
+
+	roomFailedAttempts, found := passwordGuard.failedAttemptsByRoomId[roomId]
+
+	if !found || isPasswordFailedAttemptsForgotten(roomFailedAttempts, time.Now().UnixNano()) {
+		return 0, 0
+	}
+
+	return roomFailedAttempts.Count, roomFailedAttempts.LockedUntil
+}
+
+// lockout ends by client IP for clients that are locked out at the moment, for ctrl page
+func GetLockedOutClientIps() map[string]int64 {
+	passwordGuard.Lock()
+	defer passwordGuard.Unlock()
+
+	now := time.Now().UnixNano()
+	lockedOutClientIps := make(map[string]int64)
+
+	for clientIp, clientFailedAttempts := range passwordGuard.failedAttemptsByClientIp {
+		if clientFailedAttempts.LockedUntil > now {
+			lockedOutClientIps[clientIp] = clientFailedAttempts.LockedUntil
+		}
+	}
+
+	return lockedOutClientIps
+}
+
+// must be called under password guard lock
+func addPasswordFailedAttempt(
+	failedAttemptsByKey map[string]*passwordFailedAttempts,
+	key string,
+	freeAttempts int,
+	maxLockout time.Duration,
+	now int64,
+) *passwordFailedAttempts {
+	failedAttempts, found := failedAttemptsByKey[key]
+
+	if !found || isPasswordFailedAttemptsForgotten(failedAttempts, now) {
+		failedAttempts = &passwordFailedAttempts{}
+		failedAttemptsByKey[key] = failedAttempts
+	}
+
+	failedAttempts.Count++
+	failedAttempts.LastFailedAt = now
+
+	if failedAttempts.Count > freeAttempts {
+		lockout := maxLockout
+		lockedOutAttempts := failedAttempts.Count - freeAttempts
+
+		//compare before shifting to avoid overflow
+		if lockedOutAttempts <= 20 && PasswordLockoutBaseDuration<<(lockedOutAttempts-1) < maxLockout {
+			lockout = PasswordLockoutBaseDuration << (lockedOutAttempts - 1)
+		}
+
+		failedAttempts.LockedUntil = now + lockout.Nanoseconds()
+	}
+
+	return failedAttempts
+}
+
+func isPasswordFailedAttemptsForgotten(failedAttempts *passwordFailedAttempts, now int64) bool {
+	return failedAttempts.LockedUntil <= now && now-failedAttempts.LastFailedAt >= PasswordFailedAttemptsResetAfter.Nanoseconds()
+}
+
+// must be called under password guard lock
+func sweepForgottenPasswordFailedAttempts(now int64) {
+	for _, failedAttemptsByKey := range []map[string]*passwordFailedAttempts{passwordGuard.failedAttemptsByRoomId, passwordGuard.failedAttemptsByClientIp} {
+		for key, failedAttempts := range failedAttemptsByKey {
+			if isPasswordFailedAttemptsForgotten(failedAttempts, now) {
+				delete(failedAttemptsByKey, key)
+			}
+		}
+	}
+}
+
+// returns error response if password is wrong or password attempts are locked out. Must be executed under room lock
+func checkDirectRoomPasswordNonLocking(room *domain_structures.Room, roomPassword string, clientIp string, responseFormat string) []byte {
+	return checkDirectRoomPassword(room.Id, room.PasswordHash, roomPassword, clientIp, responseFormat)
+}
+
+// same as checkDirectRoomPasswordNonLocking, for callers that check password before locking room (hash check is slow)
+func checkDirectRoomPassword(roomId string, passwordHash string, roomPassword string, clientIp string, responseFormat string) []byte {
+	if passwordHash == "" {
+		return nil
+	}
+
+	if lockout := getPasswordAttemptLockout(roomId, clientIp); lockout > 0 {
+		return buildPasswordAttemptsLockedDirectResponse(lockout, responseFormat)
+	}
+
+	err := hasher.CheckHashEquality(passwordHash, roomPassword)
+
+	if err != nil {
+		registerFailedPasswordAttempt(roomId, clientIp)
+
+		return util.BuildDirectRoomMessagesErrorResponse(
+			"error: wrong room password (use URL param 'p=myPassword')", responseFormat)
+	}
+
+	registerSuccessfulPasswordAttempt(roomId, clientIp)
+
+	return nil
+}
+
+func buildPasswordAttemptsLockedDirectResponse(lockout time.Duration, responseFormat string) []byte {
+	return util.BuildDirectRoomMessagesErrorResponse(
+		fmt.Sprintf("error: too many wrong password attempts, retry in %d seconds", int(lockout.Seconds())+1), responseFormat)
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const RoomPinnedMessagesLimit = 10
+
+// processes pin/unpin commands. Only room owner and moderators are allowed to pin messages
+func processMessagePinCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	if !isRoomOwnerOrModerator(room, clSocket.SessionUUID) {
+		room.Unlock()
+
+		util.LogWarn("failed to process '%s' - user '%s' is not an owner or moderator of room '%s'", inFrame.Command, clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotPermitted, inFrame.RequestId)
+
+		return
+	}
+
+	messageId := inFrame.Message.Id
+
+	if inFrame.Command == domain_structures.TextMessagePin {
+		_, messageFound := room.RoomMessages[messageId]
+
+		//nothing to do if message is already gone or already pinned
+		if !messageFound || isMessagePinned(room, messageId) {
+			room.Unlock()
+
+			writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+			return
+		}
+
+		if len(room.PinnedMessageIds) >= RoomPinnedMessagesLimit {
+			room.Unlock()
+
+			util.LogTrace("failed to pin message '%d' - pinned messages limit reached in room '%s'", messageId, room.Name)
+			writeErrorMessageToSocket(clSocket, domain_structures.WsRoomPinnedMessagesLimit, inFrame.RequestId)
+
+			return
+		}
+
+		room.PinnedMessageIds = append(room.PinnedMessageIds, messageId)
+	} else if !unpinMessageNonLocking(room, messageId) {
+		room.Unlock()
+
+		writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+		return
+	}
+
+	util.LogTrace("user '%s' applied '%s' to message '%d' in room '%s' / '%s'", clSocket.SessionUUID, inFrame.Command, messageId, room.Id, room.Name)
+
+	ActiveRoomStore.SaveRoomInfo(room)
+
+	pinnedMessagesDispatchingFrame := makePinnedMessagesFrame(room)
+
+	recordRoomEvent(room, pinnedMessagesDispatchingFrame)
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	writeFrameToActiveRoomMembers(pinnedMessagesDispatchingFrame, room, roomActiveClientSocketsByUUID)
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// must be executed under room lock
+func isMessagePinned(room *domain_structures.Room, messageId int64) bool {
+	for _, pinnedMessageId := range room.PinnedMessageIds {
+		if pinnedMessageId == messageId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns false if message was not pinned. Must be executed under room lock
+func unpinMessageNonLocking(room *domain_structures.Room, messageId int64) bool {
+	for i, pinnedMessageId := range room.PinnedMessageIds {
+		if pinnedMessageId == messageId {
+			room.PinnedMessageIds = append(room.PinnedMessageIds[:i], room.PinnedMessageIds[i+1:]...)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// frame with full list of currently pinned messages (in order of pinning). Must be executed under room lock
+func makePinnedMessagesFrame(room *domain_structures.Room) *domain_structures.OutMessageFrame {
+	pinnedMessagesDTO := make([]domain_structures.RoomMessageDTO, 0, len(room.PinnedMessageIds))
+
+	for _, pinnedMessageId := range room.PinnedMessageIds {
+		if pinnedMessage, found := room.RoomMessages[pinnedMessageId]; found {
+			pinnedMessagesDTO = append(pinnedMessagesDTO, copyMessageAsDTO(pinnedMessage))
+		}
+	}
+
+	createdAt := time.Now().UnixNano()
+
+	return &domain_structures.OutMessageFrame{
+		Command:       domain_structures.RoomPinnedMessages,
+		CreatedAtNano: &createdAt,
+		Message:       &pinnedMessagesDTO,
+	}
+}
@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const UserPresenceActive = "active"
+const UserPresenceIdle = "idle"
+const UserPresenceAway = "away"
+
+// user whose clients sent no keep-alive or status signal within this period is shown as 'away' (e.g. laptop went to sleep)
+const UserPresenceStaleTimeout = 30 * time.Second
+
+// processes presence status reported by client (e.g. 'idle' after no input for a while, 'away' when tab is hidden)
+func processUserPresenceCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	presence := inFrame.Presence
+
+	if presence != UserPresenceActive && presence != UserPresenceIdle && presence != UserPresenceAway {
+		util.LogTrace("failed to change presence - unknown presence '%s'", presence)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+
+		return
+	}
+
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, true)
+
+	if room == nil {
+		return
+	}
+
+	roomUser, found := room.AllRoomAuthorizedUsersBySessionUUID[clSocket.SessionUUID]
+
+	var presenceChange *domain_structures.RoomUserPresenceDTO = nil
+
+	if found {
+		roomUser.DeclaredPresence = presence
+		roomUser.LastSeenAt = time.Now().UnixNano()
+
+		presenceChange = refreshActiveUserPresenceNonLocking(room, clSocket.SessionUUID, roomUser, roomUser.LastSeenAt)
+	}
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	if presenceChange != nil {
+		writePresenceChangesToActiveRoomMembers(room, []domain_structures.RoomUserPresenceDTO{*presenceChange}, roomActiveClientSocketsByUUID)
+	}
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// keep-alive proves user's client is still there. Its time is kept on socket, so room is locked only to bring back user
+// that was 'away' due to missed keep-alives
+func touchUserPresence(clSocket *domain_structures.WebSocket) {
+	room := clSocket.RelatedRoom
+
+	if room == nil || atomic.LoadInt32(&clSocket.IsPresenceStale) == 0 {
+		return
+	}
+
+	room.Lock()
+
+	_, isActive := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
+	roomUser, found := room.AllRoomAuthorizedUsersBySessionUUID[clSocket.SessionUUID]
+
+	if room.IsDeleted || !isActive || !found {
+		room.Unlock()
+
+		return
+	}
+
+	presenceChange := refreshActiveUserPresenceNonLocking(room, clSocket.SessionUUID, roomUser, time.Now().UnixNano())
+
+	if presenceChange == nil {
+		room.Unlock()
+
+		return
+	}
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	writePresenceChangesToActiveRoomMembers(room, []domain_structures.RoomUserPresenceDTO{*presenceChange}, roomActiveClientSocketsByUUID)
+}
+
+// re-evaluates presence of all active room users, sends changes (if any) to room members
+func refreshRoomUsersPresence(room *domain_structures.Room) {
+	room.Lock()
+
+	if room.IsDeleted {
+		room.Unlock()
+
+		return
+	}
+
+	now := time.Now().UnixNano()
+
+	var presenceChanges []domain_structures.RoomUserPresenceDTO
+
+	for sessionUUID := range room.ActiveRoomUserUUIDBySessionUUID {
+		roomUser, found := room.AllRoomAuthorizedUsersBySessionUUID[sessionUUID]
+
+		if !found {
+			continue
+		}
+
+		if presenceChange := refreshActiveUserPresenceNonLocking(room, sessionUUID, roomUser, now); presenceChange != nil {
+			presenceChanges = append(presenceChanges, *presenceChange)
+		}
+	}
+
+	if len(presenceChanges) == 0 {
+		room.Unlock()
+
+		return
+	}
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	writePresenceChangesToActiveRoomMembers(room, presenceChanges, roomActiveClientSocketsByUUID)
+}
+
+// same as refreshUserPresenceNonLocking, for user being in room - his latest keep-alive counts as time he was last seen,
+// his socket learns whether he is 'away' due to missed keep-alives. Must be executed under room lock
+func refreshActiveUserPresenceNonLocking(
+	room *domain_structures.Room,
+	sessionUUID string,
+	roomUser *domain_structures.RoomUser,
+	now int64,
+) *domain_structures.RoomUserPresenceDTO {
+	userSocket := findSocketBySessionUUID(&room.ActiveClientSocketsByUUID, sessionUUID)
+
+	if userSocket == nil {
+		return refreshUserPresenceNonLocking(roomUser, now)
+	}
+
+	if lastKeepAliveAt := atomic.LoadInt64(&userSocket.LastKeepAliveSignal); lastKeepAliveAt > roomUser.LastSeenAt {
+		roomUser.LastSeenAt = lastKeepAliveAt
+	}
+
+	presenceChange := refreshUserPresenceNonLocking(roomUser, now)
+
+	if isUserPresenceStale(roomUser, now) {
+		atomic.StoreInt32(&userSocket.IsPresenceStale, 1)
+	} else {
+		atomic.StoreInt32(&userSocket.IsPresenceStale, 0)
+	}
+
+	return presenceChange
+}
+
+// updates effective presence of user, returns change to be sent to room members or nil if presence did not change.
+// Must be executed under room lock
+func refreshUserPresenceNonLocking(roomUser *domain_structures.RoomUser, now int64) *domain_structures.RoomUserPresenceDTO {
+	presence := roomUser.DeclaredPresence
+
+	if presence == "" {
+		presence = UserPresenceActive
+	}
+
+	if isUserPresenceStale(roomUser, now) {
+		presence = UserPresenceAway
+	}
+
+	if presence == roomUser.Presence {
+		return nil
+	}
+
+	roomUser.Presence = presence
+
+	return &domain_structures.RoomUserPresenceDTO{
+		UserInRoomUUID: roomUser.UserInRoomUUID,
+		IsOnlineInRoom: true,
+		Presence:       presence,
+		LastSeenAt:     roomUser.LastSeenAt,
+	}
+}
+
+func isUserPresenceStale(roomUser *domain_structures.RoomUser, now int64) bool {
+	return now-roomUser.LastSeenAt > int64(UserPresenceStaleTimeout)
+}
+
+// saves time user was last seen at (latest keep-alive of his socket counts, socket may be nil). Full members list
+// (sent when user leaves) carries his new state. Must be executed under room lock, after user is removed from room's active users
+func markUserOfflineNonLocking(room *domain_structures.Room, sessionUUID string, userSocket *domain_structures.WebSocket) {
+	roomUser, found := room.AllRoomAuthorizedUsersBySessionUUID[sessionUUID]
+
+	if !found {
+		return
+	}
+
+	roomUser.DeclaredPresence = ""
+	roomUser.Presence = ""
+
+	if userSocket != nil {
+		if lastKeepAliveAt := atomic.LoadInt64(&userSocket.LastKeepAliveSignal); lastKeepAliveAt > roomUser.LastSeenAt {
+			roomUser.LastSeenAt = lastKeepAliveAt
+		}
+	}
+
+	if roomUser.LastSeenAt == 0 {
+		roomUser.LastSeenAt = time.Now().UnixNano()
+	}
+
+	ActiveRoomStore.AuthorizeUser(room, sessionUUID, roomUser)
+}
+
+func writePresenceChangesToActiveRoomMembers(
+	room *domain_structures.Room,
+	presenceChanges []domain_structures.RoomUserPresenceDTO,
+	roomActiveClientSocketsByUUID *map[string]*domain_structures.WebSocket,
+) {
+	createdAt := time.Now().UnixNano()
+
+	writeFrameToActiveRoomMembers(&domain_structures.OutMessageFrame{
+		Command:         domain_structures.UserPresence,
+		CreatedAtNano:   &createdAt,
+		PresenceChanges: &presenceChanges,
+	}, room, roomActiveClientSocketsByUUID)
+}
@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const RoomSlowModeMaxIntervalSec = 60 * 60
+
+// limiters of client IPs that are idle (bucket is full again) are dropped once there are more limiters than this
+const DirectSendingRateLimitersSweepThreshold = 10000
+
+/* Variables */
+
+// every user can send burst of messages to room, then one message per refill interval (may be overridden by app config)
+var MessageRateLimitBurst = 10
+var MessageRateLimitRefillInterval = 1 * time.Second
+
+// same for directly sent messages, per client IP (may be overridden by app config)
+var DirectSendingRateLimitBurst = 5
+var DirectSendingRateLimitRefillInterval = 5 * time.Second
+
+var directSendingRateLimiters = struct {
+	sync.Mutex
+	limitersByClientIp map[string]*domain_structures.TokenBucket
+}{
+	limitersByClientIp: make(map[string]*domain_structures.TokenBucket),
+}
+
+// turns slow mode on (positive interval) or off (zero interval). Only room owner is allowed to do it
+func processRoomSlowModeCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	slowModeIntervalSec := inFrame.SlowModeIntervalSec
+
+	if slowModeIntervalSec < 0 || slowModeIntervalSec > RoomSlowModeMaxIntervalSec {
+		util.LogTrace("failed to change slow mode - invalid interval '%d'", slowModeIntervalSec)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+
+		return
+	}
+
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	if !isRoomOwner(room, clSocket.SessionUUID) {
+		room.Unlock()
+
+		util.LogWarn("failed to change slow mode - user '%s' is not an owner of room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotPermitted, inFrame.RequestId)
+
+		return
+	}
+
+	if room.SlowModeIntervalSec == slowModeIntervalSec {
+		room.Unlock()
+
+		writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+		return
+	}
+
+	util.LogTrace("user '%s' is setting slow mode interval '%d' for room '%s'", clSocket.SessionUUID, slowModeIntervalSec, room.Name)
+
+	room.SlowModeIntervalSec = slowModeIntervalSec
+
+	//limiters are re-created with new interval on next message
+	room.MessageRateLimitersBySessionUUID = make(map[string]*domain_structures.TokenBucket)
+
+	ActiveRoomStore.SaveRoomInfo(room)
+
+	slowModeDispatchingFrame := makeRoomSlowModeFrame(room)
+
+	recordRoomEvent(room, slowModeDispatchingFrame)
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	writeFrameToActiveRoomMembers(slowModeDispatchingFrame, room, roomActiveClientSocketsByUUID)
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// checks (and consumes) user's allowance to send message. If it is exhausted - writes error with time after which user can retry.
+// Must be executed under room lock
+func takeMessageRateLimitTokenNonLocking(room *domain_structures.Room, clSocket *domain_structures.WebSocket, requestId *string) bool {
+	rateLimiter, found := room.MessageRateLimitersBySessionUUID[clSocket.SessionUUID]
+
+	if !found {
+		rateLimiter = makeMessageRateLimiterNonLocking(room, clSocket.SessionUUID)
+		room.MessageRateLimitersBySessionUUID[clSocket.SessionUUID] = rateLimiter
+	}
+
+	return takeRateLimitTokenNonLocking(room, clSocket, rateLimiter, requestId)
+}
+
+// edits have their own allowance - fixing typo does not cost user his next message. Slow mode does not apply to edits
+// (they add nothing to room history), but global limit does. Must be executed under room lock
+func takeMessageEditRateLimitTokenNonLocking(room *domain_structures.Room, clSocket *domain_structures.WebSocket, requestId *string) bool {
+	rateLimiter, found := room.EditRateLimitersBySessionUUID[clSocket.SessionUUID]
+
+	if !found {
+		rateLimiter = domain_structures.NewTokenBucket(MessageRateLimitBurst, MessageRateLimitRefillInterval)
+		room.EditRateLimitersBySessionUUID[clSocket.SessionUUID] = rateLimiter
+	}
+
+	return takeRateLimitTokenNonLocking(room, clSocket, rateLimiter, requestId)
+}
+
+// must be executed under room lock
+func takeRateLimitTokenNonLocking(
+	room *domain_structures.Room,
+	clSocket *domain_structures.WebSocket,
+	rateLimiter *domain_structures.TokenBucket,
+	requestId *string,
+) bool {
+	allowed, retryAfter := rateLimiter.Take(time.Now().UnixNano())
+
+	if !allowed {
+		util.LogTrace("user '%s' is rate limited in room '%s' for '%s'", clSocket.SessionUUID, room.Name, retryAfter)
+		writeErrorWithRetryAfterToSocket(clSocket, domain_structures.WsRoomRateLimited, retryAfter, requestId)
+	}
+
+	return allowed
+}
+
+// slow mode (if on) allows single message per its interval, but never loosens global limit. Room owner and moderators
+// are not affected by slow mode. Must be executed under room lock
+func makeMessageRateLimiterNonLocking(room *domain_structures.Room, sessionUUID string) *domain_structures.TokenBucket {
+	slowModeInterval := time.Duration(room.SlowModeIntervalSec) * time.Second
+
+	if slowModeInterval > 0 && !isRoomOwnerOrModerator(room, sessionUUID) {
+		if slowModeInterval < MessageRateLimitRefillInterval {
+			slowModeInterval = MessageRateLimitRefillInterval
+		}
+
+		return domain_structures.NewTokenBucket(1, slowModeInterval)
+	}
+
+	return domain_structures.NewTokenBucket(MessageRateLimitBurst, MessageRateLimitRefillInterval)
+}
+
+// checks (and consumes) client's allowance to send message directly. Returns time after which client can retry if it is exhausted
+func takeDirectSendingRateLimitToken(clientIp string) (bool, time.Duration) {
+	directSendingRateLimiters.Lock()
+	defer directSendingRateLimiters.Unlock()
+
+	now := time.Now().UnixNano()
+
+	if len(directSendingRateLimiters.limitersByClientIp) >= DirectSendingRateLimitersSweepThreshold {
+		for ip, rateLimiter := range directSendingRateLimiters.limitersByClientIp {
+			if rateLimiter.IsFull(now) {
+				delete(directSendingRateLimiters.limitersByClientIp, ip)
+			}
+		}
+	}
+
+	rateLimiter, found := directSendingRateLimiters.limitersByClientIp[clientIp]
+
+	if !found {
+		rateLimiter = domain_structures.NewTokenBucket(DirectSendingRateLimitBurst, DirectSendingRateLimitRefillInterval)
+		directSendingRateLimiters.limitersByClientIp[clientIp] = rateLimiter
+	}
+
+	return rateLimiter.Take(now)
+}
+
+// must be executed under room lock
+func makeRoomSlowModeFrame(room *domain_structures.Room) *domain_structures.OutMessageFrame {
+	slowModeIntervalSec := room.SlowModeIntervalSec
+	createdAt := time.Now().UnixNano()
+
+	return &domain_structures.OutMessageFrame{
+		Command:             domain_structures.RoomSlowMode,
+		CreatedAtNano:       &createdAt,
+		SlowModeIntervalSec: &slowModeIntervalSec,
+	}
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// reserved reactions - mapped onto message support/reject votes ('sC'/'rC' counters), always allowed
+const SupportReaction = "👍"
+const RejectReaction = "👎"
+
+/* Variables */
+
+// may be overridden by app config
+var AllowedMessageReactions = []string{"❤️", "😂", "😮", "😢", "🔥", "🎉"}
+
+// toggles reaction of current user on message and broadcasts changed reaction count
+func processMessageReactionCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	reaction := inFrame.Reaction
+
+	if !isAllowedMessageReaction(reaction) {
+		util.LogTrace("failed to react to message - reaction '%s' is not allowed", reaction)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+
+		return
+	}
+
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	userInRoomUUID := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
+
+	existingMessage, messageFound := room.RoomMessages[inFrame.Message.Id]
+
+	if !messageFound {
+		room.Unlock()
+
+		writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+		return
+	}
+
+	reactedAt := time.Now().UnixNano()
+
+	var messageReactionDispatchingFrame *domain_structures.OutMessageFrame
+
+	if reaction == SupportReaction || reaction == RejectReaction {
+		//same rules as for support/reject command: not allowed for message author, except for room owner
+		if existingMessage.UserInRoomUUID == userInRoomUUID && !isRoomOwner(room, clSocket.SessionUUID) {
+			room.Unlock()
+
+			util.LogWarn("failed to react '%s' to message - user '%s' is an author of message '%d'", reaction, clSocket.SessionUUID, existingMessage.Id)
+			writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+			return
+		}
+
+		ActiveRoomStore.ToggleMessageVote(room, existingMessage, clSocket.SessionUUID, reaction == SupportReaction, reactedAt)
+
+		messageReactionDispatchingFrame = &domain_structures.OutMessageFrame{
+			Command:       domain_structures.TextMessageSupportOrReject,
+			Message:       &[]domain_structures.RoomMessageDTO{copyVotedMessageAsDTO(existingMessage)},
+			CreatedAtNano: &reactedAt,
+		}
+	} else {
+		ActiveRoomStore.ToggleMessageReaction(room, existingMessage, clSocket.SessionUUID, reaction, reactedAt)
+
+		messageReactionDispatchingFrame = &domain_structures.OutMessageFrame{
+			Command:       domain_structures.TextMessageReaction,
+			Message:       &[]domain_structures.RoomMessageDTO{copyReactedMessageAsDTO(existingMessage, reaction)},
+			CreatedAtNano: &reactedAt,
+		}
+	}
+
+	util.LogTrace("user '%s' toggled reaction '%s' on message '%d' in room '%s' / '%s'",
+		clSocket.SessionUUID, reaction, existingMessage.Id, room.Id, room.Name)
+
+	recordRoomEvent(room, messageReactionDispatchingFrame)
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	writeFrameToActiveRoomMembers(messageReactionDispatchingFrame, room, roomActiveClientSocketsByUUID)
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+func isAllowedMessageReaction(reaction string) bool {
+	return reaction == SupportReaction || reaction == RejectReaction || util.ArrayContainsString(AllowedMessageReactions, reaction)
+}
+
+// all message reactions (support/reject ones included) with non-zero counts. Must be executed under room lock
+func copyMessageReactions(orig *domain_structures.RoomMessage) map[string]int {
+	reactions := make(map[string]int, len(orig.ReactionCounts)+2)
+
+	if orig.SupportedCount > 0 {
+		reactions[SupportReaction] = orig.SupportedCount
+	}
+
+	if orig.RejectedCount > 0 {
+		reactions[RejectReaction] = orig.RejectedCount
+	}
+
+	for reaction, count := range orig.ReactionCounts {
+		reactions[reaction] = count
+	}
+
+	return reactions
+}
+
+// message id with new count of single changed reaction (zero means reaction is gone)
+func copyReactedMessageAsDTO(orig *domain_structures.RoomMessage, reaction string) domain_structures.RoomMessageDTO {
+	messageId := orig.Id
+
+	return domain_structures.RoomMessageDTO{
+		Id:          &messageId,
+		LastVotedAt: orig.LastVotedAt,
+		Reactions:   map[string]int{reaction: orig.ReactionCounts[reaction]},
+	}
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// read markers reported within this period are collected, saved to room store and broadcast as single 'seen by' update
+const ReadMarkersBroadcastDelay = 2 * time.Second
+
+// saves id of latest message user has seen. Markers only move forward
+func processUserReadMarkerCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	roomUser, found := room.AllRoomAuthorizedUsersBySessionUUID[clSocket.SessionUUID]
+	lastReadMessageId := inFrame.Message.Id
+
+	//ids of not yet existing messages are not accepted
+	if lastReadMessageId >= room.NextMessageId {
+		lastReadMessageId = room.NextMessageId - 1
+	}
+
+	if !found || lastReadMessageId <= roomUser.LastReadMessageId {
+		room.Unlock()
+
+		writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+		return
+	}
+
+	//saved to room store with next broadcast
+	roomUser.LastReadMessageId = lastReadMessageId
+
+	//first change since previous broadcast - schedule next one
+	if len(room.PendingReadMarkerUserInRoomUUIDs) == 0 {
+		time.AfterFunc(ReadMarkersBroadcastDelay, func() {
+			writeReadMarkersFrameToActiveRoomMembers(room)
+		})
+	}
+
+	room.PendingReadMarkerUserInRoomUUIDs[roomUser.UserInRoomUUID] = true
+
+	room.Unlock()
+
+	util.LogTrace("user '%s' read messages up to '%d' in room '%s'", clSocket.SessionUUID, lastReadMessageId, room.Name)
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// saves and broadcasts read markers changed since previous broadcast, drops self-destructing messages read by all. Full set of markers is delivered with room members list
+func writeReadMarkersFrameToActiveRoomMembers(room *domain_structures.Room) {
+	room.Lock()
+
+	if room.IsDeleted || len(room.PendingReadMarkerUserInRoomUUIDs) == 0 {
+		room.Unlock()
+
+		return
+	}
+
+	readMarkers := make(map[string]int64, len(room.PendingReadMarkerUserInRoomUUIDs))
+
+	for sessionUUID, user := range room.AllRoomAuthorizedUsersBySessionUUID {
+		if room.PendingReadMarkerUserInRoomUUIDs[user.UserInRoomUUID] {
+			readMarkers[user.UserInRoomUUID] = user.LastReadMessageId
+
+			ActiveRoomStore.AuthorizeUser(room, sessionUUID, user)
+		}
+	}
+
+	room.PendingReadMarkerUserInRoomUUIDs = make(map[string]bool)
+
+	//self-destructing messages may now be read by everyone - checked once per broadcast, not on every marker
+	hasExpiringMessages := room.MessageExpiryTimer != nil
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	if hasExpiringMessages {
+		expireRoomMessages(room)
+	}
+
+	createdAt := time.Now().UnixNano()
+
+	writeFrameToActiveRoomMembers(&domain_structures.OutMessageFrame{
+		Command:       domain_structures.UserReadMarker,
+		CreatedAtNano: &createdAt,
+		ReadMarkers:   &readMarkers,
+	}, room, roomActiveClientSocketsByUUID)
+}
@@ -0,0 +1,281 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const RoomRoleOwner = "owner"
+const RoomRoleModerator = "moderator"
+const RoomRoleMember = "member"
+
+// failed recovery key attempts are counted by password guard separately from room password ones
+const OwnerRecoveryKeyFailedAttemptsKeyPrefix = "owner-key:"
+
+// processes grant/revoke moderator, transfer and reclaim ownership commands
+func processRoomRolesCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	//new owner gets new recovery key. Hashing is slow - it is done before room is locked
+	var ownerRecoveryKey, ownerRecoveryKeyHash string
+
+	if inFrame.Command == domain_structures.RoomTransferOwnership {
+		var err error
+
+		ownerRecoveryKey, ownerRecoveryKeyHash, err = generateOwnerRecoveryKey()
+
+		if err != nil {
+			util.LogSevere("failed to generate owner recovery key for room '%s': '%s'", inFrame.Room.Name, err)
+			writeErrorMessageToSocket(clSocket, domain_structures.WsServerError, inFrame.RequestId)
+
+			return
+		}
+	}
+
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	//reclaim is the only roles command that is done by (yet) non-owner
+	if inFrame.Command == domain_structures.RoomReclaimOwnership {
+		reclaimRoomOwnership(room, clSocket, inFrame)
+
+		return
+	}
+
+	if !isRoomOwner(room, clSocket.SessionUUID) {
+		room.Unlock()
+
+		util.LogWarn("failed to process '%s' - user '%s' is not an owner of room '%s'", inFrame.Command, clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotPermitted, inFrame.RequestId)
+
+		return
+	}
+
+	targetUserInRoomUUID := inFrame.TargetUserInRoomUUID
+	targetSessionUUID := findSessionUUIDByUserInRoomUUID(room, targetUserInRoomUUID)
+
+	if targetSessionUUID == "" || targetSessionUUID == ExternalUserSessionUUID || room.BannedUserInRoomUUIDs[targetUserInRoomUUID] {
+		room.Unlock()
+
+		util.LogTrace("failed to process '%s' - user '%s' not found in room '%s'", inFrame.Command, targetUserInRoomUUID, room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomUserNotFound, inFrame.RequestId)
+
+		return
+	}
+
+	if targetSessionUUID == clSocket.SessionUUID {
+		room.Unlock()
+
+		util.LogTrace("failed to process '%s' - owner of room '%s' can not change own role", inFrame.Command, room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+
+		return
+	}
+
+	ownershipChanged := false
+
+	switch inFrame.Command {
+	case domain_structures.RoomGrantModerator:
+		room.ModeratorUserInRoomUUIDs[targetUserInRoomUUID] = true
+
+	case domain_structures.RoomRevokeModerator:
+		delete(room.ModeratorUserInRoomUUIDs, targetUserInRoomUUID)
+
+	case domain_structures.RoomTransferOwnership:
+		//new owner must be online to receive his recovery key
+		targetSocket := findSocketBySessionUUID(&room.ActiveClientSocketsByUUID, targetSessionUUID)
+
+		if targetSocket == nil {
+			room.Unlock()
+
+			util.LogTrace("failed to transfer ownership of room '%s' - user '%s' is not online", room.Name, targetUserInRoomUUID)
+			writeErrorMessageToSocket(clSocket, domain_structures.WsRoomUserNotFound, inFrame.RequestId)
+
+			return
+		}
+
+		room.OwnerRecoveryKeyHash = ownerRecoveryKeyHash
+
+		//previous owner stays moderator
+		previousOwnerUser, found := room.AllRoomAuthorizedUsersBySessionUUID[room.CreatedBySessionUUID]
+
+		if found {
+			room.ModeratorUserInRoomUUIDs[previousOwnerUser.UserInRoomUUID] = true
+		}
+
+		delete(room.ModeratorUserInRoomUUIDs, targetUserInRoomUUID)
+		room.CreatedBySessionUUID = targetSessionUUID
+
+		createdAt := time.Now().UnixNano()
+
+		writeFrameToSocket(targetSocket, &domain_structures.OutMessageFrame{
+			Command:          domain_structures.RoomOwnerRecoveryKey,
+			CreatedAtNano:    &createdAt,
+			OwnerRecoveryKey: &ownerRecoveryKey,
+		})
+
+		ownershipChanged = true
+	}
+
+	//slow mode does not apply to owner and moderators - limiters are re-created with respect to new roles
+	room.MessageRateLimitersBySessionUUID = make(map[string]*domain_structures.TokenBucket)
+
+	ActiveRoomStore.SaveRoomInfo(room)
+
+	room.Unlock()
+
+	util.LogInfo("'%s' applied to user '%s' in room '%s' / '%s'", inFrame.Command, targetUserInRoomUUID, room.Id, room.Name)
+
+	go notifyRoomRolesChanged(room, ownershipChanged)
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// makes current session a room owner if valid recovery key is provided. Key is one-time - new one is returned to new owner.
+// Key guesses are limited the same way as password guesses. Hashing is slow - room is unlocked while key is checked.
+// Must be executed under room lock, unlocks room
+func reclaimRoomOwnership(room *domain_structures.Room, clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	currentOwnerRecoveryKeyHash := room.OwnerRecoveryKeyHash
+
+	room.Unlock()
+
+	failedAttemptsKey := OwnerRecoveryKeyFailedAttemptsKeyPrefix + room.Id
+
+	if lockout := getPasswordAttemptLockout(failedAttemptsKey, clSocket.ClientIp); lockout > 0 {
+		util.LogTrace("owner recovery key attempts for room '%s' are locked out for client '%s'", room.Id, clSocket.ClientIp)
+		writeErrorWithRetryAfterToSocket(clSocket, domain_structures.WsRoomPasswordAttemptsLocked, lockout, inFrame.RequestId)
+
+		return
+	}
+
+	if currentOwnerRecoveryKeyHash == "" || inFrame.OwnerRecoveryKey == "" ||
+		hasher.CheckHashEquality(currentOwnerRecoveryKeyHash, inFrame.OwnerRecoveryKey) != nil {
+		registerFailedPasswordAttempt(failedAttemptsKey, clSocket.ClientIp)
+
+		util.LogWarn("failed to reclaim ownership of room '%s' by user '%s' - invalid recovery key", room.Name, clSocket.SessionUUID)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomInvalidOwnerRecoveryKey, inFrame.RequestId)
+
+		return
+	}
+
+	registerSuccessfulPasswordAttempt(failedAttemptsKey, clSocket.ClientIp)
+
+	ownerRecoveryKey, ownerRecoveryKeyHash, err := generateOwnerRecoveryKey()
+
+	if err != nil {
+		util.LogSevere("failed to generate owner recovery key for room '%s': '%s'", room.Id, err)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsServerError, inFrame.RequestId)
+
+		return
+	}
+
+	room.Lock()
+
+	//key may have been used by parallel request or room may have changed while it was unlocked
+	if room.IsDeleted || room.IsFrozen || room.OwnerRecoveryKeyHash != currentOwnerRecoveryKeyHash {
+		room.Unlock()
+
+		util.LogWarn("failed to reclaim ownership of room '%s' by user '%s' - room changed during key check", room.Name, clSocket.SessionUUID)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomInvalidOwnerRecoveryKey, inFrame.RequestId)
+
+		return
+	}
+
+	newOwnerUserInRoomUUID, userFound := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
+
+	if !userFound {
+		room.Unlock()
+
+		util.LogInfo("failed to reclaim ownership - user '%s' not active for room '%s'", clSocket.SessionUUID, room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotAuthorized, inFrame.RequestId)
+
+		return
+	}
+
+	room.OwnerRecoveryKeyHash = ownerRecoveryKeyHash
+
+	delete(room.ModeratorUserInRoomUUIDs, newOwnerUserInRoomUUID)
+	room.CreatedBySessionUUID = clSocket.SessionUUID
+
+	ActiveRoomStore.SaveRoomInfo(room)
+
+	room.Unlock()
+
+	util.LogInfo("user '%s' reclaimed ownership of room '%s' / '%s'", newOwnerUserInRoomUUID, room.Id, room.Name)
+
+	go notifyRoomRolesChanged(room, true)
+
+	createdAt := time.Now().UnixNano()
+
+	writeFrameToSocket(clSocket, &domain_structures.OutMessageFrame{
+		Command:          domain_structures.RequestProcessed,
+		RequestId:        inFrame.RequestId,
+		CreatedAtNano:    &createdAt,
+		OwnerRecoveryKey: &ownerRecoveryKey,
+	})
+}
+
+// generates new owner recovery key, returns it with its hash (to be put to room - previous key stops working then).
+// Hashing is slow - should not be done under room lock
+func generateOwnerRecoveryKey() (string, string, error) {
+	//random-based (not time-based) uuid, so key can not be guessed
+	ownerRecoveryKeyUUID, err := uuid.NewRandom()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	ownerRecoveryKey := ownerRecoveryKeyUUID.String()
+
+	ownerRecoveryKeyHash, err := hasher.GenerateHashFromString(ownerRecoveryKey)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return ownerRecoveryKey, ownerRecoveryKeyHash, nil
+}
+
+func notifyRoomRolesChanged(room *domain_structures.Room, ownershipChanged bool) {
+	writeMembersListChangedFrameToActiveRoomMembers(room, nil)
+
+	//room description frame carries room owner id
+	if ownershipChanged {
+		writeRoomDescriptionChangedFrameToActiveRoomMembers(room, ServerStatus)
+	}
+}
+
+// must be executed under room lock
+func getRoomUserRole(room *domain_structures.Room, sessionUUID string, userInRoomUUID string) string {
+	if sessionUUID == room.CreatedBySessionUUID {
+		return RoomRoleOwner
+	}
+
+	if room.ModeratorUserInRoomUUIDs[userInRoomUUID] {
+		return RoomRoleModerator
+	}
+
+	return RoomRoleMember
+}
+
+// must be executed under room lock
+func isRoomOwner(room *domain_structures.Room, sessionUUID string) bool {
+	return sessionUUID == room.CreatedBySessionUUID
+}
+
+// must be executed under room lock
+func isRoomOwnerOrModerator(room *domain_structures.Room, sessionUUID string) bool {
+	if isRoomOwner(room, sessionUUID) {
+		return true
+	}
+
+	roomUser, found := room.AllRoomAuthorizedUsersBySessionUUID[sessionUUID]
+
+	return found && room.ModeratorUserInRoomUUIDs[roomUser.UserInRoomUUID]
+}
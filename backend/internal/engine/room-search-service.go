@@ -0,0 +1,340 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const SearchPageDefaultLimit = 20
+const SearchPageMaxLimit = 100
+
+const SearchQueryMaxLength = 200
+
+// drawing messages carry file reference instead of text, so they are not indexed (see MESSAGE_META_MARKER_TYPE_DRAWING in js)
+const DrawingMessageMetaMarker = "$#$meta_marker_is_drawing$#$"
+
+// searches room messages (whispers are not searched) and returns single page of hits, newest first
+func processMessageSearchCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	searchQuery := inFrame.SearchQuery
+
+	if !isValidMessageSearchQuery(searchQuery) {
+		util.LogTrace("failed to search messages - invalid query '%+v'", searchQuery)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+
+		return
+	}
+
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, true)
+
+	if room == nil {
+		return
+	}
+
+	searchLimit := getSearchPageLimit(inFrame.HistoryLimit)
+
+	util.LogTrace("user '%s' is searching messages '%+v' before '%d' in room '%s' / '%s'",
+		clSocket.SessionUUID, searchQuery, inFrame.HistoryBeforeId, room.Id, room.Name)
+
+	foundMessages, hitsCount, hasMoreHits := searchRoomMessagesNonLocking(room, searchQuery, inFrame.HistoryBeforeId, searchLimit)
+
+	foundMessagesDTOCopy := make([]domain_structures.RoomMessageDTO, len(foundMessages))
+
+	for i, foundMessage := range foundMessages {
+		foundMessagesDTOCopy[i] = copyMessageAsDTO(foundMessage)
+	}
+
+	room.Unlock()
+
+	createdAt := time.Now().UnixNano()
+
+	writeFrameToSocket(clSocket, &domain_structures.OutMessageFrame{
+		Command:         domain_structures.TextMessageSearch,
+		RequestId:       inFrame.RequestId,
+		CreatedAtNano:   &createdAt,
+		Message:         &foundMessagesDTOCopy,
+		HasMoreHistory:  &hasMoreHits,
+		SearchHitsCount: &hitsCount,
+	})
+}
+
+// side method for room messages search - used for direct http requests. Unlike retrieval, does not create missing room
+func SearchRoomMessagesDirectly(roomName string, roomPassword string, searchQuery domain_structures.MessageSearchQuery,
+	searchLimit int, beforeMessageId int64, responseFormat string, quiteMode bool, clientIp string) []byte {
+	if !isValidMessageSearchQuery(searchQuery) {
+		return util.BuildDirectRoomMessagesErrorResponse(
+			"error: empty or too long search query (use URL params 'q=words', 'u=userName', 'from=unixSeconds', 'to=unixSeconds')", responseFormat)
+	}
+
+	room := ActiveRoomsByNameMap.Get(roomName)
+
+	if room == nil {
+		return util.BuildDirectRoomMessagesErrorResponse("error: room not found", responseFormat)
+	}
+
+	room.Lock()
+
+	if room.IsDeleted {
+		room.Unlock()
+
+		return util.BuildDirectRoomMessagesErrorResponse("error: room not found", responseFormat)
+	}
+
+	if errorResponse := checkDirectRoomPasswordNonLocking(room, roomPassword, clientIp, responseFormat); errorResponse != nil {
+		room.Unlock()
+
+		return errorResponse
+	}
+
+	searchLimit = getSearchPageLimit(searchLimit)
+
+	foundMessages, hitsCount, hasMoreHits := searchRoomMessagesNonLocking(room, searchQuery, beforeMessageId, searchLimit)
+
+	foundMessagesCopy := make([]domain_structures.RoomMessage, len(foundMessages))
+
+	for i, foundMessage := range foundMessages {
+		foundMessagesCopy[i] = *foundMessage
+	}
+
+	userNameByUserInRoomUUID := make(map[string]string)
+
+	for _, user := range room.AllRoomAuthorizedUsersBySessionUUID {
+		userNameByUserInRoomUUID[user.UserInRoomUUID] = user.UserName
+	}
+
+	room.Unlock()
+
+	if responseFormat == "json" {
+		messagesArray := make([]map[string]interface{}, len(foundMessagesCopy))
+
+		for i, message := range foundMessagesCopy {
+			messagesArray[i] = map[string]interface{}{
+				"id":        message.Id,
+				"text":      unescapeDirectMessageText(message.Text),
+				"userName":  unescapeDirectMessageUserName(userNameByUserInRoomUUID, message.UserInRoomUUID),
+				"createdAt": message.CreatedAtSec,
+			}
+		}
+
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"hitsCount":     hitsCount,
+			"messagesCount": len(messagesArray),
+			"hasMore":       hasMoreHits,
+			"messages":      messagesArray,
+		})
+
+		if err != nil {
+			util.LogSevere("Failed to serialize 'direct room messages search response'. err: '%s'", err)
+
+			return []byte("{\"error\": \"failed to serialize json\"}")
+		}
+
+		return jsonData
+
+	} else {
+		var sb strings.Builder
+
+		if !quiteMode {
+			sb.WriteString("URL params\n")
+			sb.WriteString("- send room password: 'p=myPassword'\n")
+			sb.WriteString("- search words: 'q=hello world' (last word may be incomplete)\n")
+			sb.WriteString("- search by author: 'u=userName'\n")
+			sb.WriteString("- search by time: 'from=1700000000' and/or 'to=1700086400' (unix seconds)\n")
+			sb.WriteString("- limit messages: 'l=5' to get only 5 latest hits\n")
+			sb.WriteString("- next page: 'before=8' to get only hits older than message id 8\n")
+			sb.WriteString("- format response as json: 'format=json'\n")
+			sb.WriteString("- dont send this help text: 'quite=true'\n")
+			sb.WriteString("\n")
+		}
+
+		sb.WriteString(fmt.Sprintf("Showing %d of %d matching room messages below this line (newest first)\n\n", len(foundMessagesCopy), hitsCount))
+
+		for _, message := range foundMessagesCopy {
+			sb.WriteString(fmt.Sprintf("#%d [%s] %s: %s\n",
+				message.Id,
+				time.Unix(message.CreatedAtSec, 0).UTC().Format(time.RFC3339),
+				unescapeDirectMessageUserName(userNameByUserInRoomUUID, message.UserInRoomUUID),
+				unescapeDirectMessageText(message.Text)))
+		}
+
+		if hasMoreHits && len(foundMessagesCopy) > 0 {
+			sb.WriteString(fmt.Sprintf("\nmore hits: 'before=%d'\n", foundMessagesCopy[len(foundMessagesCopy)-1].Id))
+		}
+
+		sb.WriteString("\n")
+
+		return []byte(sb.String())
+	}
+}
+
+// returns page of matching messages (newest first) with id lower than beforeMessageId (if set), total hits count and whether
+// there are more hits after this page. Must be executed under room lock
+func searchRoomMessagesNonLocking(
+	room *domain_structures.Room,
+	searchQuery domain_structures.MessageSearchQuery,
+	beforeMessageId int64,
+	limit int,
+) ([]*domain_structures.RoomMessage, int, bool) {
+	var candidateMessageIds map[int64]bool
+
+	if strings.TrimSpace(searchQuery.Text) != "" {
+		candidateMessageIds = getRoomSearchIndexNonLocking(room).Find(domain_structures.TokenizeSearchText(searchQuery.Text))
+	} else {
+		candidateMessageIds = make(map[int64]bool, len(room.RoomMessages))
+
+		for messageId := range room.RoomMessages {
+			candidateMessageIds[messageId] = true
+		}
+	}
+
+	var authorUserInRoomUUIDs map[string]bool
+
+	if searchQuery.UserName != "" {
+		authorUserInRoomUUIDs = findUsersByNamePartNonLocking(room, searchQuery.UserName)
+	}
+
+	var hitMessageIds []int64
+
+	for messageId := range candidateMessageIds {
+		message, found := room.RoomMessages[messageId]
+
+		if !found {
+			continue
+		}
+
+		if searchQuery.FromSec > 0 && message.CreatedAtSec < searchQuery.FromSec {
+			continue
+		}
+
+		if searchQuery.ToSec > 0 && message.CreatedAtSec > searchQuery.ToSec {
+			continue
+		}
+
+		if authorUserInRoomUUIDs != nil && !authorUserInRoomUUIDs[message.UserInRoomUUID] {
+			continue
+		}
+
+		hitMessageIds = append(hitMessageIds, messageId)
+	}
+
+	sort.Slice(hitMessageIds, func(i, j int) bool {
+		return hitMessageIds[i] > hitMessageIds[j]
+	})
+
+	hitsCount := len(hitMessageIds)
+
+	if beforeMessageId > 0 {
+		firstPageIdx := sort.Search(len(hitMessageIds), func(i int) bool {
+			return hitMessageIds[i] < beforeMessageId
+		})
+
+		hitMessageIds = hitMessageIds[firstPageIdx:]
+	}
+
+	hasMoreHits := false
+
+	if len(hitMessageIds) > limit {
+		hitMessageIds = hitMessageIds[:limit]
+		hasMoreHits = true
+	}
+
+	foundMessages := make([]*domain_structures.RoomMessage, len(hitMessageIds))
+
+	for i, messageId := range hitMessageIds {
+		foundMessages[i] = room.RoomMessages[messageId]
+	}
+
+	return foundMessages, hitsCount, hasMoreHits
+}
+
+// must be executed under room lock
+func findUsersByNamePartNonLocking(room *domain_structures.Room, userNamePart string) map[string]bool {
+	userNamePart = strings.ToLower(strings.TrimSpace(userNamePart))
+	userInRoomUUIDs := make(map[string]bool)
+
+	for _, user := range room.AllRoomAuthorizedUsersBySessionUUID {
+		userName, err := url.QueryUnescape(user.UserName)
+
+		if err != nil {
+			continue
+		}
+
+		if strings.Contains(strings.ToLower(userName), userNamePart) {
+			userInRoomUUIDs[user.UserInRoomUUID] = true
+		}
+	}
+
+	return userInRoomUUIDs
+}
+
+// index is built on first search only - most rooms are never searched. Must be executed under room lock
+func getRoomSearchIndexNonLocking(room *domain_structures.Room) *domain_structures.MessageSearchIndex {
+	if room.SearchIndex == nil {
+		room.SearchIndex = domain_structures.NewMessageSearchIndex()
+
+		for _, message := range room.RoomMessages {
+			indexRoomMessageNonLocking(room, message)
+		}
+	}
+
+	return room.SearchIndex
+}
+
+// keeps search index (if it is built already) up to date with new or edited message. Must be executed under room lock
+func indexRoomMessageNonLocking(room *domain_structures.Room, message *domain_structures.RoomMessage) {
+	if room.SearchIndex == nil || message.RecipientUserInRoomUUID != "" {
+		return
+	}
+
+	text, err := url.QueryUnescape(message.Text)
+
+	if err != nil || strings.HasPrefix(text, DrawingMessageMetaMarker) {
+		room.SearchIndex.Remove(message.Id)
+
+		return
+	}
+
+	room.SearchIndex.Add(message.Id, text)
+}
+
+// must be executed under room lock
+func unindexRoomMessageNonLocking(room *domain_structures.Room, messageId int64) {
+	if room.SearchIndex != nil {
+		room.SearchIndex.Remove(messageId)
+	}
+}
+
+func isValidMessageSearchQuery(searchQuery domain_structures.MessageSearchQuery) bool {
+	hasCriteria := strings.TrimSpace(searchQuery.Text) != "" || strings.TrimSpace(searchQuery.UserName) != "" ||
+		searchQuery.FromSec > 0 || searchQuery.ToSec > 0
+
+	return hasCriteria && len(searchQuery.Text) <= SearchQueryMaxLength && len(searchQuery.UserName) <= SearchQueryMaxLength
+}
+
+func getSearchPageLimit(requestedLimit int) int {
+	if requestedLimit <= 0 {
+		return SearchPageDefaultLimit
+	} else if requestedLimit > SearchPageMaxLimit {
+		return SearchPageMaxLimit
+	}
+
+	return requestedLimit
+}
+
+func unescapeDirectMessageText(text string) string {
+	unescapedText, err := url.QueryUnescape(text)
+
+	if err != nil {
+		return fmt.Sprintf("system: failed to unescape message: %s", err)
+	}
+
+	return unescapedText
+}
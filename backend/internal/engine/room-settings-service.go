@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"strconv"
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/config"
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Variables */
+
+// room members are warned when messages count reaches these shares (in percents) of room's messages limit
+var RoomMessagesLimitApproachingWarningPercents = []int{92, 96, 99}
+
+// bounds for room settings (may be overridden by app config)
+var RoomMaxUsersBounds = config.RoomSettingBounds{Min: 2, Default: 100, Max: 100}
+var RoomMessagesLimitBounds = config.RoomSettingBounds{Min: 50, Default: 500, Max: 500}
+var RoomInactiveTtlSecBounds = config.RoomSettingBounds{Min: 10 * 60, Default: 12 * 60 * 60, Max: 7 * 24 * 60 * 60}
+var RoomMaxMessageLengthBounds = config.RoomSettingBounds{Min: 100, Default: 10000, Max: 10000}
+
+// processes room settings change. Only room owner is allowed to change settings, zero values are left unchanged
+func processRoomSettingsCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	if !isRoomOwner(room, clSocket.SessionUUID) {
+		room.Unlock()
+
+		util.LogWarn("failed to change room settings - user '%s' is not an owner of room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotPermitted, inFrame.RequestId)
+
+		return
+	}
+
+	if inFrame.RoomSettings == nil {
+		room.Unlock()
+
+		util.LogTrace("failed to change room settings - no settings given. Room: '%s'", room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+
+		return
+	}
+
+	newRoomSettings := mergeRoomSettings(room.Settings, inFrame.RoomSettings)
+
+	if !areRoomSettingsWithinBounds(newRoomSettings) {
+		room.Unlock()
+
+		util.LogTrace("failed to change room settings - settings '%+v' are out of bounds. Room: '%s'", newRoomSettings, room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomValidationErrorBadSettings, inFrame.RequestId)
+
+		return
+	}
+
+	util.LogTrace("user '%s' is changing settings of room '%s' to '%+v'", clSocket.SessionUUID, room.Name, newRoomSettings)
+
+	room.Settings = newRoomSettings
+
+	ActiveRoomStore.SaveRoomInfo(room)
+
+	//lowered history size is applied right away
+	lowestMessageIdAfterShrink := checkLimitAndShrinkMessagesList(room)
+	droppedWhisperDeleteFrames, droppedWhisperAudiencesSocketsByUUID := dropOldestWhisperMessagesNonLocking(room)
+
+	roomSettingsDispatchingFrame := makeRoomSettingsFrame(room)
+
+	recordRoomEvent(room, roomSettingsDispatchingFrame)
+
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	room.Unlock()
+
+	writeFrameToActiveRoomMembers(roomSettingsDispatchingFrame, room, roomActiveClientSocketsByUUID)
+
+	for i, droppedWhisperDeleteFrame := range droppedWhisperDeleteFrames {
+		writeFrameToActiveRoomMembers(droppedWhisperDeleteFrame, room, droppedWhisperAudiencesSocketsByUUID[i])
+	}
+
+	if lowestMessageIdAfterShrink != int64(-1) {
+		lowestMessageIdAfterShrinkStr := strconv.FormatInt(lowestMessageIdAfterShrink, 10)
+
+		writeNotificationToActiveRoomMembers(domain_structures.NotifyMessagesLimitReached, room, roomActiveClientSocketsByUUID, &lowestMessageIdAfterShrinkStr, true)
+	}
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// settings for new room - requested ones (if any) over defaults. Returns false if some requested value is out of bounds
+func makeNewRoomSettings(requestedSettings *domain_structures.RoomSettings) (domain_structures.RoomSettings, bool) {
+	newRoomSettings := mergeRoomSettings(normalizeRoomSettings(nil), requestedSettings)
+
+	return newRoomSettings, areRoomSettingsWithinBounds(newRoomSettings)
+}
+
+// puts non-zero requested values over current settings
+func mergeRoomSettings(currentSettings domain_structures.RoomSettings, requestedSettings *domain_structures.RoomSettings) domain_structures.RoomSettings {
+	if requestedSettings == nil {
+		return currentSettings
+	}
+
+	if requestedSettings.MaxUsers != 0 {
+		currentSettings.MaxUsers = requestedSettings.MaxUsers
+	}
+
+	if requestedSettings.MessagesLimit != 0 {
+		currentSettings.MessagesLimit = requestedSettings.MessagesLimit
+	}
+
+	if requestedSettings.InactiveTtlSec != 0 {
+		currentSettings.InactiveTtlSec = requestedSettings.InactiveTtlSec
+	}
+
+	if requestedSettings.MaxMessageLength != 0 {
+		currentSettings.MaxMessageLength = requestedSettings.MaxMessageLength
+	}
+
+	return currentSettings
+}
+
+func areRoomSettingsWithinBounds(settings domain_structures.RoomSettings) bool {
+	return isRoomSettingWithinBounds(settings.MaxUsers, RoomMaxUsersBounds) &&
+		isRoomSettingWithinBounds(settings.MessagesLimit, RoomMessagesLimitBounds) &&
+		isRoomSettingWithinBounds(settings.InactiveTtlSec, RoomInactiveTtlSecBounds) &&
+		isRoomSettingWithinBounds(settings.MaxMessageLength, RoomMaxMessageLengthBounds)
+}
+
+func isRoomSettingWithinBounds(value int, bounds config.RoomSettingBounds) bool {
+	return value >= bounds.Min && value <= bounds.Max
+}
+
+// for restored rooms - missing values (rooms saved by older version) get defaults, values that are out of
+// (possibly narrowed by admin) bounds are cut to them. Nil settings give all defaults
+func normalizeRoomSettings(settings *domain_structures.RoomSettings) domain_structures.RoomSettings {
+	normalizedSettings := domain_structures.RoomSettings{}
+
+	if settings != nil {
+		normalizedSettings = *settings
+	}
+
+	normalizedSettings.MaxUsers = normalizeRoomSetting(normalizedSettings.MaxUsers, RoomMaxUsersBounds)
+	normalizedSettings.MessagesLimit = normalizeRoomSetting(normalizedSettings.MessagesLimit, RoomMessagesLimitBounds)
+	normalizedSettings.InactiveTtlSec = normalizeRoomSetting(normalizedSettings.InactiveTtlSec, RoomInactiveTtlSecBounds)
+	normalizedSettings.MaxMessageLength = normalizeRoomSetting(normalizedSettings.MaxMessageLength, RoomMaxMessageLengthBounds)
+
+	return normalizedSettings
+}
+
+func normalizeRoomSetting(value int, bounds config.RoomSettingBounds) int {
+	if value == 0 {
+		return bounds.Default
+	} else if value < bounds.Min {
+		return bounds.Min
+	} else if value > bounds.Max {
+		return bounds.Max
+	}
+
+	return value
+}
+
+// must be executed under room lock
+func makeRoomSettingsFrame(room *domain_structures.Room) *domain_structures.OutMessageFrame {
+	roomSettingsCopy := room.Settings
+	createdAt := time.Now().UnixNano()
+
+	return &domain_structures.OutMessageFrame{
+		Command:       domain_structures.RoomChangeSettings,
+		CreatedAtNano: &createdAt,
+		RoomSettings:  &roomSettingsCopy,
+	}
+}
+
+func isRoomMessagesLimitApproaching(room *domain_structures.Room) bool {
+	for _, warningPercent := range RoomMessagesLimitApproachingWarningPercents {
+		if room.RoomMessagesLen == room.Settings.MessagesLimit*warningPercent/100 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getRoomInactiveTtl(room *domain_structures.Room) time.Duration {
+	return time.Duration(room.Settings.InactiveTtlSec) * time.Second
+}
@@ -0,0 +1,369 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const RoomsSnapshotFormatVersion = 1
+
+const RoomsSnapshotFileName = "rooms-snapshot.json"
+const RoomsSnapshotTmpFileName = "rooms-snapshot.json.tmp"
+
+var RoomsSnapshotVersionMismatch = errors.New("unsupported rooms snapshot version")
+
+/* Variables */
+
+// set from app config
+var RoomSnapshotDirPath = ""
+var RoomSnapshotInterval = 300 * time.Second
+
+var stopRoomSnapshotTimers = false
+
+// prevents periodical and shutdown snapshots from writing file concurrently
+var roomsSnapshotWriteMutex = sync.Mutex{}
+
+func StartRoomSnapshotTimer() {
+	util.LogInfo("Starting RoomSnapshot timer, interval: '%s', dir: '%s'", RoomSnapshotInterval, RoomSnapshotDirPath)
+
+	timer := time.NewTimer(RoomSnapshotInterval)
+
+	go runRoomSnapshotTimer(timer)
+}
+
+func runRoomSnapshotTimer(timer *time.Timer) {
+	if stopRoomSnapshotTimers {
+		return
+	}
+
+	//wait for timer to tick
+	<-timer.C
+
+	if stopRoomSnapshotTimers {
+		return
+	}
+
+	if err := SaveRoomsSnapshot(); err != nil {
+		util.LogSevere("Failed to save periodical rooms snapshot: '%s'", err)
+	}
+
+	util.LogTrace("Restarting RoomSnapshot timer")
+
+	timer.Reset(RoomSnapshotInterval)
+
+	go runRoomSnapshotTimer(timer)
+}
+
+func StopRoomSnapshotTimer() {
+	util.LogInfo("Stopping RoomSnapshot timer")
+
+	stopRoomSnapshotTimers = true
+}
+
+// serializes all active rooms and writes them to snapshot file. File is replaced atomically so crash during write won't corrupt previous snapshot
+func SaveRoomsSnapshot() error {
+	roomsSnapshotWriteMutex.Lock()
+	defer roomsSnapshotWriteMutex.Unlock()
+
+	activeRoomsByName, _ := ActiveRoomsByNameMap.CopyActiveRoomsByNameMap()
+
+	roomsSnapshot := domain_structures.RoomsSnapshot{
+		Version:   RoomsSnapshotFormatVersion,
+		CreatedAt: time.Now().UnixNano(),
+		Rooms:     make([]domain_structures.RoomSnapshot, 0, len(*activeRoomsByName)),
+	}
+
+	for _, room := range *activeRoomsByName {
+		room.Lock()
+
+		if !room.IsDeleted {
+			roomsSnapshot.Rooms = append(roomsSnapshot.Rooms, makeRoomSnapshot(room))
+		}
+
+		room.Unlock()
+	}
+
+	snapshotJson, err := json.Marshal(roomsSnapshot)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(RoomSnapshotDirPath, os.ModePerm)
+
+	if err != nil {
+		return err
+	}
+
+	tmpFilePath := filepath.Join(RoomSnapshotDirPath, RoomsSnapshotTmpFileName)
+
+	err = os.WriteFile(tmpFilePath, snapshotJson, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpFilePath, filepath.Join(RoomSnapshotDirPath, RoomsSnapshotFileName))
+
+	if err != nil {
+		return err
+	}
+
+	util.LogInfo("Saved rooms snapshot: '%d' rooms, '%d' bytes", len(roomsSnapshot.Rooms), len(snapshotJson))
+
+	return nil
+}
+
+// reads snapshot file (if any) and puts all rooms from it back into active rooms map. Must be called on startup, after metrics are set up
+func RestoreRoomsFromSnapshot() error {
+	snapshotFilePath := filepath.Join(RoomSnapshotDirPath, RoomsSnapshotFileName)
+
+	snapshotJson, err := os.ReadFile(snapshotFilePath)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			util.LogInfo("No rooms snapshot found at '%s', nothing to restore", snapshotFilePath)
+
+			return nil
+		}
+
+		return err
+	}
+
+	var roomsSnapshot domain_structures.RoomsSnapshot
+
+	err = json.Unmarshal(snapshotJson, &roomsSnapshot)
+
+	if err != nil {
+		return err
+	}
+
+	if roomsSnapshot.Version != RoomsSnapshotFormatVersion {
+		return fmt.Errorf("%w: got '%d', expected '%d'", RoomsSnapshotVersionMismatch, roomsSnapshot.Version, RoomsSnapshotFormatVersion)
+	}
+
+	restoredRoomsCount := 0
+
+	for i := range roomsSnapshot.Rooms {
+		room := restoreRoomFromSnapshot(&roomsSnapshot.Rooms[i])
+
+		if registerRestoredRoom(room) {
+			//make room store aware of restored room (no-op for in-memory store)
+			room.Lock()
+			ActiveRoomStore.SaveRoom(room)
+			room.Unlock()
+
+			restoredRoomsCount++
+		} else {
+			util.LogWarn("Skipped restoring room '%s' / '%s' from snapshot - room with same name already exists", room.Id, room.Name)
+		}
+	}
+
+	util.LogInfo("Restored '%d' rooms from snapshot created at '%s'", restoredRoomsCount, time.Unix(0, roomsSnapshot.CreatedAt))
+
+	return nil
+}
+
+// must be executed under room lock
+func makeRoomSnapshot(room *domain_structures.Room) domain_structures.RoomSnapshot {
+	authorizedUsersCopy := make(map[string]*domain_structures.RoomUser, len(room.AllRoomAuthorizedUsersBySessionUUID))
+
+	for sessionUUID, user := range room.AllRoomAuthorizedUsersBySessionUUID {
+		userCopy := *user
+		authorizedUsersCopy[sessionUUID] = &userCopy
+	}
+
+	messagesCopy := make([]*domain_structures.RoomMessage, 0, len(room.RoomMessages))
+
+	for _, message := range room.RoomMessages {
+		messageCopy := *message
+
+		if message.ReactionCounts != nil {
+			messageCopy.ReactionCounts = make(map[string]int, len(message.ReactionCounts))
+
+			for reaction, count := range message.ReactionCounts {
+				messageCopy.ReactionCounts[reaction] = count
+			}
+		}
+
+		messagesCopy = append(messagesCopy, &messageCopy)
+	}
+
+	whispersCopy := make([]*domain_structures.RoomMessage, 0, len(room.WhisperMessages))
+
+	for _, whisperMessage := range room.WhisperMessages {
+		whisperMessageCopy := *whisperMessage
+		whispersCopy = append(whispersCopy, &whisperMessageCopy)
+	}
+
+	votesCopy := make(map[int64]*domain_structures.RoomMessageVotes, len(room.MessageVotesByMessageId))
+
+	for messageId, votes := range room.MessageVotesByMessageId {
+		votesCopy[messageId] = &domain_structures.RoomMessageVotes{
+			SupportVotesBySessionUUID: copyBoolMap(votes.SupportVotesBySessionUUID),
+			RejectVotesBySessionUUID:  copyBoolMap(votes.RejectVotesBySessionUUID),
+		}
+
+		if votes.ReactionsBySessionUUID != nil {
+			votesCopy[messageId].ReactionsBySessionUUID = make(map[string]map[string]bool, len(votes.ReactionsBySessionUUID))
+
+			for reaction, reactedSessions := range votes.ReactionsBySessionUUID {
+				votesCopy[messageId].ReactionsBySessionUUID[reaction] = copyBoolMap(reactedSessions)
+			}
+		}
+	}
+
+	roomSettingsCopy := room.Settings
+
+	return domain_structures.RoomSnapshot{
+		Id:                                  room.Id,
+		Name:                                room.Name,
+		PasswordHash:                        room.PasswordHash,
+		Description:                         room.Description,
+		CreatedBySessionUUID:                room.CreatedBySessionUUID,
+		StartedAt:                           room.StartedAt,
+		LastActiveAt:                        room.LastActiveAt,
+		NextMessageId:                       room.NextMessageId,
+		BannedUserInRoomUUIDs:               copyBoolMap(room.BannedUserInRoomUUIDs),
+		ModeratorUserInRoomUUIDs:            copyBoolMap(room.ModeratorUserInRoomUUIDs),
+		OwnerRecoveryKeyHash:                room.OwnerRecoveryKeyHash,
+		PinnedMessageIds:                    append([]int64(nil), room.PinnedMessageIds...),
+		Settings:                            &roomSettingsCopy,
+		IsAnnouncementMode:                  room.IsAnnouncementMode,
+		SlowModeIntervalSec:                 room.SlowModeIntervalSec,
+		MessageEditWindowSec:                room.MessageEditWindowSec,
+		AllRoomAuthorizedUsersBySessionUUID: authorizedUsersCopy,
+		RoomMessages:                        messagesCopy,
+		MessageVotesByMessageId:             votesCopy,
+		WhisperMessages:                     whispersCopy,
+	}
+}
+
+func restoreRoomFromSnapshot(snapshot *domain_structures.RoomSnapshot) *domain_structures.Room {
+	room := &domain_structures.Room{
+		IsDeleted:                           false,
+		Id:                                  snapshot.Id,
+		Name:                                snapshot.Name,
+		PasswordHash:                        snapshot.PasswordHash,
+		Description:                         snapshot.Description,
+		CreatedBySessionUUID:                snapshot.CreatedBySessionUUID,
+		StartedAt:                           snapshot.StartedAt,
+		LastActiveAt:                        snapshot.LastActiveAt,
+		NextMessageId:                       snapshot.NextMessageId,
+		AllRoomAuthorizedUsersBySessionUUID: snapshot.AllRoomAuthorizedUsersBySessionUUID,
+		ActiveRoomUserUUIDBySessionUUID:     make(map[string]string),
+		ActiveRoomUsersLen:                  0,
+		ActiveClientSocketsByUUID:           make(map[string]*domain_structures.WebSocket),
+		PendingReadMarkerUserInRoomUUIDs:    make(map[string]bool),
+		TypingUsersByUserInRoomUUID:         make(map[string]*domain_structures.RoomUserTypingState),
+		MessageRateLimitersBySessionUUID:    make(map[string]*domain_structures.TokenBucket),
+		EditRateLimitersBySessionUUID:       make(map[string]*domain_structures.TokenBucket),
+		RoomMessages:                        make(map[int64]*domain_structures.RoomMessage, len(snapshot.RoomMessages)),
+		MessageVotesByMessageId:             snapshot.MessageVotesByMessageId,
+		WhisperMessages:                     make(map[int64]*domain_structures.RoomMessage, len(snapshot.WhisperMessages)),
+		BannedUserInRoomUUIDs:               snapshot.BannedUserInRoomUUIDs,
+		ModeratorUserInRoomUUIDs:            snapshot.ModeratorUserInRoomUUIDs,
+		OwnerRecoveryKeyHash:                snapshot.OwnerRecoveryKeyHash,
+		PinnedMessageIds:                    snapshot.PinnedMessageIds,
+		Settings:                            normalizeRoomSettings(snapshot.Settings),
+		IsAnnouncementMode:                  snapshot.IsAnnouncementMode,
+		SlowModeIntervalSec:                 snapshot.SlowModeIntervalSec,
+		MessageEditWindowSec:                snapshot.MessageEditWindowSec,
+		LastEventSeq:                        initialRoomEventSeq(),
+	}
+
+	if room.AllRoomAuthorizedUsersBySessionUUID == nil {
+		room.AllRoomAuthorizedUsersBySessionUUID = make(map[string]*domain_structures.RoomUser)
+	}
+
+	if room.MessageVotesByMessageId == nil {
+		room.MessageVotesByMessageId = make(map[int64]*domain_structures.RoomMessageVotes)
+	}
+
+	if room.BannedUserInRoomUUIDs == nil {
+		room.BannedUserInRoomUUIDs = make(map[string]bool)
+	}
+
+	if room.ModeratorUserInRoomUUIDs == nil {
+		room.ModeratorUserInRoomUUIDs = make(map[string]bool)
+	}
+
+	for _, message := range snapshot.RoomMessages {
+		room.RoomMessages[message.Id] = message
+
+		//should not happen, but never reuse ids of existing messages
+		if message.Id >= room.NextMessageId {
+			room.NextMessageId = message.Id + 1
+		}
+	}
+
+	room.RoomMessagesLen = len(room.RoomMessages)
+
+	for _, whisperMessage := range snapshot.WhisperMessages {
+		room.WhisperMessages[whisperMessage.Id] = whisperMessage
+
+		if whisperMessage.Id >= room.NextMessageId {
+			room.NextMessageId = whisperMessage.Id + 1
+		}
+	}
+
+	//drop pins of messages that did not survive
+	pinnedMessageIds := make([]int64, 0, len(room.PinnedMessageIds))
+
+	for _, pinnedMessageId := range room.PinnedMessageIds {
+		if _, found := room.RoomMessages[pinnedMessageId]; found {
+			pinnedMessageIds = append(pinnedMessageIds, pinnedMessageId)
+		}
+	}
+
+	room.PinnedMessageIds = pinnedMessageIds
+
+	//technical users are not expected to be missing, but re-add them in case snapshot was made by older version
+	if _, found := room.AllRoomAuthorizedUsersBySessionUUID[ExternalUserSessionUUID]; !found {
+		addTechnicalUsersToRoom(room)
+	}
+
+	return room
+}
+
+// puts restored room into active rooms map and starts its housekeeper. Returns false if room with same name already exists
+func registerRestoredRoom(room *domain_structures.Room) bool {
+	ActiveRoomsByNameMap.Lock()
+
+	if ActiveRoomsByNameMap.ContainsNonLocking(room.Name) {
+		ActiveRoomsByNameMap.Unlock()
+
+		return false
+	}
+
+	ActiveRoomsByNameMap.ActiveRoomsByName[room.Name] = room
+	RoomsOnlineGauge.Inc()
+
+	ActiveRoomsByNameMap.Unlock()
+
+	util.LogInfo("restored room '%s' / '%s' with '%d' messages", room.Id, room.Name, room.RoomMessagesLen)
+
+	StartSocketHouseKeeper(room)
+
+	return true
+}
+
+func copyBoolMap(orig map[string]bool) map[string]bool {
+	mapCopy := make(map[string]bool, len(orig))
+
+	for k, v := range orig {
+		mapCopy[k] = v
+	}
+
+	return mapCopy
+}
@@ -0,0 +1,559 @@
+package engine
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const BoltOpenTimeout = 5 * time.Second
+
+// writes wait in queue for writer goroutine, senders block only when it is full
+const BoltWriteQueueSize = 10000
+
+// writes queued meanwhile are committed by writer in single transaction (single fsync)
+const BoltWriteBatchMaxSize = 500
+
+// bucket layout: rooms -> <room id> -> (info key, messages, votes, users, whispers)
+var boltRoomsBucket = []byte("rooms")
+var boltRoomInfoKey = []byte("info")
+var boltRoomMessagesBucket = []byte("messages")
+var boltRoomVotesBucket = []byte("votes")
+var boltRoomUsersBucket = []byte("users")
+var boltRoomWhispersBucket = []byte("whispers")
+
+var boltRoomChildBuckets = [][]byte{boltRoomMessagesBucket, boltRoomVotesBucket, boltRoomUsersBucket, boltRoomWhispersBucket}
+
+// embedded on-disk room store (single bbolt file). Applies every change to in-memory room maps first (same as InMemoryRoomStore),
+// then queues it for writing to db file. Changes are marshalled by caller under room lock and written by single writer
+// goroutine in batches - so room lock is never held during disk writes and changes are written in order they were made
+type BoltRoomStore struct {
+	InMemoryRoomStore
+	db *bolt.DB
+
+	writeQueue chan *boltRoomWrite
+	writerDone chan struct{}
+	closeLock  sync.RWMutex
+	isClosed   bool
+}
+
+// pending write of single store operation. Values are already marshalled, so writer does not touch live room data
+type boltRoomWrite struct {
+	roomId        string
+	roomName      string
+	operationName string
+	// whole room bucket is dropped before changes are applied
+	dropRoom bool
+	changes  []boltKeyChange
+	err      error
+}
+
+// nil child bucket name - key of room bucket itself, nil value - key is deleted
+type boltKeyChange struct {
+	childBucketName []byte
+	key             []byte
+	value           []byte
+}
+
+func NewBoltRoomStore(filePath string) (*BoltRoomStore, error) {
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(filePath, 0600, &bolt.Options{Timeout: BoltOpenTimeout})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltRoomsBucket)
+
+		return err
+	})
+
+	if err != nil {
+		db.Close()
+
+		return nil, err
+	}
+
+	store := &BoltRoomStore{
+		db:         db,
+		writeQueue: make(chan *boltRoomWrite, BoltWriteQueueSize),
+		writerDone: make(chan struct{}),
+	}
+
+	go store.runWriter()
+
+	return store, nil
+}
+
+func (s *BoltRoomStore) SaveRoom(room *domain_structures.Room) {
+	s.InMemoryRoomStore.SaveRoom(room)
+
+	snapshot := makeRoomSnapshot(room)
+
+	//room may have been stored before (e.g. moved away and back) - drop old data
+	write := newBoltRoomWrite(room, "save room")
+	write.dropRoom = true
+	write.putRoomInfo(room)
+
+	for _, message := range snapshot.RoomMessages {
+		write.put(boltRoomMessagesBucket, messageIdKey(message.Id), message)
+	}
+
+	for messageId, votes := range snapshot.MessageVotesByMessageId {
+		write.put(boltRoomVotesBucket, messageIdKey(messageId), votes)
+	}
+
+	for sessionUUID, user := range snapshot.AllRoomAuthorizedUsersBySessionUUID {
+		write.put(boltRoomUsersBucket, []byte(sessionUUID), user)
+	}
+
+	for _, whisperMessage := range snapshot.WhisperMessages {
+		write.put(boltRoomWhispersBucket, messageIdKey(whisperMessage.Id), whisperMessage)
+	}
+
+	s.enqueue(write)
+}
+
+func (s *BoltRoomStore) SaveRoomInfo(room *domain_structures.Room) {
+	s.InMemoryRoomStore.SaveRoomInfo(room)
+
+	write := newBoltRoomWrite(room, "save room info")
+	write.putRoomInfo(room)
+
+	s.enqueue(write)
+}
+
+func (s *BoltRoomStore) DeleteRoom(room *domain_structures.Room) {
+	s.InMemoryRoomStore.DeleteRoom(room)
+
+	write := newBoltRoomWrite(room, "delete room")
+	write.dropRoom = true
+
+	s.enqueue(write)
+}
+
+func (s *BoltRoomStore) AddMessage(
+	room *domain_structures.Room,
+	userInRoomUUID string,
+	messageText string,
+	replyToUserId *string,
+	replyToMessageId *int64,
+	ttlSec int64,
+	isFlagged bool,
+	mentionedUserInRoomUUIDs []string,
+) *domain_structures.RoomMessage {
+	newRoomMessage := s.InMemoryRoomStore.AddMessage(room, userInRoomUUID, messageText, replyToUserId, replyToMessageId, ttlSec, isFlagged,
+		mentionedUserInRoomUUIDs)
+
+	//room info holds next message id
+	write := newBoltRoomWrite(room, "add message")
+	write.putRoomInfo(room)
+	write.put(boltRoomMessagesBucket, messageIdKey(newRoomMessage.Id), newRoomMessage)
+
+	s.enqueue(write)
+
+	return newRoomMessage
+}
+
+func (s *BoltRoomStore) AddWhisperMessage(
+	room *domain_structures.Room,
+	userInRoomUUID string,
+	recipientUserInRoomUUID string,
+	messageText string,
+	replyToUserId *string,
+	replyToMessageId *int64,
+	ttlSec int64,
+	isFlagged bool,
+) *domain_structures.RoomMessage {
+	newWhisperMessage := s.InMemoryRoomStore.AddWhisperMessage(room, userInRoomUUID, recipientUserInRoomUUID, messageText, replyToUserId, replyToMessageId, ttlSec, isFlagged)
+
+	//room info holds next message id
+	write := newBoltRoomWrite(room, "add whisper message")
+	write.putRoomInfo(room)
+	write.put(boltRoomWhispersBucket, messageIdKey(newWhisperMessage.Id), newWhisperMessage)
+
+	s.enqueue(write)
+
+	return newWhisperMessage
+}
+
+func (s *BoltRoomStore) EditMessage(
+	room *domain_structures.Room,
+	message *domain_structures.RoomMessage,
+	messageText string,
+	replyToUserId *string,
+	replyToMessageId *int64,
+	editedAt int64,
+	isFlagged bool,
+	mentionedUserInRoomUUIDs []string,
+) {
+	s.InMemoryRoomStore.EditMessage(room, message, messageText, replyToUserId, replyToMessageId, editedAt, isFlagged,
+		mentionedUserInRoomUUIDs)
+
+	messagesBucketName := boltRoomMessagesBucket
+
+	if message.RecipientUserInRoomUUID != "" {
+		messagesBucketName = boltRoomWhispersBucket
+	}
+
+	write := newBoltRoomWrite(room, "edit message")
+	write.put(messagesBucketName, messageIdKey(message.Id), message)
+
+	s.enqueue(write)
+}
+
+func (s *BoltRoomStore) DeleteMessages(room *domain_structures.Room, messageIds []int64) {
+	s.InMemoryRoomStore.DeleteMessages(room, messageIds)
+
+	write := newBoltRoomWrite(room, "delete messages")
+
+	for _, messageId := range messageIds {
+		write.delete(boltRoomMessagesBucket, messageIdKey(messageId))
+		write.delete(boltRoomVotesBucket, messageIdKey(messageId))
+		write.delete(boltRoomWhispersBucket, messageIdKey(messageId))
+	}
+
+	s.enqueue(write)
+}
+
+func (s *BoltRoomStore) ToggleMessageVote(
+	room *domain_structures.Room,
+	message *domain_structures.RoomMessage,
+	sessionUUID string,
+	isSupport bool,
+	votedAt int64,
+) {
+	s.InMemoryRoomStore.ToggleMessageVote(room, message, sessionUUID, isSupport, votedAt)
+
+	write := newBoltRoomWrite(room, "vote for message")
+	write.put(boltRoomMessagesBucket, messageIdKey(message.Id), message)
+	write.put(boltRoomVotesBucket, messageIdKey(message.Id), room.MessageVotesByMessageId[message.Id])
+
+	s.enqueue(write)
+}
+
+func (s *BoltRoomStore) ToggleMessageReaction(
+	room *domain_structures.Room,
+	message *domain_structures.RoomMessage,
+	sessionUUID string,
+	reaction string,
+	reactedAt int64,
+) {
+	s.InMemoryRoomStore.ToggleMessageReaction(room, message, sessionUUID, reaction, reactedAt)
+
+	write := newBoltRoomWrite(room, "react to message")
+	write.put(boltRoomMessagesBucket, messageIdKey(message.Id), message)
+	write.put(boltRoomVotesBucket, messageIdKey(message.Id), room.MessageVotesByMessageId[message.Id])
+
+	s.enqueue(write)
+}
+
+func (s *BoltRoomStore) AuthorizeUser(room *domain_structures.Room, sessionUUID string, user *domain_structures.RoomUser) {
+	s.InMemoryRoomStore.AuthorizeUser(room, sessionUUID, user)
+
+	write := newBoltRoomWrite(room, "authorize user")
+	write.put(boltRoomUsersBucket, []byte(sessionUUID), user)
+
+	s.enqueue(write)
+}
+
+func (s *BoltRoomStore) LoadRooms() ([]*domain_structures.Room, error) {
+	var rooms []*domain_structures.Room
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRoomsBucket).ForEach(func(roomId []byte, _ []byte) error {
+			roomBucket := tx.Bucket(boltRoomsBucket).Bucket(roomId)
+
+			if roomBucket == nil {
+				return nil
+			}
+
+			var snapshot domain_structures.RoomSnapshot
+
+			if err := json.Unmarshal(roomBucket.Get(boltRoomInfoKey), &snapshot); err != nil {
+				util.LogSevere("room store: skipping room '%s' with broken info: '%s'", roomId, err)
+
+				return nil
+			}
+
+			snapshot.AllRoomAuthorizedUsersBySessionUUID = make(map[string]*domain_structures.RoomUser)
+			snapshot.MessageVotesByMessageId = make(map[int64]*domain_structures.RoomMessageVotes)
+
+			err := roomBucket.Bucket(boltRoomMessagesBucket).ForEach(func(k []byte, v []byte) error {
+				var message domain_structures.RoomMessage
+
+				if err := json.Unmarshal(v, &message); err != nil {
+					return err
+				}
+
+				snapshot.RoomMessages = append(snapshot.RoomMessages, &message)
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+
+			err = roomBucket.Bucket(boltRoomVotesBucket).ForEach(func(k []byte, v []byte) error {
+				var votes domain_structures.RoomMessageVotes
+
+				if err := json.Unmarshal(v, &votes); err != nil {
+					return err
+				}
+
+				snapshot.MessageVotesByMessageId[int64(binary.BigEndian.Uint64(k))] = &votes
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+
+			err = roomBucket.Bucket(boltRoomUsersBucket).ForEach(func(k []byte, v []byte) error {
+				var user domain_structures.RoomUser
+
+				if err := json.Unmarshal(v, &user); err != nil {
+					return err
+				}
+
+				snapshot.AllRoomAuthorizedUsersBySessionUUID[string(k)] = &user
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+
+			//room stored by older version may have no whispers bucket
+			if whispersBucket := roomBucket.Bucket(boltRoomWhispersBucket); whispersBucket != nil {
+				err = whispersBucket.ForEach(func(k []byte, v []byte) error {
+					var whisperMessage domain_structures.RoomMessage
+
+					if err := json.Unmarshal(v, &whisperMessage); err != nil {
+						return err
+					}
+
+					snapshot.WhisperMessages = append(snapshot.WhisperMessages, &whisperMessage)
+
+					return nil
+				})
+
+				if err != nil {
+					return err
+				}
+			}
+
+			rooms = append(rooms, restoreRoomFromSnapshot(&snapshot))
+
+			return nil
+		})
+	})
+
+	return rooms, err
+}
+
+// waits until all queued writes are committed. Writes made after close are dropped
+func (s *BoltRoomStore) Close() error {
+	s.closeLock.Lock()
+
+	if s.isClosed {
+		s.closeLock.Unlock()
+
+		return nil
+	}
+
+	s.isClosed = true
+	close(s.writeQueue)
+
+	s.closeLock.Unlock()
+
+	<-s.writerDone
+
+	return s.db.Close()
+}
+
+// queues write for writer goroutine. Errors are logged, in-memory state stays primary
+func (s *BoltRoomStore) enqueue(write *boltRoomWrite) {
+	if write.err != nil {
+		util.LogSevere("room store: failed to %s, room '%s' / '%s': '%s'", write.operationName, write.roomId, write.roomName, write.err)
+
+		return
+	}
+
+	s.closeLock.RLock()
+	defer s.closeLock.RUnlock()
+
+	if s.isClosed {
+		util.LogWarn("room store: dropped '%s' of room '%s' / '%s' - store is closed", write.operationName, write.roomId, write.roomName)
+
+		return
+	}
+
+	s.writeQueue <- write
+}
+
+func (s *BoltRoomStore) runWriter() {
+	defer close(s.writerDone)
+
+	for write := range s.writeQueue {
+		writes := []*boltRoomWrite{write}
+
+		for len(writes) < BoltWriteBatchMaxSize && len(s.writeQueue) > 0 {
+			writes = append(writes, <-s.writeQueue)
+		}
+
+		s.commitWrites(writes)
+	}
+}
+
+// commits writes in single transaction. If it fails, writes are retried one by one - so broken write does not take others with it
+func (s *BoltRoomStore) commitWrites(writes []*boltRoomWrite) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, write := range writes {
+			if err := applyBoltRoomWrite(tx, write); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		return
+	}
+
+	for _, write := range writes {
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			return applyBoltRoomWrite(tx, write)
+		})
+
+		if err != nil {
+			util.LogSevere("room store: failed to %s, room '%s' / '%s': '%s'", write.operationName, write.roomId, write.roomName, err)
+		}
+	}
+}
+
+// applies write to room's bucket (creating it if needed)
+func applyBoltRoomWrite(tx *bolt.Tx, write *boltRoomWrite) error {
+	roomsBucket := tx.Bucket(boltRoomsBucket)
+
+	if write.dropRoom {
+		if err := roomsBucket.DeleteBucket([]byte(write.roomId)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		if len(write.changes) == 0 {
+			return nil
+		}
+	}
+
+	roomBucket, err := roomsBucket.CreateBucketIfNotExists([]byte(write.roomId))
+
+	if err != nil {
+		return err
+	}
+
+	for _, childBucketName := range boltRoomChildBuckets {
+		if _, err := roomBucket.CreateBucketIfNotExists(childBucketName); err != nil {
+			return err
+		}
+	}
+
+	for _, change := range write.changes {
+		bucket := roomBucket
+
+		if change.childBucketName != nil {
+			bucket = roomBucket.Bucket(change.childBucketName)
+		}
+
+		if change.value == nil {
+			err = bucket.Delete(change.key)
+		} else {
+			err = bucket.Put(change.key, change.value)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newBoltRoomWrite(room *domain_structures.Room, operationName string) *boltRoomWrite {
+	return &boltRoomWrite{
+		roomId:        room.Id,
+		roomName:      room.Name,
+		operationName: operationName,
+	}
+}
+
+// value is marshalled right away - must be called under room lock
+func (w *boltRoomWrite) put(childBucketName []byte, key []byte, value interface{}) {
+	if w.err != nil {
+		return
+	}
+
+	valueJson, err := json.Marshal(value)
+
+	if err != nil {
+		w.err = err
+
+		return
+	}
+
+	w.changes = append(w.changes, boltKeyChange{childBucketName: childBucketName, key: key, value: valueJson})
+}
+
+func (w *boltRoomWrite) delete(childBucketName []byte, key []byte) {
+	w.changes = append(w.changes, boltKeyChange{childBucketName: childBucketName, key: key})
+}
+
+// room-level fields are stored as snapshot without messages/users/votes
+func (w *boltRoomWrite) putRoomInfo(room *domain_structures.Room) {
+	w.put(nil, boltRoomInfoKey, domain_structures.RoomSnapshot{
+		Id:                   room.Id,
+		Name:                 room.Name,
+		PasswordHash:         room.PasswordHash,
+		Description:          room.Description,
+		CreatedBySessionUUID: room.CreatedBySessionUUID,
+		StartedAt:            room.StartedAt,
+		LastActiveAt:         room.LastActiveAt,
+		NextMessageId:        room.NextMessageId,
+
+		BannedUserInRoomUUIDs:    room.BannedUserInRoomUUIDs,
+		ModeratorUserInRoomUUIDs: room.ModeratorUserInRoomUUIDs,
+		OwnerRecoveryKeyHash:     room.OwnerRecoveryKeyHash,
+		PinnedMessageIds:         room.PinnedMessageIds,
+		Settings:                 &room.Settings,
+		IsAnnouncementMode:       room.IsAnnouncementMode,
+		SlowModeIntervalSec:      room.SlowModeIntervalSec,
+		MessageEditWindowSec:     room.MessageEditWindowSec,
+	})
+}
+
+// big-endian keys keep messages sorted by id inside bucket
+func messageIdKey(messageId int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(messageId))
+
+	return key
+}
@@ -0,0 +1,333 @@
+package engine
+
+import (
+	"errors"
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const RoomStoreTypeMemory = "memory"
+const RoomStoreTypeBolt = "bolt"
+
+var UnknownRoomStoreType = errors.New("unknown room store type")
+
+// RoomStore is the single entry point for changing room data (messages, votes, authorized users).
+// Room maps held in RAM always stay the primary copy that is used for reads,
+// implementations may additionally persist every change so rooms survive backend restart.
+// All methods except LoadRooms/Close must be called under room lock.
+// Persistence failures are logged by implementation and never break in-memory operation
+type RoomStore interface {
+	// persists whole room state (e.g. newly created or restored room)
+	SaveRoom(room *domain_structures.Room)
+	// persists room-level fields only (description, password etc.)
+	SaveRoomInfo(room *domain_structures.Room)
+	DeleteRoom(room *domain_structures.Room)
+
+	// ttlSec > 0 makes message self-destructing, isFlagged is set by message filters
+	AddMessage(room *domain_structures.Room, userInRoomUUID string, messageText string, replyToUserId *string,
+		replyToMessageId *int64, ttlSec int64, isFlagged bool, mentionedUserInRoomUUIDs []string) *domain_structures.RoomMessage
+	AddWhisperMessage(room *domain_structures.Room, userInRoomUUID string, recipientUserInRoomUUID string, messageText string,
+		replyToUserId *string, replyToMessageId *int64, ttlSec int64, isFlagged bool) *domain_structures.RoomMessage
+	// edits both public messages and whispers, previous text is kept as message revision
+	EditMessage(room *domain_structures.Room, message *domain_structures.RoomMessage, messageText string,
+		replyToUserId *string, replyToMessageId *int64, editedAt int64, isFlagged bool, mentionedUserInRoomUUIDs []string)
+	// deletes both public messages and whispers
+	DeleteMessages(room *domain_structures.Room, messageIds []int64)
+	ToggleMessageVote(room *domain_structures.Room, message *domain_structures.RoomMessage, sessionUUID string,
+		isSupport bool, votedAt int64)
+	// toggles reaction other than support/reject ones (those go through ToggleMessageVote)
+	ToggleMessageReaction(room *domain_structures.Room, message *domain_structures.RoomMessage, sessionUUID string,
+		reaction string, reactedAt int64)
+
+	// adds new authorized user or saves changes of existing one (e.g. new name)
+	AuthorizeUser(room *domain_structures.Room, sessionUUID string, user *domain_structures.RoomUser)
+
+	// returns all rooms persisted by previous run (empty for non-persistent stores)
+	LoadRooms() ([]*domain_structures.Room, error)
+	Close() error
+}
+
+/* Variables */
+
+var ActiveRoomStore RoomStore = &InMemoryRoomStore{}
+
+func InitRoomStore(storeType string, boltFilePath string) error {
+	switch storeType {
+	case "", RoomStoreTypeMemory:
+		ActiveRoomStore = &InMemoryRoomStore{}
+
+	case RoomStoreTypeBolt:
+		boltStore, err := NewBoltRoomStore(boltFilePath)
+
+		if err != nil {
+			return err
+		}
+
+		ActiveRoomStore = boltStore
+
+	default:
+		return UnknownRoomStoreType
+	}
+
+	util.LogInfo("Room store initialized: '%s'", storeType)
+
+	return nil
+}
+
+// puts all rooms persisted by room store back into active rooms map. Must be called on startup, after metrics are set up
+func RestoreRoomsFromStore() error {
+	rooms, err := ActiveRoomStore.LoadRooms()
+
+	if err != nil {
+		return err
+	}
+
+	restoredRoomsCount := 0
+
+	for _, room := range rooms {
+		if registerRestoredRoom(room) {
+			restoredRoomsCount++
+		} else {
+			util.LogWarn("Skipped restoring room '%s' / '%s' from room store - room with same name already exists", room.Id, room.Name)
+		}
+	}
+
+	util.LogInfo("Restored '%d' rooms from room store", restoredRoomsCount)
+
+	return nil
+}
+
+/* In-memory implementation */
+
+// keeps room data only in room maps, i.e. data is lost on restart
+type InMemoryRoomStore struct{}
+
+func (s *InMemoryRoomStore) SaveRoom(room *domain_structures.Room) {}
+
+func (s *InMemoryRoomStore) SaveRoomInfo(room *domain_structures.Room) {}
+
+func (s *InMemoryRoomStore) DeleteRoom(room *domain_structures.Room) {}
+
+func (s *InMemoryRoomStore) AddMessage(
+	room *domain_structures.Room,
+	userInRoomUUID string,
+	messageText string,
+	replyToUserId *string,
+	replyToMessageId *int64,
+	ttlSec int64,
+	isFlagged bool,
+	mentionedUserInRoomUUIDs []string,
+) *domain_structures.RoomMessage {
+	newRoomMessage := &domain_structures.RoomMessage{
+		Id:               room.NextMessageId,
+		Text:             messageText,
+		SupportedCount:   0,
+		RejectedCount:    0,
+		UserInRoomUUID:   userInRoomUUID,
+		CreatedAtSec:     time.Now().Unix(),
+		ReplyToUserId:    replyToUserId,
+		ReplyToMessageId: replyToMessageId,
+		IsFlagged:        isFlagged,
+
+		MentionedUserInRoomUUIDs: mentionedUserInRoomUUIDs,
+	}
+
+	setMessageTtl(newRoomMessage, ttlSec)
+
+	room.NextMessageId += 1
+
+	room.RoomMessages[newRoomMessage.Id] = newRoomMessage
+	room.RoomMessagesLen = len(room.RoomMessages)
+
+	indexRoomMessageNonLocking(room, newRoomMessage)
+
+	return newRoomMessage
+}
+
+func (s *InMemoryRoomStore) AddWhisperMessage(
+	room *domain_structures.Room,
+	userInRoomUUID string,
+	recipientUserInRoomUUID string,
+	messageText string,
+	replyToUserId *string,
+	replyToMessageId *int64,
+	ttlSec int64,
+	isFlagged bool,
+) *domain_structures.RoomMessage {
+	newWhisperMessage := &domain_structures.RoomMessage{
+		Id:                      room.NextMessageId,
+		Text:                    messageText,
+		UserInRoomUUID:          userInRoomUUID,
+		CreatedAtSec:            time.Now().Unix(),
+		ReplyToUserId:           replyToUserId,
+		ReplyToMessageId:        replyToMessageId,
+		RecipientUserInRoomUUID: recipientUserInRoomUUID,
+		IsFlagged:               isFlagged,
+	}
+
+	setMessageTtl(newWhisperMessage, ttlSec)
+
+	room.NextMessageId += 1
+
+	room.WhisperMessages[newWhisperMessage.Id] = newWhisperMessage
+
+	return newWhisperMessage
+}
+
+func (s *InMemoryRoomStore) EditMessage(
+	room *domain_structures.Room,
+	message *domain_structures.RoomMessage,
+	messageText string,
+	replyToUserId *string,
+	replyToMessageId *int64,
+	editedAt int64,
+	isFlagged bool,
+	mentionedUserInRoomUUIDs []string,
+) {
+	addMessageRevisionNonLocking(message)
+
+	message.Text = messageText
+	message.ReplyToUserId = replyToUserId
+	message.ReplyToMessageId = replyToMessageId
+	message.LastEditedAt = &editedAt
+	message.IsFlagged = isFlagged
+	message.MentionedUserInRoomUUIDs = mentionedUserInRoomUUIDs
+
+	indexRoomMessageNonLocking(room, message)
+}
+
+func (s *InMemoryRoomStore) DeleteMessages(room *domain_structures.Room, messageIds []int64) {
+	for _, messageId := range messageIds {
+		delete(room.RoomMessages, messageId)
+		delete(room.MessageVotesByMessageId, messageId)
+		delete(room.WhisperMessages, messageId)
+
+		unindexRoomMessageNonLocking(room, messageId)
+	}
+
+	room.RoomMessagesLen = len(room.RoomMessages)
+}
+
+func (s *InMemoryRoomStore) ToggleMessageVote(
+	room *domain_structures.Room,
+	message *domain_structures.RoomMessage,
+	sessionUUID string,
+	isSupport bool,
+	votedAt int64,
+) {
+	messageVotes, votesInitialized := room.MessageVotesByMessageId[message.Id]
+
+	if !votesInitialized {
+		messageVotes = &domain_structures.RoomMessageVotes{
+			SupportVotesBySessionUUID: make(map[string]bool),
+			RejectVotesBySessionUUID:  make(map[string]bool),
+		}
+		room.MessageVotesByMessageId[message.Id] = messageVotes
+	}
+
+	userSupports, supportRecordFound := messageVotes.SupportVotesBySessionUUID[sessionUUID]
+	userRejects, rejectRecordFound := messageVotes.RejectVotesBySessionUUID[sessionUUID]
+
+	//if user chosen support - check if user already supports this message. If no - add one to support, else - cancel support
+	if isSupport {
+		if !supportRecordFound || !userSupports {
+			messageVotes.SupportVotesBySessionUUID[sessionUUID] = true
+			message.SupportedCount = message.SupportedCount + 1
+		} else {
+			//if user already supports this message - cancel support
+			messageVotes.SupportVotesBySessionUUID[sessionUUID] = false
+			message.SupportedCount = message.SupportedCount - 1
+		}
+
+		if rejectRecordFound && userRejects {
+			messageVotes.RejectVotesBySessionUUID[sessionUUID] = false
+			message.RejectedCount = message.RejectedCount - 1
+		}
+	} else {
+		//same logic for rejecting
+
+		if !rejectRecordFound || !userRejects {
+			messageVotes.RejectVotesBySessionUUID[sessionUUID] = true
+			message.RejectedCount = message.RejectedCount + 1
+		} else {
+			//if user already rejects this message - cancel reject
+			messageVotes.RejectVotesBySessionUUID[sessionUUID] = false
+			message.RejectedCount = message.RejectedCount - 1
+		}
+
+		if supportRecordFound && userSupports {
+			messageVotes.SupportVotesBySessionUUID[sessionUUID] = false
+			message.SupportedCount = message.SupportedCount - 1
+		}
+	}
+
+	message.LastVotedAt = &votedAt
+}
+
+func (s *InMemoryRoomStore) ToggleMessageReaction(
+	room *domain_structures.Room,
+	message *domain_structures.RoomMessage,
+	sessionUUID string,
+	reaction string,
+	reactedAt int64,
+) {
+	messageVotes, votesInitialized := room.MessageVotesByMessageId[message.Id]
+
+	if !votesInitialized {
+		messageVotes = &domain_structures.RoomMessageVotes{
+			SupportVotesBySessionUUID: make(map[string]bool),
+			RejectVotesBySessionUUID:  make(map[string]bool),
+		}
+		room.MessageVotesByMessageId[message.Id] = messageVotes
+	}
+
+	if messageVotes.ReactionsBySessionUUID == nil {
+		messageVotes.ReactionsBySessionUUID = make(map[string]map[string]bool)
+	}
+
+	if message.ReactionCounts == nil {
+		message.ReactionCounts = make(map[string]int)
+	}
+
+	reactedSessions, found := messageVotes.ReactionsBySessionUUID[reaction]
+
+	if !found {
+		reactedSessions = make(map[string]bool)
+		messageVotes.ReactionsBySessionUUID[reaction] = reactedSessions
+	}
+
+	//same reaction again cancels it. Counts are changed by one (same as votes) - imported messages have counts without reacted sessions
+	if reactedSessions[sessionUUID] {
+		delete(reactedSessions, sessionUUID)
+		message.ReactionCounts[reaction] = message.ReactionCounts[reaction] - 1
+	} else {
+		reactedSessions[sessionUUID] = true
+		message.ReactionCounts[reaction] = message.ReactionCounts[reaction] + 1
+	}
+
+	if len(reactedSessions) == 0 {
+		delete(messageVotes.ReactionsBySessionUUID, reaction)
+	}
+
+	if message.ReactionCounts[reaction] <= 0 {
+		delete(message.ReactionCounts, reaction)
+	}
+
+	message.LastVotedAt = &reactedAt
+}
+
+func (s *InMemoryRoomStore) AuthorizeUser(room *domain_structures.Room, sessionUUID string, user *domain_structures.RoomUser) {
+	room.AllRoomAuthorizedUsersBySessionUUID[sessionUUID] = user
+}
+
+func (s *InMemoryRoomStore) LoadRooms() ([]*domain_structures.Room, error) {
+	return []*domain_structures.Room{}, nil
+}
+
+func (s *InMemoryRoomStore) Close() error {
+	return nil
+}
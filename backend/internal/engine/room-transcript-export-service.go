@@ -0,0 +1,498 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+const TranscriptFormatJson = "json"
+const TranscriptFormatMarkdown = "md"
+const TranscriptFormatHtml = "html"
+
+// transcript is flushed to client after every this many messages
+const TranscriptFlushEveryMessages = 50
+
+const transcriptTimeLayout = "2006-01-02 15:04:05 UTC"
+
+/* Variables */
+
+// base of links to user drawings on file server, e.g. 'https://myinstantchat.org' (set from app config)
+var DrawingFileBaseURL = ""
+
+var transcriptFileNameBadCharsRegexp = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// copy of room state taken under room lock - transcript is written from it after lock is released
+type RoomTranscript struct {
+	RoomName      string                  `json:"roomName"`
+	Description   string                  `json:"description,omitempty"`
+	StartedAtSec  int64                   `json:"startedAt"`
+	ExportedAtSec int64                   `json:"exportedAt"`
+	Messages      []RoomTranscriptMessage `json:"messages"`
+}
+
+type RoomTranscriptMessage struct {
+	Id               int64          `json:"id"`
+	CreatedAtSec     int64          `json:"createdAt"`
+	EditedAtSec      int64          `json:"editedAt,omitempty"`
+	Author           string         `json:"author"`
+	Text             string         `json:"text,omitempty"`
+	DrawingURL       string         `json:"drawingUrl,omitempty"`
+	ReplyToMessageId int64          `json:"replyToMessageId,omitempty"`
+	ReplyToAuthor    string         `json:"replyToAuthor,omitempty"`
+	SupportedCount   int            `json:"supported"`
+	RejectedCount    int            `json:"rejected"`
+	Reactions        map[string]int `json:"reactions,omitempty"`
+	IsPinned         bool           `json:"pinned,omitempty"`
+}
+
+func IsValidTranscriptFormat(format string) bool {
+	return format == TranscriptFormatJson || format == TranscriptFormatMarkdown || format == TranscriptFormatHtml
+}
+
+// side method for room transcript export - used for direct http requests. Returns error response if transcript can not
+// be exported (response format is json for json transcripts, plain text otherwise). Whispers are never exported
+func PrepareRoomTranscriptDirectly(roomName string, roomPassword string, format string, clientIp string) (*RoomTranscript, []byte) {
+	responseFormat := getTranscriptErrorResponseFormat(format)
+
+	room := ActiveRoomsByNameMap.Get(roomName)
+
+	if room == nil {
+		return nil, util.BuildDirectRoomMessagesErrorResponse("error: room not found", responseFormat)
+	}
+
+	room.Lock()
+
+	if room.IsDeleted {
+		room.Unlock()
+
+		return nil, util.BuildDirectRoomMessagesErrorResponse("error: room not found", responseFormat)
+	}
+
+	if errorResponse := checkDirectRoomPasswordNonLocking(room, roomPassword, clientIp, responseFormat); errorResponse != nil {
+		room.Unlock()
+
+		return nil, errorResponse
+	}
+
+	util.LogInfo("exporting transcript of room '%s' / '%s' as '%s'", room.Id, room.Name, format)
+
+	transcript := makeRoomTranscriptNonLocking(room)
+
+	room.Unlock()
+
+	return transcript, nil
+}
+
+// writes transcript in given format, flushing it to client while it is being written
+func WriteRoomTranscript(w io.Writer, transcript *RoomTranscript, format string) error {
+	bufferedWriter := bufio.NewWriter(w)
+
+	flush := func() error {
+		if err := bufferedWriter.Flush(); err != nil {
+			return err
+		}
+
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+
+	var writeMessage func(message *RoomTranscriptMessage, isFirst bool) error
+	var writeFooter func() error
+
+	switch format {
+	case TranscriptFormatJson:
+		if err := writeJsonTranscriptHeader(bufferedWriter, transcript); err != nil {
+			return err
+		}
+
+		writeMessage = func(message *RoomTranscriptMessage, isFirst bool) error {
+			return writeJsonTranscriptMessage(bufferedWriter, message, isFirst)
+		}
+		writeFooter = func() error {
+			_, err := bufferedWriter.WriteString("\n]}\n")
+
+			return err
+		}
+
+	case TranscriptFormatMarkdown:
+		writeMarkdownTranscriptHeader(bufferedWriter, transcript)
+
+		writeMessage = func(message *RoomTranscriptMessage, isFirst bool) error {
+			writeMarkdownTranscriptMessage(bufferedWriter, message)
+
+			return nil
+		}
+		writeFooter = func() error {
+			return nil
+		}
+
+	case TranscriptFormatHtml:
+		writeHtmlTranscriptHeader(bufferedWriter, transcript)
+
+		writeMessage = func(message *RoomTranscriptMessage, isFirst bool) error {
+			writeHtmlTranscriptMessage(bufferedWriter, message)
+
+			return nil
+		}
+		writeFooter = func() error {
+			_, err := bufferedWriter.WriteString("</main>\n</body>\n</html>\n")
+
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown transcript format '%s'", format)
+	}
+
+	for i := range transcript.Messages {
+		if err := writeMessage(&transcript.Messages[i], i == 0); err != nil {
+			return err
+		}
+
+		if (i+1)%TranscriptFlushEveryMessages == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writeFooter(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+func GetTranscriptContentType(format string) string {
+	switch format {
+	case TranscriptFormatJson:
+		return "application/json"
+	case TranscriptFormatHtml:
+		return "text/html; charset=utf-8"
+	}
+
+	return "text/markdown; charset=utf-8"
+}
+
+func GetTranscriptFileName(transcript *RoomTranscript, format string) string {
+	safeRoomName := strings.Trim(transcriptFileNameBadCharsRegexp.ReplaceAllString(transcript.RoomName, "_"), "_")
+
+	if safeRoomName == "" {
+		safeRoomName = "room"
+	}
+
+	return fmt.Sprintf("%s-%s.%s", safeRoomName, time.Unix(transcript.ExportedAtSec, 0).UTC().Format("20060102-150405"), format)
+}
+
+// must be executed under room lock
+func makeRoomTranscriptNonLocking(room *domain_structures.Room) *RoomTranscript {
+	userNameByUserInRoomUUID := make(map[string]string)
+
+	for _, user := range room.AllRoomAuthorizedUsersBySessionUUID {
+		userNameByUserInRoomUUID[user.UserInRoomUUID] = user.UserName
+	}
+
+	pinnedMessageIds := make(map[int64]bool, len(room.PinnedMessageIds))
+
+	for _, pinnedMessageId := range room.PinnedMessageIds {
+		pinnedMessageIds[pinnedMessageId] = true
+	}
+
+	messageIds := make([]int64, 0, len(room.RoomMessages))
+
+	for messageId := range room.RoomMessages {
+		messageIds = append(messageIds, messageId)
+	}
+
+	sort.Slice(messageIds, func(i, j int) bool {
+		return messageIds[i] < messageIds[j]
+	})
+
+	description, _ := url.QueryUnescape(room.Description)
+
+	transcript := &RoomTranscript{
+		RoomName:      room.Name,
+		Description:   description,
+		StartedAtSec:  time.Unix(0, room.StartedAt).Unix(),
+		ExportedAtSec: time.Now().Unix(),
+		Messages:      make([]RoomTranscriptMessage, len(messageIds)),
+	}
+
+	for i, messageId := range messageIds {
+		message := room.RoomMessages[messageId]
+
+		transcriptMessage := RoomTranscriptMessage{
+			Id:             message.Id,
+			CreatedAtSec:   message.CreatedAtSec,
+			Author:         unescapeDirectMessageUserName(userNameByUserInRoomUUID, message.UserInRoomUUID),
+			SupportedCount: message.SupportedCount,
+			RejectedCount:  message.RejectedCount,
+			IsPinned:       pinnedMessageIds[message.Id],
+		}
+
+		if message.LastEditedAt != nil {
+			transcriptMessage.EditedAtSec = time.Unix(0, *message.LastEditedAt).Unix()
+		}
+
+		text := unescapeDirectMessageText(message.Text)
+
+		if strings.HasPrefix(text, DrawingMessageMetaMarker) {
+			transcriptMessage.DrawingURL = makeDrawingFileURL(strings.TrimPrefix(text, DrawingMessageMetaMarker))
+		} else {
+			transcriptMessage.Text = text
+		}
+
+		if message.ReplyToMessageId != nil {
+			transcriptMessage.ReplyToMessageId = *message.ReplyToMessageId
+		}
+
+		if message.ReplyToUserId != nil {
+			transcriptMessage.ReplyToAuthor = unescapeDirectMessageUserName(userNameByUserInRoomUUID, *message.ReplyToUserId)
+		}
+
+		if len(message.ReactionCounts) > 0 {
+			transcriptMessage.Reactions = make(map[string]int, len(message.ReactionCounts))
+
+			for reaction, count := range message.ReactionCounts {
+				transcriptMessage.Reactions[reaction] = count
+			}
+		}
+
+		transcript.Messages[i] = transcriptMessage
+	}
+
+	return transcript
+}
+
+// drawing meta is 'fileName@fileGroupPrefix'
+func makeDrawingFileURL(drawingMeta string) string {
+	fileName, fileGroupPrefix, _ := strings.Cut(drawingMeta, "@")
+
+	return fmt.Sprintf("%s/get_text_file?file_name=%s&file_group_prefix=%s",
+		DrawingFileBaseURL, url.QueryEscape(fileName), url.QueryEscape(fileGroupPrefix))
+}
+
+func getTranscriptErrorResponseFormat(format string) string {
+	if format == TranscriptFormatJson {
+		return "json"
+	}
+
+	return ""
+}
+
+func formatTranscriptTime(timeSec int64) string {
+	return time.Unix(timeSec, 0).UTC().Format(transcriptTimeLayout)
+}
+
+// reactions in stable order, support/reject votes first
+func formatTranscriptReactions(message *RoomTranscriptMessage) string {
+	var reactions []string
+
+	if message.SupportedCount > 0 {
+		reactions = append(reactions, fmt.Sprintf("%s %d", SupportReaction, message.SupportedCount))
+	}
+
+	if message.RejectedCount > 0 {
+		reactions = append(reactions, fmt.Sprintf("%s %d", RejectReaction, message.RejectedCount))
+	}
+
+	otherReactions := make([]string, 0, len(message.Reactions))
+
+	for reaction := range message.Reactions {
+		otherReactions = append(otherReactions, reaction)
+	}
+
+	sort.Strings(otherReactions)
+
+	for _, reaction := range otherReactions {
+		reactions = append(reactions, fmt.Sprintf("%s %d", reaction, message.Reactions[reaction]))
+	}
+
+	return strings.Join(reactions, " · ")
+}
+
+/* json */
+
+// messages are written one by one, so whole transcript is never encoded at once
+func writeJsonTranscriptHeader(w *bufio.Writer, transcript *RoomTranscript) error {
+	header := *transcript
+	header.Messages = nil
+
+	headerJson, err := json.Marshal(header)
+
+	if err != nil {
+		return err
+	}
+
+	//replace closing brace (and empty messages list) with opening of messages array
+	headerJson = []byte(strings.TrimSuffix(string(headerJson), `,"messages":null}`))
+
+	_, err = w.Write(headerJson)
+
+	if err == nil {
+		_, err = w.WriteString(`,"messages":[`)
+	}
+
+	return err
+}
+
+func writeJsonTranscriptMessage(w *bufio.Writer, message *RoomTranscriptMessage, isFirst bool) error {
+	messageJson, err := json.Marshal(message)
+
+	if err != nil {
+		return err
+	}
+
+	if !isFirst {
+		w.WriteString(",")
+	}
+
+	w.WriteString("\n")
+	_, err = w.Write(messageJson)
+
+	return err
+}
+
+/* markdown */
+
+func writeMarkdownTranscriptHeader(w *bufio.Writer, transcript *RoomTranscript) {
+	fmt.Fprintf(w, "# Room transcript: %s\n\n", transcript.RoomName)
+
+	if transcript.Description != "" {
+		fmt.Fprintf(w, "%s\n\n", quoteMarkdownText(transcript.Description))
+	}
+
+	fmt.Fprintf(w, "- Room started: %s\n", formatTranscriptTime(transcript.StartedAtSec))
+	fmt.Fprintf(w, "- Exported: %s\n", formatTranscriptTime(transcript.ExportedAtSec))
+	fmt.Fprintf(w, "- Messages: %d\n\n---\n\n", len(transcript.Messages))
+}
+
+func writeMarkdownTranscriptMessage(w *bufio.Writer, message *RoomTranscriptMessage) {
+	fmt.Fprintf(w, "**#%d %s** · %s", message.Id, escapeMarkdownInline(message.Author), formatTranscriptTime(message.CreatedAtSec))
+
+	if message.EditedAtSec > 0 {
+		fmt.Fprintf(w, " · edited %s", formatTranscriptTime(message.EditedAtSec))
+	}
+
+	if message.IsPinned {
+		w.WriteString(" · pinned")
+	}
+
+	w.WriteString("\n\n")
+
+	if message.ReplyToMessageId > 0 {
+		fmt.Fprintf(w, "↪ reply to #%d", message.ReplyToMessageId)
+
+		if message.ReplyToAuthor != "" {
+			fmt.Fprintf(w, " (%s)", escapeMarkdownInline(message.ReplyToAuthor))
+		}
+
+		w.WriteString("\n\n")
+	}
+
+	if message.DrawingURL != "" {
+		fmt.Fprintf(w, "[drawing](%s)\n\n", message.DrawingURL)
+	} else {
+		fmt.Fprintf(w, "%s\n\n", quoteMarkdownText(message.Text))
+	}
+
+	if reactions := formatTranscriptReactions(message); reactions != "" {
+		fmt.Fprintf(w, "%s\n\n", reactions)
+	}
+}
+
+// message text is put into blockquote as is, so markdown typed by users is kept
+func quoteMarkdownText(text string) string {
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
+
+func escapeMarkdownInline(text string) string {
+	return strings.NewReplacer("*", "\\*", "_", "\\_", "`", "\\`", "[", "\\[", "]", "\\]").Replace(text)
+}
+
+/* html */
+
+func writeHtmlTranscriptHeader(w *bufio.Writer, transcript *RoomTranscript) {
+	roomName := html.EscapeString(transcript.RoomName)
+
+	w.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(w, "<title>Room transcript: %s</title>\n", roomName)
+	w.WriteString("<style>\n" +
+		"body { font-family: sans-serif; max-width: 900px; margin: 0 auto; padding: 20px; color: #222; }\n" +
+		".meta { color: #777; font-size: 0.85em; }\n" +
+		".description { white-space: pre-wrap; border-left: 3px solid #ccc; padding-left: 10px; }\n" +
+		".msg { border-bottom: 1px solid #eee; padding: 10px 0; }\n" +
+		".msg-text { white-space: pre-wrap; word-wrap: break-word; margin: 6px 0; }\n" +
+		".reply { color: #777; font-size: 0.85em; }\n" +
+		".pinned { background: #fffbe6; }\n" +
+		"</style>\n</head>\n<body>\n")
+
+	fmt.Fprintf(w, "<h1>Room transcript: %s</h1>\n", roomName)
+
+	if transcript.Description != "" {
+		fmt.Fprintf(w, "<p class=\"description\">%s</p>\n", html.EscapeString(transcript.Description))
+	}
+
+	fmt.Fprintf(w, "<p class=\"meta\">Room started: %s<br>Exported: %s<br>Messages: %d</p>\n<main>\n",
+		formatTranscriptTime(transcript.StartedAtSec), formatTranscriptTime(transcript.ExportedAtSec), len(transcript.Messages))
+}
+
+func writeHtmlTranscriptMessage(w *bufio.Writer, message *RoomTranscriptMessage) {
+	messageClass := "msg"
+
+	if message.IsPinned {
+		messageClass += " pinned"
+	}
+
+	fmt.Fprintf(w, "<div class=\"%s\" id=\"m%d\">\n<div class=\"meta\">#%d <b>%s</b> · %s",
+		messageClass, message.Id, message.Id, html.EscapeString(message.Author), formatTranscriptTime(message.CreatedAtSec))
+
+	if message.EditedAtSec > 0 {
+		fmt.Fprintf(w, " · edited %s", formatTranscriptTime(message.EditedAtSec))
+	}
+
+	if message.IsPinned {
+		w.WriteString(" · pinned")
+	}
+
+	w.WriteString("</div>\n")
+
+	if message.ReplyToMessageId > 0 {
+		fmt.Fprintf(w, "<div class=\"reply\">↪ reply to <a href=\"#m%d\">#%d</a>", message.ReplyToMessageId, message.ReplyToMessageId)
+
+		if message.ReplyToAuthor != "" {
+			fmt.Fprintf(w, " (%s)", html.EscapeString(message.ReplyToAuthor))
+		}
+
+		w.WriteString("</div>\n")
+	}
+
+	if message.DrawingURL != "" {
+		fmt.Fprintf(w, "<div class=\"msg-text\"><a href=\"%s\">drawing</a></div>\n", html.EscapeString(message.DrawingURL))
+	} else {
+		fmt.Fprintf(w, "<div class=\"msg-text\">%s</div>\n", html.EscapeString(message.Text))
+	}
+
+	if reactions := formatTranscriptReactions(message); reactions != "" {
+		fmt.Fprintf(w, "<div class=\"meta\">%s</div>\n", html.EscapeString(reactions))
+	}
+
+	w.WriteString("</div>\n")
+}
@@ -0,0 +1,358 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// author of messages with empty author in transcript
+const TranscriptUnknownAuthorName = "unknown"
+
+var TranscriptImportBadMessage = errors.New("transcript message is invalid")
+var TranscriptImportBadDescription = errors.New("transcript room description is too long")
+
+// result of room import. Imported room has no real owner - owner recovery key lets person who imported it reclaim ownership
+type TranscriptImportResult struct {
+	RoomName         string
+	MessagesCount    int
+	AuthorsCount     int
+	OwnerRecoveryKey string
+}
+
+// creates new room pre-populated from JSON transcript (see WriteRoomTranscript). Authors are mapped to placeholder room users
+// (nobody can log in as them, but their names stay taken). Original message ids are kept if they are valid, otherwise messages
+// are renumbered in transcript order. Only latest messages that fit room messages limit are imported, their texts go through
+// message filters.
+// Import is done by admin only - imported room is new, so nobody owns it yet. Room owner (e.g. one whose room was lost with
+// backend) gets ownership of imported room with owner recovery key returned by import
+func ImportRoomFromTranscript(roomName string, roomPassword string, transcriptJson []byte) (*TranscriptImportResult, error) {
+	var transcript RoomTranscript
+
+	err := json.Unmarshal(transcriptJson, &transcript)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if roomName == "" {
+		roomName = strings.ToLower(transcript.RoomName)
+	}
+
+	description := escapeClientText(strings.TrimSpace(transcript.Description))
+
+	if validateRoomDescription(description) != nil {
+		return nil, TranscriptImportBadDescription
+	}
+
+	//random-based uuid - placeholder owner session nobody has
+	ownerSessionUUID, err := uuid.NewRandom()
+
+	if err != nil {
+		return nil, err
+	}
+
+	//hashing is slow - it is done before rooms map is locked
+	ownerRecoveryKey, ownerRecoveryKeyHash, err := generateOwnerRecoveryKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ActiveRoomsByNameMap.Lock()
+
+	//checked under rooms map lock - so room is not created after backend started draining and moving rooms away
+	if IsDraining() {
+		ActiveRoomsByNameMap.Unlock()
+
+		return nil, MigrationBackendDraining
+	}
+
+	if ActiveRoomsByNameMap.ContainsNonLocking(strings.TrimSpace(roomName)) {
+		ActiveRoomsByNameMap.Unlock()
+
+		return nil, MigrationRoomAlreadyExists
+	}
+
+	room, err := createRoom(roomName, roomPassword, ownerSessionUUID.String(), nil)
+
+	if err != nil {
+		ActiveRoomsByNameMap.Unlock()
+
+		return nil, err
+	}
+
+	room.Lock()
+
+	room.Description = description
+
+	if transcript.StartedAtSec > 0 && transcript.StartedAtSec < time.Now().Unix() {
+		room.StartedAt = time.Unix(transcript.StartedAtSec, 0).UnixNano()
+	}
+
+	authorsCount, err := populateRoomFromTranscriptNonLocking(room, transcript.Messages)
+
+	if err != nil {
+		//room is not registered yet, so only room store has to forget it
+		room.IsDeleted = true
+		ActiveRoomStore.DeleteRoom(room)
+
+		room.Unlock()
+		ActiveRoomsByNameMap.Unlock()
+
+		return nil, err
+	}
+
+	room.OwnerRecoveryKeyHash = ownerRecoveryKeyHash
+
+	ActiveRoomStore.SaveRoom(room)
+
+	messagesCount := room.RoomMessagesLen
+
+	room.Unlock()
+
+	ActiveRoomsByNameMap.ActiveRoomsByName[room.Name] = room
+	RoomsOnlineGauge.Inc()
+
+	ActiveRoomsByNameMap.Unlock()
+
+	StartSocketHouseKeeper(room)
+
+	util.LogInfo("imported room '%s' / '%s' from transcript with '%d' messages of '%d' authors",
+		room.Id, room.Name, messagesCount, authorsCount)
+
+	return &TranscriptImportResult{
+		RoomName:         room.Name,
+		MessagesCount:    messagesCount,
+		AuthorsCount:     authorsCount,
+		OwnerRecoveryKey: ownerRecoveryKey,
+	}, nil
+}
+
+// fills empty room with transcript messages and their authors, returns number of placeholder users added.
+// Must be executed under room lock
+func populateRoomFromTranscriptNonLocking(room *domain_structures.Room, transcriptMessages []RoomTranscriptMessage) (int, error) {
+	transcriptMessages = append([]RoomTranscriptMessage(nil), transcriptMessages...)
+
+	sort.SliceStable(transcriptMessages, func(i, j int) bool {
+		return transcriptMessages[i].Id < transcriptMessages[j].Id
+	})
+
+	if len(transcriptMessages) > room.Settings.MessagesLimit {
+		transcriptMessages = transcriptMessages[len(transcriptMessages)-room.Settings.MessagesLimit:]
+	}
+
+	keepMessageIds := true
+	seenMessageIds := make(map[int64]bool, len(transcriptMessages))
+
+	for _, transcriptMessage := range transcriptMessages {
+		if transcriptMessage.Id <= 0 || seenMessageIds[transcriptMessage.Id] {
+			keepMessageIds = false
+
+			break
+		}
+
+		seenMessageIds[transcriptMessage.Id] = true
+	}
+
+	newMessageIds := make([]int64, len(transcriptMessages))
+	newMessageIdByTranscriptId := make(map[int64]int64, len(transcriptMessages))
+
+	for i, transcriptMessage := range transcriptMessages {
+		if keepMessageIds {
+			newMessageIds[i] = transcriptMessage.Id
+		} else {
+			newMessageIds[i] = int64(i + 1)
+		}
+
+		newMessageIdByTranscriptId[transcriptMessage.Id] = newMessageIds[i]
+	}
+
+	placeholderUsers := make(map[string]*domain_structures.RoomUser)
+	nowSec := time.Now().Unix()
+
+	var pinnedMessageIds []int64
+
+	for i := range transcriptMessages {
+		transcriptMessage := &transcriptMessages[i]
+
+		messageText, isFlagged, err := makeImportedMessageTextNonLocking(room, transcriptMessage)
+
+		if err != nil {
+			return 0, fmt.Errorf("%w: message '%d' - %s", TranscriptImportBadMessage, transcriptMessage.Id, err)
+		}
+
+		authorUserInRoomUUID, err := getOrAddPlaceholderUserNonLocking(room, placeholderUsers, transcriptMessage.Author)
+
+		if err != nil {
+			return 0, err
+		}
+
+		messageId := newMessageIds[i]
+
+		message := &domain_structures.RoomMessage{
+			Id:             messageId,
+			Text:           messageText,
+			SupportedCount: transcriptMessage.SupportedCount,
+			RejectedCount:  transcriptMessage.RejectedCount,
+			UserInRoomUUID: authorUserInRoomUUID,
+			CreatedAtSec:   transcriptMessage.CreatedAtSec,
+			IsFlagged:      isFlagged,
+		}
+
+		if message.SupportedCount < 0 || message.RejectedCount < 0 {
+			return 0, fmt.Errorf("%w: message '%d' - negative votes count", TranscriptImportBadMessage, transcriptMessage.Id)
+		}
+
+		if message.CreatedAtSec <= 0 || message.CreatedAtSec > nowSec {
+			message.CreatedAtSec = nowSec
+		}
+
+		if transcriptMessage.EditedAtSec > 0 {
+			lastEditedAt := time.Unix(transcriptMessage.EditedAtSec, 0).UnixNano()
+			message.LastEditedAt = &lastEditedAt
+		}
+
+		//replies to messages that are not imported are kept as plain messages
+		if replyToMessageId, found := newMessageIdByTranscriptId[transcriptMessage.ReplyToMessageId]; found && transcriptMessage.ReplyToMessageId > 0 {
+			message.ReplyToMessageId = &replyToMessageId
+
+			if transcriptMessage.ReplyToAuthor != "" {
+				replyToUserInRoomUUID, err := getOrAddPlaceholderUserNonLocking(room, placeholderUsers, transcriptMessage.ReplyToAuthor)
+
+				if err != nil {
+					return 0, err
+				}
+
+				message.ReplyToUserId = &replyToUserInRoomUUID
+			}
+		}
+
+		for reaction, count := range transcriptMessage.Reactions {
+			if count <= 0 || reaction == SupportReaction || reaction == RejectReaction || !isAllowedMessageReaction(reaction) {
+				continue
+			}
+
+			if message.ReactionCounts == nil {
+				message.ReactionCounts = make(map[string]int)
+			}
+
+			message.ReactionCounts[reaction] = count
+		}
+
+		if transcriptMessage.IsPinned && len(pinnedMessageIds) < RoomPinnedMessagesLimit {
+			pinnedMessageIds = append(pinnedMessageIds, messageId)
+		}
+
+		room.RoomMessages[messageId] = message
+
+		if messageId >= room.NextMessageId {
+			room.NextMessageId = messageId + 1
+		}
+	}
+
+	room.RoomMessagesLen = len(room.RoomMessages)
+	room.PinnedMessageIds = pinnedMessageIds
+
+	return len(placeholderUsers), nil
+}
+
+// drawings are exported as links to file server - they are turned back into drawing messages. Returned text is url-escaped,
+// flag is set by message filters. Must be executed under room lock
+func makeImportedMessageTextNonLocking(room *domain_structures.Room, transcriptMessage *RoomTranscriptMessage) (string, bool, error) {
+	text := transcriptMessage.Text
+
+	if transcriptMessage.DrawingURL != "" {
+		drawingURL, err := url.Parse(transcriptMessage.DrawingURL)
+
+		if err != nil {
+			return "", false, err
+		}
+
+		fileName := drawingURL.Query().Get("file_name")
+		fileGroupPrefix := drawingURL.Query().Get("file_group_prefix")
+
+		if fileName == "" || fileGroupPrefix == "" {
+			return "", false, errors.New("bad drawing url")
+		}
+
+		text = DrawingMessageMetaMarker + fileName + "@" + fileGroupPrefix
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return "", false, errors.New("message is empty")
+	}
+
+	if len([]rune(text)) > room.Settings.MaxMessageLength {
+		return "", false, errors.New("message is too long")
+	}
+
+	filterResult := filterMessageText(room, escapeClientText(text))
+
+	if filterResult.RejectError != nil {
+		return "", false, errors.New(filterResult.RejectReason)
+	}
+
+	return filterResult.Text, filterResult.IsFlagged, nil
+}
+
+// authors are matched by name (case-insensitive). Must be executed under room lock
+func getOrAddPlaceholderUserNonLocking(
+	room *domain_structures.Room,
+	placeholderUsers map[string]*domain_structures.RoomUser,
+	authorName string,
+) (string, error) {
+	authorName = strings.TrimSpace(authorName)
+
+	if authorName == "" {
+		authorName = TranscriptUnknownAuthorName
+	}
+
+	if authorName == ExternalUserName {
+		return ExternalUserUUID, nil
+	}
+
+	if authorNameRunes := []rune(authorName); len(authorNameRunes) > RoomUserNameMaxLength {
+		authorName = string(authorNameRunes[:RoomUserNameMaxLength])
+	}
+
+	authorKey := strings.ToLower(authorName)
+
+	if placeholderUser, found := placeholderUsers[authorKey]; found {
+		return placeholderUser.UserInRoomUUID, nil
+	}
+
+	userInRoomUUID, err := uuid.NewUUID()
+
+	if err != nil {
+		return "", err
+	}
+
+	//random-based uuid - placeholder session nobody has
+	sessionUUID, err := uuid.NewRandom()
+
+	if err != nil {
+		return "", err
+	}
+
+	placeholderUser := &domain_structures.RoomUser{
+		UserInRoomUUID: userInRoomUUID.String(),
+		UserName:       escapeClientText(authorName),
+		IsAnonName:     false,
+	}
+
+	placeholderUsers[authorKey] = placeholderUser
+	room.AllRoomAuthorizedUsersBySessionUUID[sessionUUID.String()] = placeholderUser
+
+	return placeholderUser.UserInRoomUUID, nil
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+/* Constants */
+
+// repeated 'typing' signals of same user are not re-broadcast more often than this
+const TypingBroadcastThrottleInterval = 3 * time.Second
+
+// typing state is dropped (and 'stopped typing' is broadcast) if user sends no new signal within this period.
+// Clients are expected to repeat signal while user keeps typing
+const TypingStateExpiryTimeout = 6 * time.Second
+
+// processes typing indicator signal. Typing state is ephemeral - it is not saved to messages history nor to room event log
+func processUserTypingCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	userInRoomUUID := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
+	existingTypingState, wasTyping := room.TypingUsersByUserInRoomUUID[userInRoomUUID]
+
+	if wasTyping {
+		existingTypingState.ExpiryTimer.Stop()
+	}
+
+	var typingDispatchingFrame *domain_structures.OutMessageFrame = nil
+
+	if inFrame.IsTyping {
+		now := time.Now().UnixNano()
+
+		//new state object per signal - so expiry routine, that already fired and waits for lock, can tell it is outdated
+		typingState := &domain_structures.RoomUserTypingState{
+			LastBroadcastAt: now,
+		}
+
+		if wasTyping && now-existingTypingState.LastBroadcastAt < int64(TypingBroadcastThrottleInterval) {
+			typingState.LastBroadcastAt = existingTypingState.LastBroadcastAt
+		} else {
+			typingDispatchingFrame = makeUserTypingFrame(userInRoomUUID, true)
+		}
+
+		typingState.ExpiryTimer = time.AfterFunc(TypingStateExpiryTimeout, func() {
+			expireUserTypingState(room, userInRoomUUID, typingState)
+		})
+
+		room.TypingUsersByUserInRoomUUID[userInRoomUUID] = typingState
+	} else if wasTyping {
+		delete(room.TypingUsersByUserInRoomUUID, userInRoomUUID)
+
+		typingDispatchingFrame = makeUserTypingFrame(userInRoomUUID, false)
+	}
+
+	if typingDispatchingFrame == nil {
+		room.Unlock()
+
+		writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+
+		return
+	}
+
+	roomActiveClientSocketsByUUID := copyActiveClientSocketMapExceptSessionNonLocking(room, clSocket.SessionUUID)
+
+	room.Unlock()
+
+	writeFrameToActiveRoomMembers(typingDispatchingFrame, room, roomActiveClientSocketsByUUID)
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// drops typing state of user that stopped sending typing signals (e.g. closed browser tab while typing)
+func expireUserTypingState(room *domain_structures.Room, userInRoomUUID string, typingState *domain_structures.RoomUserTypingState) {
+	room.Lock()
+
+	//room is gone or user sent new signal meanwhile
+	if room.IsDeleted || room.TypingUsersByUserInRoomUUID[userInRoomUUID] != typingState {
+		room.Unlock()
+
+		return
+	}
+
+	delete(room.TypingUsersByUserInRoomUUID, userInRoomUUID)
+
+	util.LogTrace("typing state of user '%s' expired in room '%s'", userInRoomUUID, room.Name)
+
+	sessionUUID := findSessionUUIDByUserInRoomUUID(room, userInRoomUUID)
+	roomActiveClientSocketsByUUID := copyActiveClientSocketMapExceptSessionNonLocking(room, sessionUUID)
+
+	room.Unlock()
+
+	writeFrameToActiveRoomMembers(makeUserTypingFrame(userInRoomUUID, false), room, roomActiveClientSocketsByUUID)
+}
+
+func makeUserTypingFrame(userInRoomUUID string, isTyping bool) *domain_structures.OutMessageFrame {
+	createdAt := time.Now().UnixNano()
+
+	return &domain_structures.OutMessageFrame{
+		Command:        domain_structures.UserTyping,
+		UserInRoomUUID: &userInRoomUUID,
+		CreatedAtNano:  &createdAt,
+		IsTyping:       &isTyping,
+	}
+}
+
+// user does not need own typing signals (in any of his browser tabs). Must be executed under room lock
+func copyActiveClientSocketMapExceptSessionNonLocking(room *domain_structures.Room, sessionUUID string) *map[string]*domain_structures.WebSocket {
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	for socketUUID, userSocket := range *roomActiveClientSocketsByUUID {
+		if userSocket.SessionUUID == sessionUUID {
+			delete(*roomActiveClientSocketsByUUID, socketUUID)
+		}
+	}
+
+	return roomActiveClientSocketsByUUID
+}
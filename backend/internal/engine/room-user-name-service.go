@@ -4,6 +4,7 @@ import (
 	"errors"
 	"math/rand"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
@@ -15,7 +16,7 @@ const RoomUserNameMinLength = 1
 const RoomUserNameMaxLength = 80
 
 var ProvidedNameTaken = errors.New("provided name already taken")
-var BadNameLength = errors.New("provided name must be between " + string(RoomUserNameMinLength) + " and " + string(RoomUserNameMaxLength) + " characters")
+var BadNameLength = errors.New("provided name must be between " + strconv.Itoa(RoomUserNameMinLength) + " and " + strconv.Itoa(RoomUserNameMaxLength) + " characters")
 
 var AnonNames = []string{
 	"Aardvark",
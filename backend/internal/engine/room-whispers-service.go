@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"sort"
+	"time"
+
+	"instantchat.rooms/instantchat/backend/internal/domain_structures"
+	"instantchat.rooms/instantchat/backend/internal/util"
+)
+
+// sends private message to single room user. Whisper is delivered only to sockets of author and recipient
+func processWhisperCommand(clSocket *domain_structures.WebSocket, inFrame *domain_structures.InMessageFrame) {
+	room := lockRoomForActiveUserCommand(clSocket, inFrame, false)
+
+	if room == nil {
+		return
+	}
+
+	userInRoomUUID := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
+	recipientUserInRoomUUID := inFrame.TargetUserInRoomUUID
+	recipientSessionUUID := findSessionUUIDByUserInRoomUUID(room, recipientUserInRoomUUID)
+
+	//recipient does not have to be online - he gets whisper on next join
+	if recipientSessionUUID == "" || recipientSessionUUID == ExternalUserSessionUUID || room.BannedUserInRoomUUIDs[recipientUserInRoomUUID] {
+		room.Unlock()
+
+		util.LogTrace("failed to whisper - user '%s' not found in room '%s'", recipientUserInRoomUUID, room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomUserNotFound, inFrame.RequestId)
+
+		return
+	}
+
+	if recipientUserInRoomUUID == userInRoomUUID {
+		room.Unlock()
+
+		util.LogTrace("failed to whisper - user '%s' can not whisper to himself in room '%s'", clSocket.SessionUUID, room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+
+		return
+	}
+
+	if !takeMessageRateLimitTokenNonLocking(room, clSocket, inFrame.RequestId) {
+		room.Unlock()
+
+		return
+	}
+
+	message := inFrame.Message
+
+	//NOTE: we are expecting message text to come url-escaped
+	filterResult, accepted := filterMessageTextForSocket(room, clSocket, message.Text, inFrame.RequestId)
+
+	if !accepted {
+		room.Unlock()
+
+		util.LogTrace("failed to whisper - message of user '%s' was rejected in room '%s'", clSocket.SessionUUID, room.Name)
+
+		return
+	}
+
+	util.LogTrace("user '%s' is sending whisper of len '%d' to room '%s' / '%s'", clSocket.SessionUUID, len(filterResult.Text), room.Id, room.Name)
+
+	newWhisperMessage := ActiveRoomStore.AddWhisperMessage(
+		room,
+		userInRoomUUID,
+		recipientUserInRoomUUID,
+		filterResult.Text,
+		message.ReplyToUserId,
+		message.ReplyToMessageId,
+		message.TtlSec,
+		filterResult.IsFlagged,
+	)
+
+	scheduleMessageExpiryNonLocking(room, newWhisperMessage)
+
+	droppedWhisperDeleteFrames, droppedWhisperAudiencesSocketsByUUID := dropOldestWhisperMessagesNonLocking(room)
+
+	whisperDispatchingFrame := &domain_structures.OutMessageFrame{
+		Command: domain_structures.TextMessageWhisper,
+		Message: &[]domain_structures.RoomMessageDTO{copyMessageAsDTO(newWhisperMessage)},
+	}
+
+	recordMessageEvent(room, newWhisperMessage, whisperDispatchingFrame)
+
+	whisperParticipantsSocketsByUUID := copyMessageAudienceSocketMapNonLocking(room, newWhisperMessage)
+
+	room.Unlock()
+
+	for i, droppedWhisperDeleteFrame := range droppedWhisperDeleteFrames {
+		writeFrameToActiveRoomMembers(droppedWhisperDeleteFrame, room, droppedWhisperAudiencesSocketsByUUID[i])
+	}
+
+	writeFrameToActiveRoomMembers(whisperDispatchingFrame, room, whisperParticipantsSocketsByUUID)
+
+	writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
+}
+
+// oldest whispers are dropped when room has more of them than its messages limit. Returns delete frames and audiences
+// of dropped whispers - to be sent after unlock. Must be executed under room lock
+func dropOldestWhisperMessagesNonLocking(room *domain_structures.Room) ([]*domain_structures.OutMessageFrame, []*map[string]*domain_structures.WebSocket) {
+	if len(room.WhisperMessages) <= room.Settings.MessagesLimit {
+		return nil, nil
+	}
+
+	whisperMessageIds := make([]int64, 0, len(room.WhisperMessages))
+
+	for whisperMessageId := range room.WhisperMessages {
+		whisperMessageIds = append(whisperMessageIds, whisperMessageId)
+	}
+
+	sort.Slice(whisperMessageIds, func(i, j int) bool {
+		return whisperMessageIds[i] < whisperMessageIds[j]
+	})
+
+	droppedWhisperMessageIds := whisperMessageIds[:len(whisperMessageIds)-room.Settings.MessagesLimit]
+
+	messageDeleteDispatchingFrames := make([]*domain_structures.OutMessageFrame, 0, len(droppedWhisperMessageIds))
+	messageAudiencesSocketsByUUID := make([]*map[string]*domain_structures.WebSocket, 0, len(droppedWhisperMessageIds))
+
+	for _, droppedWhisperMessageId := range droppedWhisperMessageIds {
+		droppedWhisperMessage := room.WhisperMessages[droppedWhisperMessageId]
+		messageId := droppedWhisperMessageId
+
+		messageDeleteDispatchingFrame := &domain_structures.OutMessageFrame{
+			Command: domain_structures.TextMessageDelete,
+			Message: &[]domain_structures.RoomMessageDTO{{Id: &messageId}},
+		}
+
+		recordMessageEvent(room, droppedWhisperMessage, messageDeleteDispatchingFrame)
+
+		messageDeleteDispatchingFrames = append(messageDeleteDispatchingFrames, messageDeleteDispatchingFrame)
+		messageAudiencesSocketsByUUID = append(messageAudiencesSocketsByUUID, copyMessageAudienceSocketMapNonLocking(room, droppedWhisperMessage))
+	}
+
+	ActiveRoomStore.DeleteMessages(room, droppedWhisperMessageIds)
+
+	return messageDeleteDispatchingFrames, messageAudiencesSocketsByUUID
+}
+
+// looks for message among public messages and whispers. Must be executed under room lock
+func findRoomOrWhisperMessage(room *domain_structures.Room, messageId int64) (*domain_structures.RoomMessage, bool) {
+	if message, found := room.RoomMessages[messageId]; found {
+		return message, true
+	}
+
+	message, found := room.WhisperMessages[messageId]
+
+	return message, found
+}
+
+// records frame concerning message as room event, visible only to whisper participants for whispers. Must be executed under room lock
+func recordMessageEvent(room *domain_structures.Room, message *domain_structures.RoomMessage, frame *domain_structures.OutMessageFrame) {
+	if message.RecipientUserInRoomUUID == "" {
+		recordRoomEvent(room, frame)
+	} else {
+		recordPrivateRoomEvent(room, frame, []string{message.UserInRoomUUID, message.RecipientUserInRoomUUID})
+	}
+}
+
+// sockets of users message is visible to: all active sockets for public messages, author's and recipient's ones for whispers.
+// Must be executed under room lock
+func copyMessageAudienceSocketMapNonLocking(room *domain_structures.Room, message *domain_structures.RoomMessage) *map[string]*domain_structures.WebSocket {
+	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+
+	if message.RecipientUserInRoomUUID == "" {
+		return roomActiveClientSocketsByUUID
+	}
+
+	for socketUUID, userSocket := range *roomActiveClientSocketsByUUID {
+		socketUserInRoomUUID := room.ActiveRoomUserUUIDBySessionUUID[userSocket.SessionUUID]
+
+		if socketUserInRoomUUID != message.UserInRoomUUID && socketUserInRoomUUID != message.RecipientUserInRoomUUID {
+			delete(*roomActiveClientSocketsByUUID, socketUUID)
+		}
+	}
+
+	return roomActiveClientSocketsByUUID
+}
+
+// whispers sent or received by user, sorted by id. Must be executed under room lock
+func copyUserWhisperMessagesAsDTOArray(room *domain_structures.Room, userInRoomUUID string) []domain_structures.RoomMessageDTO {
+	userWhispersDTO := make([]domain_structures.RoomMessageDTO, 0)
+
+	for _, whisperMessage := range room.WhisperMessages {
+		if whisperMessage.UserInRoomUUID == userInRoomUUID || whisperMessage.RecipientUserInRoomUUID == userInRoomUUID {
+			userWhispersDTO = append(userWhispersDTO, copyMessageAsDTO(whisperMessage))
+		}
+	}
+
+	sort.Slice(userWhispersDTO, func(i, j int) bool {
+		return *userWhispersDTO[i].Id < *userWhispersDTO[j].Id
+	})
+
+	return userWhispersDTO
+}
+
+// frame with all whispers of joining user. Must be executed under room lock
+func makeUserWhisperMessagesFrame(room *domain_structures.Room, userInRoomUUID string) *domain_structures.OutMessageFrame {
+	userWhispersDTO := copyUserWhisperMessagesAsDTOArray(room, userInRoomUUID)
+	createdAt := time.Now().UnixNano()
+
+	return &domain_structures.OutMessageFrame{
+		Command:       domain_structures.TextMessageWhisper,
+		CreatedAtNano: &createdAt,
+		Message:       &userWhispersDTO,
+	}
+}
@@ -10,34 +10,43 @@ import (
 
 const MaxRoomDescriptionLength = 400
 
-// must be executed under room lock
+// pinned messages are never removed (and are not taken into account when choosing older half).
+// Must be executed under room lock
 func shrinkRoomMessagesMap(room *domain_structures.Room) int64 {
 	//sort existing messages by id to be able to cut older half
-	messagesArray := make([]*domain_structures.RoomMessage, len(room.RoomMessages))
+	messagesArray := make([]*domain_structures.RoomMessage, 0, len(room.RoomMessages))
 
-	i := 0
 	for _, message := range room.RoomMessages {
-		messagesArray[i] = message
-		i++
+		if !isMessagePinned(room, message.Id) {
+			messagesArray = append(messagesArray, message)
+		}
 	}
 	//may be slow but shrinking operation is rare
 	sort.Slice(messagesArray, func(i, j int) bool {
 		return messagesArray[i].Id < messagesArray[j].Id
 	})
 
-	newMessagesArray := append([]*domain_structures.RoomMessage{}, messagesArray[RoomMessagesLimit/2:]...)
+	//keep half of limit - limit may have been lowered, so more than half of messages may have to go
+	removedMessagesLen := room.RoomMessagesLen - room.Settings.MessagesLimit/2
+
+	if removedMessagesLen >= len(messagesArray) {
+		removedMessagesLen = len(messagesArray) - 1
+	}
+
+	//all messages are pinned (or single one is not) - nothing to remove
+	if removedMessagesLen <= 0 {
+		return -1
+	}
 
-	newRoomMessagesMap := make(map[int64]*domain_structures.RoomMessage, len(newMessagesArray))
+	removedMessageIds := make([]int64, 0, removedMessagesLen)
 
-	for i := 0; i < len(newMessagesArray); i++ {
-		msg := newMessagesArray[i]
-		newRoomMessagesMap[msg.Id] = msg
+	for _, msg := range messagesArray[:removedMessagesLen] {
+		removedMessageIds = append(removedMessageIds, msg.Id)
 	}
 
-	lowestRemainingMessageId := newMessagesArray[0].Id
+	lowestRemainingMessageId := messagesArray[removedMessagesLen].Id
 
-	room.RoomMessages = newRoomMessagesMap
-	room.RoomMessagesLen = len(room.RoomMessages)
+	ActiveRoomStore.DeleteMessages(room, removedMessageIds)
 
 	return lowestRemainingMessageId
 }
@@ -57,7 +66,7 @@ func copyMessageAsDTO(orig *domain_structures.RoomMessage) domain_structures.Roo
 		CreatedAtSec:     orig.CreatedAtSec,
 	}
 
-	return domain_structures.RoomMessageDTO{
+	messageDTO := domain_structures.RoomMessageDTO{
 		Id:               &messageSafeCopy.Id,
 		Text:             &messageSafeCopy.Text,
 		SupportedCount:   &messageSafeCopy.SupportedCount,
@@ -68,7 +77,34 @@ func copyMessageAsDTO(orig *domain_structures.RoomMessage) domain_structures.Roo
 		ReplyToMessageId: messageSafeCopy.ReplyToMessageId,
 		UserInRoomUUID:   &messageSafeCopy.UserInRoomUUID,
 		CreatedAtSec:     &messageSafeCopy.CreatedAtSec,
+		Reactions:        copyMessageReactions(orig),
+	}
+
+	if orig.RecipientUserInRoomUUID != "" {
+		recipientUserInRoomUUID := orig.RecipientUserInRoomUUID
+		messageDTO.RecipientUserInRoomUUID = &recipientUserInRoomUUID
+	}
+
+	if orig.ExpiresAtSec > 0 {
+		expiresAtSec := orig.ExpiresAtSec
+		messageDTO.ExpiresAtSec = &expiresAtSec
 	}
+
+	if orig.IsFlagged {
+		isFlagged := orig.IsFlagged
+		messageDTO.IsFlagged = &isFlagged
+	}
+
+	if len(orig.MentionedUserInRoomUUIDs) > 0 {
+		messageDTO.MentionedUserInRoomUUIDs = copyMentionedUserInRoomUUIDs(orig)
+	}
+
+	if orig.RevisionsCount > 0 {
+		revisionsCount := orig.RevisionsCount
+		messageDTO.RevisionsCount = &revisionsCount
+	}
+
+	return messageDTO
 }
 
 func copyEditedMessageAsDTO(orig *domain_structures.RoomMessage) domain_structures.RoomMessageDTO {
@@ -79,14 +115,20 @@ func copyEditedMessageAsDTO(orig *domain_structures.RoomMessage) domain_structur
 		LastEditedAt:     orig.LastEditedAt,
 		ReplyToUserId:    orig.ReplyToUserId,
 		ReplyToMessageId: orig.ReplyToMessageId,
+		IsFlagged:        orig.IsFlagged,
+		RevisionsCount:   orig.RevisionsCount,
 	}
 
+	//flag and mentions are always sent - edit may clear them
 	return domain_structures.RoomMessageDTO{
-		Id:               &messageSafeCopy.Id,
-		Text:             &messageSafeCopy.Text,
-		LastEditedAt:     messageSafeCopy.LastEditedAt,
-		ReplyToUserId:    messageSafeCopy.ReplyToUserId,
-		ReplyToMessageId: messageSafeCopy.ReplyToMessageId,
+		Id:                       &messageSafeCopy.Id,
+		Text:                     &messageSafeCopy.Text,
+		LastEditedAt:             messageSafeCopy.LastEditedAt,
+		ReplyToUserId:            messageSafeCopy.ReplyToUserId,
+		ReplyToMessageId:         messageSafeCopy.ReplyToMessageId,
+		IsFlagged:                &messageSafeCopy.IsFlagged,
+		MentionedUserInRoomUUIDs: copyMentionedUserInRoomUUIDs(orig),
+		RevisionsCount:           &messageSafeCopy.RevisionsCount,
 	}
 }
 
@@ -102,62 +144,85 @@ func copyVotedMessageAsDTO(orig *domain_structures.RoomMessage) domain_structure
 		Id:             &messageSafeCopy.Id,
 		SupportedCount: &messageSafeCopy.SupportedCount,
 		RejectedCount:  &messageSafeCopy.RejectedCount,
+		//same counters as reactions delta (zero means reaction is gone)
+		Reactions: map[string]int{
+			SupportReaction: messageSafeCopy.SupportedCount,
+			RejectReaction:  messageSafeCopy.RejectedCount,
+		},
 	}
 }
 
 func copyAllRoomMessagesAsDTOArray(roomMessages *map[int64]*domain_structures.RoomMessage) *[]domain_structures.RoomMessageDTO {
-	dtoArray := make([]domain_structures.RoomMessageDTO, len(*roomMessages))
+	dtoArray := make([]domain_structures.RoomMessageDTO, 0, len(*roomMessages))
 
-	i := 0
 	for _, orig := range *roomMessages {
-		//safe copy of current message state
-		messageSafeCopy := domain_structures.RoomMessage{
-			Id:               orig.Id,
-			Text:             orig.Text,
-			SupportedCount:   orig.SupportedCount,
-			RejectedCount:    orig.RejectedCount,
-			LastEditedAt:     orig.LastEditedAt,
-			LastVotedAt:      orig.LastVotedAt,
-			ReplyToUserId:    orig.ReplyToUserId,
-			ReplyToMessageId: orig.ReplyToMessageId,
-			UserInRoomUUID:   orig.UserInRoomUUID,
-			CreatedAtSec:     orig.CreatedAtSec,
-		}
+		dtoArray = append(dtoArray, copyMessageAsDTO(orig))
+	}
+
+	return &dtoArray
+}
+
+// returns up to 'limit' latest messages with id lower than beforeMessageId (any id if <= 0), sorted by id.
+// Non-positive limit means all messages. Second value tells if there are older messages left. Must be executed under room lock
+func copyRoomMessagesPageAsDTOArray(room *domain_structures.Room, beforeMessageId int64, limit int) (*[]domain_structures.RoomMessageDTO, bool) {
+	//sorting ids is much cheaper than copying and sorting all messages
+	messageIds := make([]int64, 0, len(room.RoomMessages))
 
-		dtoArray[i] = domain_structures.RoomMessageDTO{
-			Id:               &messageSafeCopy.Id,
-			Text:             &messageSafeCopy.Text,
-			SupportedCount:   &messageSafeCopy.SupportedCount,
-			RejectedCount:    &messageSafeCopy.RejectedCount,
-			LastEditedAt:     messageSafeCopy.LastEditedAt,
-			LastVotedAt:      messageSafeCopy.LastVotedAt,
-			ReplyToUserId:    messageSafeCopy.ReplyToUserId,
-			ReplyToMessageId: messageSafeCopy.ReplyToMessageId,
-			UserInRoomUUID:   &messageSafeCopy.UserInRoomUUID,
-			CreatedAtSec:     &messageSafeCopy.CreatedAtSec,
+	for messageId := range room.RoomMessages {
+		if beforeMessageId <= 0 || messageId < beforeMessageId {
+			messageIds = append(messageIds, messageId)
 		}
+	}
 
-		i++
+	sort.Slice(messageIds, func(i, j int) bool {
+		return messageIds[i] < messageIds[j]
+	})
+
+	hasMore := false
+
+	if limit > 0 && len(messageIds) > limit {
+		messageIds = messageIds[len(messageIds)-limit:]
+		hasMore = true
 	}
 
-	return &dtoArray
+	dtoArray := make([]domain_structures.RoomMessageDTO, len(messageIds))
+
+	for i, messageId := range messageIds {
+		dtoArray[i] = copyMessageAsDTO(room.RoomMessages[messageId])
+	}
+
+	return &dtoArray, hasMore
 }
 
 func copyAllRoomUsersList(room *domain_structures.Room) *[]domain_structures.RoomUserDTO {
 	var allRoomUsersCopy []domain_structures.RoomUserDTO
 
-	for _, user := range room.AllRoomAuthorizedUsersBySessionUUID {
+	for sessionUUID, user := range room.AllRoomAuthorizedUsersBySessionUUID {
 		//safe copy of current variable values
 		userInRoomUUID := user.UserInRoomUUID
 		userName := user.UserName
 		isAnonName := user.IsAnonName
 		isOnlineInRoom := isUserOnlineInRoom(room, user.UserInRoomUUID)
+		role := getRoomUserRole(room, sessionUUID, user.UserInRoomUUID)
+		lastReadMessageId := user.LastReadMessageId
+		lastSeenAt := user.LastSeenAt
+
+		var presence *string = nil
+
+		if isOnlineInRoom && user.Presence != "" {
+			userPresence := user.Presence
+			presence = &userPresence
+		}
 
 		allRoomUsersCopy = append(allRoomUsersCopy, domain_structures.RoomUserDTO{
-			UserInRoomUUID: &userInRoomUUID,
-			UserName:       &userName,
-			IsAnonName:     &isAnonName,
-			IsOnlineInRoom: &isOnlineInRoom,
+			UserInRoomUUID:    &userInRoomUUID,
+			UserName:          &userName,
+			IsAnonName:        &isAnonName,
+			IsOnlineInRoom:    &isOnlineInRoom,
+			Role:              &role,
+			LastReadMessageId: &lastReadMessageId,
+			Presence:          presence,
+			LastSeenAt:        &lastSeenAt,
 		})
 	}
 
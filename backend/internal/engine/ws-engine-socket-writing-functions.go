@@ -111,6 +111,8 @@ func writeMembersListChangedFrameToActiveRoomMembers(room *domain_structures.Roo
 		AllRoomUsers:  allRoomUsersCopy,
 	}
 
+	recordRoomEvent(room, roomMembersListChangedDispatchingFrame)
+
 	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
 
 	room.Unlock()
@@ -133,6 +135,11 @@ func writeRoomDescriptionChangedFrameToActiveRoomMembers(room *domain_structures
 		roomCreatorUserInRoomUUID = &roomCreatorUser.UserInRoomUUID
 	}
 
+	roomSettingsCopy := room.Settings
+	isAnnouncementModeCopy := room.IsAnnouncementMode
+	slowModeIntervalSecCopy := room.SlowModeIntervalSec
+	messageEditWindowSecCopy := room.MessageEditWindowSec
+
 	roomDescriptionChangedDispatchingFrame := &domain_structures.OutMessageFrame{
 		Command:                   domain_structures.RoomChangeDescription,
 		RoomCreatorUserInRoomUUID: roomCreatorUserInRoomUUID,
@@ -140,8 +147,14 @@ func writeRoomDescriptionChangedFrameToActiveRoomMembers(room *domain_structures
 		Message: &[]domain_structures.RoomMessageDTO{
 			{Text: &room.Description},
 		},
+		RoomSettings:         &roomSettingsCopy,
+		IsAnnouncementMode:   &isAnnouncementModeCopy,
+		SlowModeIntervalSec:  &slowModeIntervalSecCopy,
+		MessageEditWindowSec: &messageEditWindowSecCopy,
 	}
 
+	recordRoomEvent(room, roomDescriptionChangedDispatchingFrame)
+
 	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
 
 	room.Unlock()
@@ -154,6 +167,7 @@ func writeNotificationToActiveRoomMembers(
 	room *domain_structures.Room,
 	roomActiveClientSocketsByUUID *map[string]*domain_structures.WebSocket,
 	additionalInfo *string,
+	isRoomEvent bool,
 ) {
 	createdAt := time.Now().UnixNano()
 
@@ -165,6 +179,13 @@ func writeNotificationToActiveRoomMembers(
 		},
 	}
 
+	//notifications that change room state (e.g. messages removal) must be replayed for re-joining users
+	if isRoomEvent {
+		room.Lock()
+		recordRoomEvent(room, notificationDispatchingFrame)
+		room.Unlock()
+	}
+
 	writeFrameToActiveRoomMembers(notificationDispatchingFrame, room, roomActiveClientSocketsByUUID)
 }
 
@@ -173,13 +194,35 @@ func writeErrorMessageToSocket(
 	error domain_structures.WsError,
 	requestId *string,
 ) {
-	doWriteErrorMessageToSocket(clSocket, error, requestId, false, nil)
+	doWriteErrorMessageToSocket(clSocket, error, requestId, nil, false, nil)
+}
+
+func writeErrorMessageWithDetailsToSocket(
+	clSocket *domain_structures.WebSocket,
+	error domain_structures.WsError,
+	requestId *string,
+	processingDetails *string,
+) {
+	doWriteErrorMessageToSocket(clSocket, error, requestId, processingDetails, false, nil)
+}
+
+// retry-after (in milliseconds) is passed as processing details
+func writeErrorWithRetryAfterToSocket(
+	clSocket *domain_structures.WebSocket,
+	error domain_structures.WsError,
+	retryAfter time.Duration,
+	requestId *string,
+) {
+	retryAfterMs := strconv.FormatInt(retryAfter.Milliseconds()+1, 10)
+
+	writeErrorMessageWithDetailsToSocket(clSocket, error, requestId, &retryAfterMs)
 }
 
 func doWriteErrorMessageToSocket(
 	clSocket *domain_structures.WebSocket,
 	error domain_structures.WsError,
 	requestId *string,
+	processingDetails *string,
 	isSyncWriteRequired bool,
 	syncWriteTimeout *time.Duration,
 ) {
@@ -187,9 +230,10 @@ func doWriteErrorMessageToSocket(
 	errorCodeStr := strconv.Itoa(error.Code)
 
 	errorFrame := domain_structures.OutMessageFrame{
-		Command:       domain_structures.Error,
-		CreatedAtNano: &createdAt,
-		RequestId:     requestId,
+		Command:           domain_structures.Error,
+		CreatedAtNano:     &createdAt,
+		RequestId:         requestId,
+		ProcessingDetails: processingDetails,
 		Message: &[]domain_structures.RoomMessageDTO{
 			{Text: &errorCodeStr},
 		},
@@ -214,7 +258,7 @@ func doWriteErrorMessageToSocket(
 }
 
 func writeRequestProcessedToSocket(clSocket *domain_structures.WebSocket, requestId *string) {
-	writeRequestProcessedToSocketWithAdditInfo(clSocket, nil, requestId, nil, nil, nil, nil)
+	writeRequestProcessedToSocketWithAdditInfo(clSocket, nil, requestId, nil, nil, nil, nil, nil)
 }
 
 func writeRequestProcessedToSocketWithAdditInfo(
@@ -225,6 +269,7 @@ func writeRequestProcessedToSocketWithAdditInfo(
 	roomId *string,
 	userInRoomId *string,
 	currentBuildNumber *string,
+	ownerRecoveryKey *string,
 ) {
 	requestProcessedFrame := domain_structures.OutMessageFrame{
 		Command:            domain_structures.RequestProcessed,
@@ -234,6 +279,7 @@ func writeRequestProcessedToSocketWithAdditInfo(
 		RoomUUID:           roomId,
 		UserInRoomUUID:     userInRoomId,
 		CurrentBuildNumber: currentBuildNumber,
+		OwnerRecoveryKey:   ownerRecoveryKey,
 	}
 
 	frameJson, err := json.Marshal(requestProcessedFrame)
@@ -248,10 +294,26 @@ func writeRequestProcessedToSocketWithAdditInfo(
 	})
 }
 
+func writeFrameToSocket(clSocket *domain_structures.WebSocket, frame *domain_structures.OutMessageFrame) {
+	frameJson, err := json.Marshal(*frame)
+	if err != nil {
+		util.LogSevere("error serializing frame to JSON. Frame: '%s', error: '%s'", *frame, err)
+
+		return
+	}
+
+	clSocket.PutMessage(&domain_structures.OutMessageWrapper{
+		OutMessageJson: &frameJson,
+	})
+}
+
 func writeAfterRoomJoinMessagesToSocket(
 	roomMembersListChangedFrame *domain_structures.OutMessageFrame,
 	allMessagesFrame *domain_structures.OutMessageFrame,
+	userWhisperMessagesFrame *domain_structures.OutMessageFrame,
 	roomDescriptionFrame *domain_structures.OutMessageFrame,
+	pinnedMessagesFrame *domain_structures.OutMessageFrame,
+	missedEventFramesJson [][]byte,
 	clSocket *domain_structures.WebSocket,
 ) error {
 	roomMembersListChangedFrameJson, err := json.Marshal(roomMembersListChangedFrame)
@@ -260,15 +322,15 @@ func writeAfterRoomJoinMessagesToSocket(
 
 		return err
 	}
-	allMessagesFrameJson, err := json.Marshal(allMessagesFrame)
+	roomDescriptionFrameJson, err := json.Marshal(roomDescriptionFrame)
 	if err != nil {
-		util.LogSevere("error serializing frame to JSON. Frame: '%s', error: '%s'", allMessagesFrame, err)
+		util.LogSevere("error serializing frame to JSON. Frame: '%s', error: '%s'", roomDescriptionFrame, err)
 
 		return err
 	}
-	roomDescriptionFrameJson, err := json.Marshal(roomDescriptionFrame)
+	pinnedMessagesFrameJson, err := json.Marshal(pinnedMessagesFrame)
 	if err != nil {
-		util.LogSevere("error serializing frame to JSON. Frame: '%s', error: '%s'", roomDescriptionFrame, err)
+		util.LogSevere("error serializing frame to JSON. Frame: '%s', error: '%s'", pinnedMessagesFrame, err)
 
 		return err
 	}
@@ -277,14 +339,48 @@ func writeAfterRoomJoinMessagesToSocket(
 		OutMessageJson: &roomMembersListChangedFrameJson,
 	})
 
+	//all messages frame is omitted when user gets only events missed since his previous visit
+	if allMessagesFrame != nil {
+		allMessagesFrameJson, err := json.Marshal(allMessagesFrame)
+		if err != nil {
+			util.LogSevere("error serializing frame to JSON. Frame: '%s', error: '%s'", allMessagesFrame, err)
+
+			return err
+		}
+
+		clSocket.PutMessage(&domain_structures.OutMessageWrapper{
+			OutMessageJson: &allMessagesFrameJson,
+		})
+	}
+
+	//omitted together with all messages frame
+	if userWhisperMessagesFrame != nil {
+		userWhisperMessagesFrameJson, err := json.Marshal(userWhisperMessagesFrame)
+		if err != nil {
+			util.LogSevere("error serializing frame to JSON. Frame: '%s', error: '%s'", userWhisperMessagesFrame, err)
+
+			return err
+		}
+
+		clSocket.PutMessage(&domain_structures.OutMessageWrapper{
+			OutMessageJson: &userWhisperMessagesFrameJson,
+		})
+	}
+
 	clSocket.PutMessage(&domain_structures.OutMessageWrapper{
-		OutMessageJson: &allMessagesFrameJson,
+		OutMessageJson: &roomDescriptionFrameJson,
 	})
 
 	clSocket.PutMessage(&domain_structures.OutMessageWrapper{
-		OutMessageJson: &roomDescriptionFrameJson,
+		OutMessageJson: &pinnedMessagesFrameJson,
 	})
 
+	for i := range missedEventFramesJson {
+		clSocket.PutMessage(&domain_structures.OutMessageWrapper{
+			OutMessageJson: &missedEventFramesJson[i],
+		})
+	}
+
 	return nil
 }
 
@@ -314,6 +410,8 @@ func removeDeadSocketFromRoom(room *domain_structures.Room, clSocket *domain_str
 				delete(room.ActiveRoomUserUUIDBySessionUUID, clSocket.SessionUUID)
 				room.ActiveRoomUsersLen = len(room.ActiveRoomUserUUIDBySessionUUID)
 
+				markUserOfflineNonLocking(room, clSocket.SessionUUID, clSocket)
+
 				room.Unlock()
 
 				writeMembersListChangedFrameToActiveRoomMembers(room, nil)
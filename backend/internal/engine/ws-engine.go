@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode"
 
@@ -30,11 +32,10 @@ const SocketReadLimitBytes = 50000
 const RoomCredsMinChars = 3
 const RoomCredsMaxChars = 100
 
-const RoomMaxUsersLimit = 100
-
 var RoomCredsValidationErrorInvalidLength = errors.New("invalid room credentials length")
 var RoomCredsValidationErrorNameForbidden = errors.New("room name forbidden")
 var RoomCredsValidationErrorNameHasBadChars = errors.New("room name contains bad characters")
+var RoomSettingsValidationErrorOutOfBounds = errors.New("room settings out of bounds")
 
 var allowedRoomNameSpecialChars = []string{
 	"!",
@@ -52,9 +53,9 @@ var allowedRoomNameSpecialChars = []string{
 	"]",
 }
 
-const RoomMessagesLimit = 500
-
-var RoomMessagesLimitApproachingWarningBreakpoints = []int{460, 480, 495}
+// page size bounds for TM_HISTORY requests
+const HistoryPageDefaultLimit = 50
+const HistoryPageMaxLimit = 200
 
 /* Variables */
 
@@ -72,6 +73,10 @@ var wsUpgrader = websocket.Upgrader{
 
 var ServerStatus = util.ServerStatusOnline
 
+// draining backend keeps serving existing rooms but refuses to create new ones (set via ctrl command).
+// Accessed atomically - it is written by ctrl requests and read by socket routines (1 - draining)
+var isDraining int32
+
 // global rooms in-memory storage
 var ActiveRoomsByNameMap = domain_structures.ActiveRoomsByName{
 	ActiveRoomsByName: make(map[string]*domain_structures.Room),
@@ -138,6 +143,7 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 		Socket:              socketConn,
 		SocketUUID:          "",
 		SessionUUID:         session.SessionUUID,
+		ClientIp:            util.GetClientIp(r),
 		LastKeepAliveSignal: time.Now().UnixNano(),
 		OutMessagesPutCh:    outMessagesPutCh,
 		OutMessagesGetCh:    outMessagesGetCh,
@@ -188,9 +194,11 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 		/* Check if this is KeepAlive signal */
 
 		if inFrame.KeepAliveBeacon == "OK" {
-			clSocket.LastKeepAliveSignal = time.Now().UnixNano()
+			atomic.StoreInt64(&clSocket.LastKeepAliveSignal, time.Now().UnixNano())
 			util.LogTrace("KeepAlive: socket: '%s', session '%s'", clSocket.SocketUUID, clSocket.SessionUUID)
 
+			touchUserPresence(clSocket)
+
 			continue
 		}
 
@@ -213,8 +221,17 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				logIntoRoom(existingRoom, clSocket, &inFrame, false)
 
 			} else {
+				if IsDraining() {
+					ActiveRoomsByNameMap.Unlock()
+
+					util.LogInfo("refused to create room '%s' - backend is draining", inFrame.Room.Name)
+					writeErrorMessageToSocket(clSocket, domain_structures.WsServerDraining, inFrame.RequestId)
+
+					continue
+				}
+
 				//create room
-				newRoom, err := createRoom(inFrame.Room.Name, inFrame.Room.Password, clSocket.SessionUUID)
+				newRoom, err := createRoom(inFrame.Room.Name, inFrame.Room.Password, clSocket.SessionUUID, inFrame.RoomSettings)
 
 				if err != nil {
 					ActiveRoomsByNameMap.Unlock()
@@ -231,6 +248,10 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 						util.LogTrace("room name contains bad characters: '%s'", inFrame.Room.Name)
 
 						writeErrorMessageToSocket(clSocket, domain_structures.WsRoomCredsValidationErrorNameHasBadChars, inFrame.RequestId)
+					} else if err == RoomSettingsValidationErrorOutOfBounds {
+						util.LogTrace("room settings are out of bounds: '%+v'", inFrame.RoomSettings)
+
+						writeErrorMessageToSocket(clSocket, domain_structures.WsRoomValidationErrorBadSettings, inFrame.RequestId)
 					} else {
 						util.LogSevere("error while creating room: '%s'", err)
 
@@ -265,7 +286,16 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
-			newRoom, err := createRoom(inFrame.Room.Name, inFrame.Room.Password, clSocket.SessionUUID)
+			if IsDraining() {
+				ActiveRoomsByNameMap.Unlock()
+
+				util.LogInfo("refused to create room '%s' - backend is draining", inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsServerDraining, inFrame.RequestId)
+
+				continue
+			}
+
+			newRoom, err := createRoom(inFrame.Room.Name, inFrame.Room.Password, clSocket.SessionUUID, inFrame.RoomSettings)
 
 			if err != nil {
 				ActiveRoomsByNameMap.Unlock()
@@ -282,6 +312,10 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 					util.LogTrace("room name contains bad characters: '%s'", inFrame.Room.Name)
 
 					writeErrorMessageToSocket(clSocket, domain_structures.WsRoomCredsValidationErrorNameHasBadChars, inFrame.RequestId)
+				} else if err == RoomSettingsValidationErrorOutOfBounds {
+					util.LogTrace("room settings are out of bounds: '%+v'", inFrame.RoomSettings)
+
+					writeErrorMessageToSocket(clSocket, domain_structures.WsRoomValidationErrorBadSettings, inFrame.RequestId)
 				} else {
 					util.LogSevere("error while creating room: '%s'", err)
 
@@ -337,6 +371,15 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			if room.IsFrozen {
+				room.Unlock()
+
+				util.LogInfo("failed to change name for user '%s' - room '%s' is being migrated", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomIsMigrating, inFrame.RequestId)
+
+				continue
+			}
+
 			existingRoomUser, userFound := room.AllRoomAuthorizedUsersBySessionUUID[clSocket.SessionUUID]
 
 			if !userFound {
@@ -372,6 +415,8 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 			existingRoomUser.UserName = roomUserName
 			existingRoomUser.IsAnonName = isAnon
 
+			ActiveRoomStore.AuthorizeUser(room, clSocket.SessionUUID, existingRoomUser)
+
 			room.Unlock()
 
 			writeMembersListChangedFrameToActiveRoomMembers(room, nil)
@@ -401,6 +446,15 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			if room.IsFrozen {
+				room.Unlock()
+
+				util.LogInfo("failed to change room description for user '%s' - room '%s' is being migrated", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomIsMigrating, inFrame.RequestId)
+
+				continue
+			}
+
 			_, userFound := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
 
 			if !userFound {
@@ -423,11 +477,11 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
-			if clSocket.SessionUUID != room.CreatedBySessionUUID {
+			if !isRoomOwnerOrModerator(room, clSocket.SessionUUID) {
 				room.Unlock()
 
-				util.LogWarn("failed to change room description - user '%s' is not a creator of room '%d'", clSocket.SessionUUID, inFrame.Room.Name)
-				writeErrorMessageToSocket(clSocket, domain_structures.WsInvalidInput, inFrame.RequestId)
+				util.LogWarn("failed to change room description - user '%s' is not an owner or moderator of room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotPermitted, inFrame.RequestId)
 
 				continue
 			}
@@ -449,12 +503,63 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 
 			room.Description = trimmedNewDescription
 
+			ActiveRoomStore.SaveRoomInfo(room)
+
 			room.Unlock()
 
 			writeRoomDescriptionChangedFrameToActiveRoomMembers(room, ServerStatus)
 
 			writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
 
+		case domain_structures.RoomChangeSettings:
+			processRoomSettingsCommand(clSocket, &inFrame)
+
+		case domain_structures.RoomAnnouncementMode:
+			processRoomAnnouncementModeCommand(clSocket, &inFrame)
+
+		case domain_structures.RoomSlowMode:
+			processRoomSlowModeCommand(clSocket, &inFrame)
+
+		case domain_structures.RoomMessageEditPolicy:
+			processRoomMessageEditPolicyCommand(clSocket, &inFrame)
+
+		case domain_structures.RoomKickUser,
+			domain_structures.RoomBanUser,
+			domain_structures.RoomUnbanUser,
+			domain_structures.RoomBannedUsers:
+			processRoomModerationCommand(clSocket, &inFrame)
+
+		case domain_structures.RoomGrantModerator,
+			domain_structures.RoomRevokeModerator,
+			domain_structures.RoomTransferOwnership,
+			domain_structures.RoomReclaimOwnership:
+			processRoomRolesCommand(clSocket, &inFrame)
+
+		case domain_structures.TextMessagePin,
+			domain_structures.TextMessageUnpin:
+			processMessagePinCommand(clSocket, &inFrame)
+
+		case domain_structures.TextMessageReaction:
+			processMessageReactionCommand(clSocket, &inFrame)
+
+		case domain_structures.TextMessageWhisper:
+			processWhisperCommand(clSocket, &inFrame)
+
+		case domain_structures.TextMessageSearch:
+			processMessageSearchCommand(clSocket, &inFrame)
+
+		case domain_structures.TextMessageRevisions:
+			processMessageRevisionsCommand(clSocket, &inFrame)
+
+		case domain_structures.UserTyping:
+			processUserTypingCommand(clSocket, &inFrame)
+
+		case domain_structures.UserReadMarker:
+			processUserReadMarkerCommand(clSocket, &inFrame)
+
+		case domain_structures.UserPresence:
+			processUserPresenceCommand(clSocket, &inFrame)
+
 		case domain_structures.TextMessage:
 			room := ActiveRoomsByNameMap.Get(inFrame.Room.Name)
 
@@ -478,6 +583,15 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			if room.IsFrozen {
+				room.Unlock()
+
+				util.LogInfo("failed to send message for user '%s' - room '%s' is being migrated", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomIsMigrating, inFrame.RequestId)
+
+				continue
+			}
+
 			userInRoomUUID, userFound := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
 
 			if !userFound {
@@ -500,31 +614,67 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			if !canPostToRoom(room, clSocket.SessionUUID) {
+				room.Unlock()
+
+				util.LogTrace("failed to send message - user '%s' can not post to announcement room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomAnnouncementOnly, inFrame.RequestId)
+
+				continue
+			}
+
+			if !takeMessageRateLimitTokenNonLocking(room, clSocket, inFrame.RequestId) {
+				room.Unlock()
+
+				continue
+			}
+
 			message := inFrame.Message
 
+			//NOTE: we are expecting message text to come url-escaped
+			filterResult, accepted := filterMessageTextForSocket(room, clSocket, message.Text, inFrame.RequestId)
+
+			if !accepted {
+				room.Unlock()
+
+				util.LogTrace("failed to send message - message of user '%s' was rejected in room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+
+				continue
+			}
+
 			util.LogTrace("user '%s' is sending message of len '%d' to room '%s' / '%s'",
-				clSocket.SessionUUID, len(message.Text), room.Id, room.Name)
+				clSocket.SessionUUID, len(filterResult.Text), room.Id, room.Name)
+
+			mentionedUserInRoomUUIDs := resolveMessageMentionsNonLocking(room, filterResult.Text, userInRoomUUID)
 
 			//transform message and add to room messages array
 			newRoomMessage := addNewMessageToRoom(
 				room,
 				userInRoomUUID,
-				message.Text, //NOTE: we are expecting message text to come url-escaped
+				filterResult.Text,
 				message.ReplyToUserId,
 				message.ReplyToMessageId,
+				message.TtlSec,
+				filterResult.IsFlagged,
+				mentionedUserInRoomUUIDs,
 			)
 
 			//check room messages amount. if reached limit - cut messages list in half
 			lowestMessageIdAfterShrink := checkLimitAndShrinkMessagesList(room)
 
+			newRoomMessageDTO := copyMessageAsDTO(newRoomMessage)
+
 			messageDispatchingFrame := &domain_structures.OutMessageFrame{
 				Command: domain_structures.TextMessage,
-				Message: &[]domain_structures.RoomMessageDTO{copyMessageAsDTO(newRoomMessage)},
+				Message: &[]domain_structures.RoomMessageDTO{newRoomMessageDTO},
 			}
 
+			recordRoomEvent(room, messageDispatchingFrame)
+
 			//make copy of active client sockets connected to this room while under lock.
 			//After unlock - initial list may be updated at any point by parallel routines
 			roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+			mentionedUsersSocketsByUUID := copyMentionedUsersSocketMapNonLocking(room, mentionedUserInRoomUUIDs)
 
 			room.Unlock()
 
@@ -536,6 +686,8 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				lowestMessageIdAfterShrink,
 			)
 
+			writeMentionNotificationToSockets(room, newRoomMessageDTO, mentionedUsersSocketsByUUID)
+
 			writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
 
 		case domain_structures.TextMessageEdit:
@@ -561,6 +713,15 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			if room.IsFrozen {
+				room.Unlock()
+
+				util.LogInfo("failed to edit message for user '%s' - room '%s' is being migrated", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomIsMigrating, inFrame.RequestId)
+
+				continue
+			}
+
 			userInRoomUUID, userFound := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
 
 			if !userFound {
@@ -585,7 +746,7 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 
 			message := inFrame.Message
 
-			existingMessage, messageFound := room.RoomMessages[message.Id]
+			existingMessage, messageFound := findRoomOrWhisperMessage(room, message.Id)
 
 			if !messageFound {
 				room.Unlock()
@@ -605,15 +766,67 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			//whispers are allowed in announcement mode, so are their edits
+			if existingMessage.RecipientUserInRoomUUID == "" && !canPostToRoom(room, clSocket.SessionUUID) {
+				room.Unlock()
+
+				util.LogTrace("failed to edit message - user '%s' can not post to announcement room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomAnnouncementOnly, inFrame.RequestId)
+
+				continue
+			}
+
+			if editError, editAllowed := checkMessageEditAllowedNonLocking(room, clSocket.SessionUUID, existingMessage); !editAllowed {
+				room.Unlock()
+
+				util.LogTrace("failed to edit message - message '%d' can not be edited in room '%s': %s",
+					existingMessage.Id, inFrame.Room.Name, editError.Text)
+				writeErrorMessageToSocket(clSocket, editError, inFrame.RequestId)
+
+				continue
+			}
+
+			if !takeMessageEditRateLimitTokenNonLocking(room, clSocket, inFrame.RequestId) {
+				room.Unlock()
+
+				continue
+			}
+
+			//NOTE: we are expecting message text to come url-escaped
+			filterResult, accepted := filterMessageTextForSocket(room, clSocket, message.Text, inFrame.RequestId)
+
+			if !accepted {
+				room.Unlock()
+
+				util.LogTrace("failed to edit message - new text of message '%d' was rejected in room '%s'", existingMessage.Id, inFrame.Room.Name)
+
+				continue
+			}
+
 			util.LogTrace("user '%s' is editing message '%d' in room '%s' / '%s'",
 				clSocket.SessionUUID, existingMessage.Id, room.Id, room.Name)
 
-			existingMessage.Text = message.Text //NOTE: we are expecting message text to come url-escaped
-			existingMessage.ReplyToUserId = message.ReplyToUserId
-			existingMessage.ReplyToMessageId = message.ReplyToMessageId
-
 			lastEditedAt := time.Now().UnixNano()
-			existingMessage.LastEditedAt = &lastEditedAt
+
+			//whispers are seen by recipient only, so they mention nobody
+			var mentionedUserInRoomUUIDs []string
+
+			if existingMessage.RecipientUserInRoomUUID == "" {
+				mentionedUserInRoomUUIDs = resolveMessageMentionsNonLocking(room, filterResult.Text, userInRoomUUID)
+			}
+
+			newlyMentionedUserInRoomUUIDs := getNewlyMentionedUserInRoomUUIDs(existingMessage.MentionedUserInRoomUUIDs, mentionedUserInRoomUUIDs)
+
+			ActiveRoomStore.EditMessage(
+				room,
+				existingMessage,
+				filterResult.Text,
+				message.ReplyToUserId,
+				message.ReplyToMessageId,
+				lastEditedAt,
+				filterResult.IsFlagged,
+				mentionedUserInRoomUUIDs,
+			)
 
 			messageEditDispatchingFrame := &domain_structures.OutMessageFrame{
 				Command:       domain_structures.TextMessageEdit,
@@ -621,15 +834,23 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				CreatedAtNano: &lastEditedAt,
 			}
 
-			//make copy of active client sockets connected to this room while under lock.
+			recordMessageEvent(room, existingMessage, messageEditDispatchingFrame)
+
+			//make copy of active client sockets connected to this room (only whisper participants for whispers) while under lock.
 			//After unlock - initial list may be updated at any point by parallel routines
-			roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+			roomActiveClientSocketsByUUID := copyMessageAudienceSocketMapNonLocking(room, existingMessage)
+
+			//users mentioned before edit were notified already
+			mentionedUsersSocketsByUUID := copyMentionedUsersSocketMapNonLocking(room, newlyMentionedUserInRoomUUIDs)
+			editedMessageDTO := copyMessageAsDTO(existingMessage)
 
 			room.Unlock()
 
 			//send new message to all active users, respond OK to user immediately
 			writeFrameToActiveRoomMembers(messageEditDispatchingFrame, room, roomActiveClientSocketsByUUID)
 
+			writeMentionNotificationToSockets(room, editedMessageDTO, mentionedUsersSocketsByUUID)
+
 			writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
 
 		case domain_structures.TextMessageDelete:
@@ -655,6 +876,15 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			if room.IsFrozen {
+				room.Unlock()
+
+				util.LogInfo("failed to delete message for user '%s' - room '%s' is being migrated", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomIsMigrating, inFrame.RequestId)
+
+				continue
+			}
+
 			userInRoomUUID, userFound := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
 
 			if !userFound {
@@ -679,7 +909,7 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 
 			message := inFrame.Message
 
-			existingMessage, messageFound := room.RoomMessages[message.Id]
+			existingMessage, messageFound := findRoomOrWhisperMessage(room, message.Id)
 
 			if !messageFound {
 				room.Unlock()
@@ -689,7 +919,10 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
-			if existingMessage.UserInRoomUUID != userInRoomUUID {
+			//room owner and moderators can delete any public message. Whispers are not visible to them - only author can delete those
+			canModerateMessage := existingMessage.RecipientUserInRoomUUID == "" && isRoomOwnerOrModerator(room, clSocket.SessionUUID)
+
+			if existingMessage.UserInRoomUUID != userInRoomUUID && !canModerateMessage {
 				room.Unlock()
 
 				util.LogWarn("failed to delete message - user '%s' is not an author of message '%d' for room '%s'",
@@ -702,25 +935,46 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 			util.LogTrace("user '%s' is deleting message '%d' in room '%s' / '%s'",
 				clSocket.SessionUUID, existingMessage.Id, room.Id, room.Name)
 
-			delete(room.RoomMessages, existingMessage.Id)
+			ActiveRoomStore.DeleteMessages(room, []int64{existingMessage.Id})
 
-			room.RoomMessagesLen = len(room.RoomMessages)
+			removedByUserInRoomUUID := userInRoomUUID
 
 			messageDeleteDispatchingFrame := &domain_structures.OutMessageFrame{
 				Command: domain_structures.TextMessageDelete,
 				Message: &[]domain_structures.RoomMessageDTO{
-					{Id: &existingMessage.Id}, //no need in safe copy because Id field wont change
+					{
+						Id:                      &existingMessage.Id, //no need in safe copy because Id field wont change
+						RemovedByUserInRoomUUID: &removedByUserInRoomUUID,
+					},
 				},
 			}
 
+			recordMessageEvent(room, existingMessage, messageDeleteDispatchingFrame)
+
+			//deleted message can not stay pinned
+			var pinnedMessagesDispatchingFrame *domain_structures.OutMessageFrame = nil
+
+			if unpinMessageNonLocking(room, existingMessage.Id) {
+				ActiveRoomStore.SaveRoomInfo(room)
+
+				pinnedMessagesDispatchingFrame = makePinnedMessagesFrame(room)
+
+				recordRoomEvent(room, pinnedMessagesDispatchingFrame)
+			}
+
 			//make copy of active client sockets connected to this room while under lock.
 			//After unlock - initial list may be updated at any point by parallel routines
 			roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+			messageAudienceSocketsByUUID := copyMessageAudienceSocketMapNonLocking(room, existingMessage)
 
 			room.Unlock()
 
-			//send new message to all active users, respond OK to user immediately
-			writeFrameToActiveRoomMembers(messageDeleteDispatchingFrame, room, roomActiveClientSocketsByUUID)
+			//send new message to all active users (only whisper participants for whispers), respond OK to user immediately
+			writeFrameToActiveRoomMembers(messageDeleteDispatchingFrame, room, messageAudienceSocketsByUUID)
+
+			if pinnedMessagesDispatchingFrame != nil {
+				writeFrameToActiveRoomMembers(pinnedMessagesDispatchingFrame, room, roomActiveClientSocketsByUUID)
+			}
 
 			writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
 
@@ -749,6 +1003,15 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			if room.IsFrozen {
+				room.Unlock()
+
+				util.LogInfo("failed to support/reject ('%v') message for user '%s' - room '%s' is being migrated", isSupport, clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomIsMigrating, inFrame.RequestId)
+
+				continue
+			}
+
 			userInRoomUUID, userFound := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
 
 			if !userFound {
@@ -782,8 +1045,8 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 
 				continue
 			}
-			//not allowed for same user that created message, except for room creator
-			if existingMessage.UserInRoomUUID == userInRoomUUID && room.CreatedBySessionUUID != clSocket.SessionUUID {
+			//not allowed for same user that created message, except for room owner
+			if existingMessage.UserInRoomUUID == userInRoomUUID && !isRoomOwner(room, clSocket.SessionUUID) {
 				room.Unlock()
 
 				util.LogWarn("failed to support/reject ('%v') message - user '%s' is an author of message '%d'", isSupport, clSocket.SocketUUID, existingMessage.Id)
@@ -795,54 +1058,9 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 			util.LogTrace("user '%s' is supporting/rejecting ('%v') message '%d' in room '%s' / '%s'",
 				isSupport, clSocket.SessionUUID, existingMessage.Id, room.Id, room.Name)
 
-			messageVotes, votesInitialized := room.MessageVotesByMessageId[existingMessage.Id]
-
-			if !votesInitialized {
-				messageVotes = &domain_structures.RoomMessageVotes{
-					SupportVotesBySessionUUID: make(map[string]bool),
-					RejectVotesBySessionUUID:  make(map[string]bool),
-				}
-				room.MessageVotesByMessageId[existingMessage.Id] = messageVotes
-			}
-
-			userSupports, supportRecordFound := messageVotes.SupportVotesBySessionUUID[clSocket.SessionUUID]
-			userRejects, rejectRecordFound := messageVotes.RejectVotesBySessionUUID[clSocket.SessionUUID]
-
-			//if user chosen support - check if user already supports this message. If no - add one to support, else - cancel support
-			if isSupport {
-				if !supportRecordFound || !userSupports {
-					messageVotes.SupportVotesBySessionUUID[clSocket.SessionUUID] = true
-					existingMessage.SupportedCount = existingMessage.SupportedCount + 1
-				} else {
-					//if user already supports this message - cancel support
-					messageVotes.SupportVotesBySessionUUID[clSocket.SessionUUID] = false
-					existingMessage.SupportedCount = existingMessage.SupportedCount - 1
-				}
-
-				if rejectRecordFound && userRejects {
-					messageVotes.RejectVotesBySessionUUID[clSocket.SessionUUID] = false
-					existingMessage.RejectedCount = existingMessage.RejectedCount - 1
-				}
-			} else {
-				//same logic for rejecting
-
-				if !rejectRecordFound || !userRejects {
-					messageVotes.RejectVotesBySessionUUID[clSocket.SessionUUID] = true
-					existingMessage.RejectedCount = existingMessage.RejectedCount + 1
-				} else {
-					//if user already rejects this message - cancel reject
-					messageVotes.RejectVotesBySessionUUID[clSocket.SessionUUID] = false
-					existingMessage.RejectedCount = existingMessage.RejectedCount - 1
-				}
-
-				if supportRecordFound && userSupports {
-					messageVotes.SupportVotesBySessionUUID[clSocket.SessionUUID] = false
-					existingMessage.SupportedCount = existingMessage.SupportedCount - 1
-				}
-			}
-
 			lastVotedAt := time.Now().UnixNano()
-			existingMessage.LastVotedAt = &lastVotedAt
+
+			ActiveRoomStore.ToggleMessageVote(room, existingMessage, clSocket.SessionUUID, isSupport, lastVotedAt)
 
 			messageSupportDispatchingFrame := &domain_structures.OutMessageFrame{
 				Command:       domain_structures.TextMessageSupportOrReject,
@@ -850,6 +1068,8 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				CreatedAtNano: &lastVotedAt,
 			}
 
+			recordRoomEvent(room, messageSupportDispatchingFrame)
+
 			//make copy of active client sockets connected to this room while under lock.
 			//After unlock - initial list may be updated at any point by parallel routines
 			roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
@@ -861,6 +1081,74 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 
 			writeRequestProcessedToSocket(clSocket, inFrame.RequestId)
 
+		case domain_structures.TextMessageHistory:
+			room := ActiveRoomsByNameMap.Get(inFrame.Room.Name)
+
+			if room == nil {
+				util.LogInfo("failed to load history for user '%s' - room '%s' not found", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotFound, inFrame.RequestId)
+
+				continue
+			}
+
+			room.Lock()
+
+			if room.IsDeleted {
+				room.Unlock()
+
+				util.LogInfo("failed to load history for user '%s' - room '%s' was deleted", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotFound, inFrame.RequestId)
+
+				continue
+			}
+
+			_, userFound := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
+
+			if !userFound {
+				room.Unlock()
+
+				util.LogInfo("failed to load history - user '%s' not active for room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomNotAuthorized, inFrame.RequestId)
+
+				continue
+			}
+
+			clientSocketForThisRoom, socketFound := room.ActiveClientSocketsByUUID[clSocket.SocketUUID]
+
+			if !socketFound || clientSocketForThisRoom.IsDead() {
+				room.Unlock()
+
+				util.LogInfo("failed to load history - socket '%s' not active for room '%s'", clSocket.SocketUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsConnectionError, inFrame.RequestId)
+
+				continue
+			}
+
+			historyLimit := inFrame.HistoryLimit
+
+			if historyLimit <= 0 {
+				historyLimit = HistoryPageDefaultLimit
+			} else if historyLimit > HistoryPageMaxLimit {
+				historyLimit = HistoryPageMaxLimit
+			}
+
+			util.LogTrace("user '%s' is loading '%d' history messages before '%d' in room '%s' / '%s'",
+				clSocket.SessionUUID, historyLimit, inFrame.HistoryBeforeId, room.Id, room.Name)
+
+			historyMessagesDTOCopy, hasMoreHistory := copyRoomMessagesPageAsDTOArray(room, inFrame.HistoryBeforeId, historyLimit)
+
+			room.Unlock()
+
+			createdAt := time.Now().UnixNano()
+
+			writeFrameToSocket(clSocket, &domain_structures.OutMessageFrame{
+				Command:        domain_structures.TextMessageHistory,
+				RequestId:      inFrame.RequestId,
+				CreatedAtNano:  &createdAt,
+				Message:        historyMessagesDTOCopy,
+				HasMoreHistory: &hasMoreHistory,
+			})
+
 		case domain_structures.UserDrawingMessage:
 			room := ActiveRoomsByNameMap.Get(inFrame.Room.Name)
 
@@ -884,6 +1172,15 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			if room.IsFrozen {
+				room.Unlock()
+
+				util.LogInfo("failed to send drawing message for user '%s' - room '%s' is being migrated", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomIsMigrating, inFrame.RequestId)
+
+				continue
+			}
+
 			userInRoomUUID, userFound := room.ActiveRoomUserUUIDBySessionUUID[clSocket.SessionUUID]
 
 			if !userFound {
@@ -906,17 +1203,45 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			if !canPostToRoom(room, clSocket.SessionUUID) {
+				room.Unlock()
+
+				util.LogTrace("failed to send drawing message - user '%s' can not post to announcement room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+				writeErrorMessageToSocket(clSocket, domain_structures.WsRoomAnnouncementOnly, inFrame.RequestId)
+
+				continue
+			}
+
+			if !takeMessageRateLimitTokenNonLocking(room, clSocket, inFrame.RequestId) {
+				room.Unlock()
+
+				continue
+			}
+
 			message := inFrame.Message
 
+			filterResult, accepted := filterMessageTextForSocket(room, clSocket, message.Text, inFrame.RequestId)
+
+			if !accepted {
+				room.Unlock()
+
+				util.LogTrace("failed to send drawing message - message of user '%s' was rejected in room '%s'", clSocket.SessionUUID, inFrame.Room.Name)
+
+				continue
+			}
+
 			util.LogTrace("user '%s' is sending drawing message to room '%s' / '%s'", clSocket.SessionUUID, room.Id, room.Name)
 
 			//transform message and add to room messages array
 			newRoomMessage := addNewMessageToRoom(
 				room,
 				userInRoomUUID,
-				message.Text,
+				filterResult.Text,
 				message.ReplyToUserId,
 				message.ReplyToMessageId,
+				message.TtlSec,
+				filterResult.IsFlagged,
+				nil,
 			)
 
 			//check room messages amount. if reached limit - cut messages list in half
@@ -927,6 +1252,8 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 				Message: &[]domain_structures.RoomMessageDTO{copyMessageAsDTO(newRoomMessage)},
 			}
 
+			recordRoomEvent(room, messageDispatchingFrame)
+
 			//make copy of active client sockets connected to this room while under lock.
 			//After unlock - initial list may be updated at any point by parallel routines
 			roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
@@ -946,6 +1273,39 @@ func WsEntry(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func IsDraining() bool {
+	return atomic.LoadInt32(&isDraining) == 1
+}
+
+func SetDraining(draining bool) {
+	if draining {
+		atomic.StoreInt32(&isDraining, 1)
+	} else {
+		atomic.StoreInt32(&isDraining, 0)
+	}
+}
+
+// rejects all room changes from now on, same way as during migration - so rooms saved on shutdown are final.
+// Http server shutdown does not wait for websocket connections, their commands keep coming until process exits
+func FreezeRoomsForShutdown() {
+	//new rooms are refused by draining backend
+	SetDraining(true)
+
+	activeRoomsByName, _ := ActiveRoomsByNameMap.CopyActiveRoomsByNameMap()
+
+	for _, room := range *activeRoomsByName {
+		room.Lock()
+
+		room.IsFrozen = true
+		//unfreeze timer of room being migrated must not bring it back
+		room.FrozenUntil = math.MaxInt64
+
+		room.Unlock()
+	}
+
+	util.LogInfo("'%d' rooms frozen for shutdown", len(*activeRoomsByName))
+}
+
 func SendControlCommandServerStatusChanged(newServerStatus string) {
 	var activeRoomsCopy []*domain_structures.Room
 
@@ -969,7 +1329,7 @@ func SendControlCommandServerStatusChanged(newServerStatus string) {
 	}
 }
 
-func createRoom(roomName string, roomPassword string, createdBySessionUUID string) (*domain_structures.Room, error) {
+func createRoom(roomName string, roomPassword string, createdBySessionUUID string, requestedSettings *domain_structures.RoomSettings) (*domain_structures.Room, error) {
 	nameTrimmed := strings.TrimSpace(roomName)
 	passwordTrimmed := strings.TrimSpace(roomPassword)
 
@@ -993,6 +1353,12 @@ func createRoom(roomName string, roomPassword string, createdBySessionUUID strin
 		}
 	}
 
+	roomSettings, settingsValid := makeNewRoomSettings(requestedSettings)
+
+	if !settingsValid {
+		return nil, RoomSettingsValidationErrorOutOfBounds
+	}
+
 	newRoomUUID, err := uuid.NewUUID()
 
 	if err != nil {
@@ -1028,10 +1394,21 @@ func createRoom(roomName string, roomPassword string, createdBySessionUUID strin
 		RoomMessages:                        make(map[int64]*domain_structures.RoomMessage),
 		RoomMessagesLen:                     0,
 		MessageVotesByMessageId:             make(map[int64]*domain_structures.RoomMessageVotes),
+		WhisperMessages:                     make(map[int64]*domain_structures.RoomMessage),
+		BannedUserInRoomUUIDs:               make(map[string]bool),
+		ModeratorUserInRoomUUIDs:            make(map[string]bool),
+		Settings:                            roomSettings,
+		PendingReadMarkerUserInRoomUUIDs:    make(map[string]bool),
+		TypingUsersByUserInRoomUUID:         make(map[string]*domain_structures.RoomUserTypingState),
+		MessageRateLimitersBySessionUUID:    make(map[string]*domain_structures.TokenBucket),
+		EditRateLimitersBySessionUUID:       make(map[string]*domain_structures.TokenBucket),
+		LastEventSeq:                        initialRoomEventSeq(),
 	}
 
 	addTechnicalUsersToRoom(room)
 
+	ActiveRoomStore.SaveRoom(room)
+
 	return room, nil
 }
 
@@ -1052,6 +1429,22 @@ func logIntoRoom(
 	frame *domain_structures.InMessageFrame,
 	createdRoom bool,
 ) {
+	//room creator gets one-time key that allows to reclaim room ownership if session is lost.
+	//Hashing is slow - it is done before room is locked
+	var ownerRecoveryKey *string = nil
+	var ownerRecoveryKeyHash string
+
+	if createdRoom {
+		newOwnerRecoveryKey, newOwnerRecoveryKeyHash, err := generateOwnerRecoveryKey()
+
+		if err != nil {
+			util.LogSevere("failed to generate owner recovery key for room '%s': '%s'", room.Id, err)
+		} else {
+			ownerRecoveryKey = &newOwnerRecoveryKey
+			ownerRecoveryKeyHash = newOwnerRecoveryKeyHash
+		}
+	}
+
 	room.Lock()
 
 	room.LastActiveAt = time.Now().UnixNano()
@@ -1065,7 +1458,16 @@ func logIntoRoom(
 		return
 	}
 
-	if room.ActiveRoomUsersLen >= RoomMaxUsersLimit {
+	if room.IsFrozen {
+		room.Unlock()
+
+		util.LogInfo("failed to login user '%s' - room '%s' is being migrated", clSocket.SessionUUID, room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomIsMigrating, frame.RequestId)
+
+		return
+	}
+
+	if room.ActiveRoomUsersLen >= room.Settings.MaxUsers {
 		room.Unlock()
 
 		util.LogTrace("room '%s' is full (%s)", room.Name, room.Id)
@@ -1082,20 +1484,42 @@ func logIntoRoom(
 
 	existingAuthorization, alreadyAuthorized := room.AllRoomAuthorizedUsersBySessionUUID[clSocket.SessionUUID]
 
+	if alreadyAuthorized && room.BannedUserInRoomUUIDs[existingAuthorization.UserInRoomUUID] {
+		room.Unlock()
+
+		util.LogInfo("failed to login user '%s' - user is banned in room '%s'", clSocket.SessionUUID, room.Name)
+		writeErrorMessageToSocket(clSocket, domain_structures.WsRoomUserBanned, frame.RequestId)
+
+		return
+	}
+
 	roomHasPassword := room.PasswordHash != ""
 
 	//check password only if room has one and user either haven't authorized yet or already authorized but passed some password again
 	if roomHasPassword && (!alreadyAuthorized || frame.Room.Password != "") {
+		if lockout := getPasswordAttemptLockout(room.Id, clSocket.ClientIp); lockout > 0 {
+			room.Unlock()
+
+			util.LogTrace("password attempts for room '%s' are locked out for client '%s'", room.Id, clSocket.ClientIp)
+			writeErrorWithRetryAfterToSocket(clSocket, domain_structures.WsRoomPasswordAttemptsLocked, lockout, frame.RequestId)
+
+			return
+		}
+
 		err := hasher.CheckHashEquality(room.PasswordHash, frame.Room.Password)
 
 		if err != nil {
 			room.Unlock()
 
+			registerFailedPasswordAttempt(room.Id, clSocket.ClientIp)
+
 			util.LogTrace("incorrect password while joining room '%s': '%s'", room.Id, err)
 			writeErrorMessageToSocket(clSocket, domain_structures.WsRoomInvalidPassword, frame.RequestId)
 
 			return
 		}
+
+		registerSuccessfulPasswordAttempt(room.Id, clSocket.ClientIp)
 	}
 
 	if alreadyAuthorized {
@@ -1169,6 +1593,12 @@ func logIntoRoom(
 	var requestProcessingDetails string
 	if createdRoom {
 		requestProcessingDetails = "room_created"
+
+		if ownerRecoveryKey != nil {
+			room.OwnerRecoveryKeyHash = ownerRecoveryKeyHash
+
+			ActiveRoomStore.SaveRoomInfo(room)
+		}
 	} else {
 		requestProcessingDetails = "room_joined"
 	}
@@ -1183,13 +1613,13 @@ func logIntoRoom(
 
 	/* Put (or re-put) user into room */
 
-	room.AllRoomAuthorizedUsersBySessionUUID[clSocket.SessionUUID] = roomUser
+	ActiveRoomStore.AuthorizeUser(room, clSocket.SessionUUID, roomUser)
 
 	//if this request if from home page - just authorize user, will fully join room later
 	if frame.Command == domain_structures.RoomCreateJoinAuthorize {
 		room.Unlock()
 
-		writeRequestProcessedToSocketWithAdditInfo(clSocket, &room.StartedAt, frame.RequestId, &requestProcessingDetails, &room.Id, &roomUser.UserInRoomUUID, nil)
+		writeRequestProcessedToSocketWithAdditInfo(clSocket, &room.StartedAt, frame.RequestId, &requestProcessingDetails, &room.Id, &roomUser.UserInRoomUUID, nil, ownerRecoveryKey)
 
 		return
 	}
@@ -1198,6 +1628,11 @@ func logIntoRoom(
 
 	room.ActiveRoomUsersLen = len(room.ActiveRoomUserUUIDBySessionUUID)
 
+	//joined user is considered active until his client reports otherwise
+	roomUser.DeclaredPresence = UserPresenceActive
+	roomUser.Presence = UserPresenceActive
+	roomUser.LastSeenAt = time.Now().UnixNano()
+
 	//in case alive socket already exists for user - remove it from room, and later close (after unlocking room)
 	existingSocket := findSocketBySessionUUID(&room.ActiveClientSocketsByUUID, clSocket.SessionUUID)
 
@@ -1206,7 +1641,7 @@ func logIntoRoom(
 
 		//synchronously write error message to socket, then close it. Small timeout since this call is still under room lock
 		writeTimeout := time.Second * 2
-		doWriteErrorMessageToSocket(existingSocket, domain_structures.WsRoomUserDuplication, frame.RequestId, true, &writeTimeout)
+		doWriteErrorMessageToSocket(existingSocket, domain_structures.WsRoomUserDuplication, frame.RequestId, nil, true, &writeTimeout)
 
 		existingSocket.Terminate()
 	}
@@ -1217,8 +1652,51 @@ func logIntoRoom(
 
 	util.LogInfo("user '%s'/'%s' joined room '%s' / '%s'", clSocket.SessionUUID, roomUser.UserInRoomUUID, room.Id, room.Name)
 
-	//copy all room messages
-	allRoomMessagesDTOCopy := copyAllRoomMessagesAsDTOArray(&room.RoomMessages)
+	//re-joining user that knows last event he saw gets only missed events (if they are still in log), others - all room messages
+	var missedEventFramesJson [][]byte = nil
+	deltaSyncPossible := false
+
+	if frame.LastSeenSeq > 0 {
+		var missedEvents []*domain_structures.RoomEvent
+		missedEvents, deltaSyncPossible = findMissedRoomEvents(room, frame.LastSeenSeq, roomUser.UserInRoomUUID)
+
+		//serialize under lock - frames in log are shared and must not be read while room changes
+		missedEventFramesJson = make([][]byte, 0, len(missedEvents))
+
+		for _, missedEvent := range missedEvents {
+			missedEventFrameJson, err := json.Marshal(missedEvent.Frame)
+
+			if err != nil {
+				util.LogSevere("error serializing frame to JSON. Frame: '%s', error: '%s'", missedEvent.Frame, err)
+				deltaSyncPossible = false
+
+				break
+			}
+
+			missedEventFramesJson = append(missedEventFramesJson, missedEventFrameJson)
+		}
+
+		if deltaSyncPossible {
+			requestProcessingDetails += ";sync=delta"
+		} else {
+			missedEventFramesJson = nil
+			requestProcessingDetails += ";sync=full"
+		}
+	}
+
+	//copy room messages (all or only latest ones if user requested limited history, older are loaded by TM_HISTORY requests)
+	var roomMessagesDTOCopy *[]domain_structures.RoomMessageDTO = nil
+	hasMoreHistory := false
+
+	//whispers are sent in full (not paginated), missed ones are replayed with other events on delta sync
+	var userWhisperMessagesFrame *domain_structures.OutMessageFrame = nil
+
+	if !deltaSyncPossible {
+		roomMessagesDTOCopy, hasMoreHistory = copyRoomMessagesPageAsDTOArray(room, 0, frame.HistoryLimit)
+		userWhisperMessagesFrame = makeUserWhisperMessagesFrame(room, roomUser.UserInRoomUUID)
+	}
+
+	lastEventSeq := room.LastEventSeq
 
 	//copy room active users list
 	allRoomUsersCopy := copyAllRoomUsersList(room)
@@ -1234,6 +1712,14 @@ func logIntoRoom(
 	roomDataCopiedAt := time.Now().UnixNano()
 
 	roomDescriptionSafeCopy := room.Description
+	roomSettingsSafeCopy := room.Settings
+	isAnnouncementModeSafeCopy := room.IsAnnouncementMode
+	slowModeIntervalSecSafeCopy := room.SlowModeIntervalSec
+	messageEditWindowSecSafeCopy := room.MessageEditWindowSec
+
+	//pinned messages are sent on every join (even for delta sync) - they may be older than messages page user gets
+	pinnedMessagesFrame := makePinnedMessagesFrame(room)
+	pinnedMessagesFrame.EventSeq = &lastEventSeq
 
 	room.Unlock()
 
@@ -1247,18 +1733,23 @@ func logIntoRoom(
 		CreatedAtNano:      &roomDataCopiedAt,
 		AllRoomUsers:       allRoomUsersCopy,
 		CurrentBuildNumber: &config.BuildVersion,
+		EventSeq:           &lastEventSeq,
 	}
 
-	//send all room messages to user
-	sort.Slice(*allRoomMessagesDTOCopy, func(i, j int) bool {
-		return *((*allRoomMessagesDTOCopy)[i].Id) < *((*allRoomMessagesDTOCopy)[j].Id)
-	})
+	//send room messages (sorted by id) to user
+	var allMessagesFrame *domain_structures.OutMessageFrame = nil
+
+	if !deltaSyncPossible {
+		allMessagesFrame = &domain_structures.OutMessageFrame{
+			Command:            domain_structures.AllTextMessages,
+			Message:            roomMessagesDTOCopy,
+			CreatedAtNano:      &roomDataCopiedAt,
+			CurrentBuildNumber: &config.BuildVersion,
+			HasMoreHistory:     &hasMoreHistory,
+			EventSeq:           &lastEventSeq,
+		}
 
-	allMessagesFrame := domain_structures.OutMessageFrame{
-		Command:            domain_structures.AllTextMessages,
-		Message:            allRoomMessagesDTOCopy,
-		CreatedAtNano:      &roomDataCopiedAt,
-		CurrentBuildNumber: &config.BuildVersion,
+		userWhisperMessagesFrame.EventSeq = &lastEventSeq
 	}
 
 	roomDescriptionFrame := domain_structures.OutMessageFrame{
@@ -1269,10 +1760,15 @@ func logIntoRoom(
 		Message: &[]domain_structures.RoomMessageDTO{
 			{Text: &roomDescriptionSafeCopy},
 		},
+		RoomSettings:         &roomSettingsSafeCopy,
+		IsAnnouncementMode:   &isAnnouncementModeSafeCopy,
+		SlowModeIntervalSec:  &slowModeIntervalSecSafeCopy,
+		MessageEditWindowSec: &messageEditWindowSecSafeCopy,
+		EventSeq:             &lastEventSeq,
 	}
 
-	if err := writeAfterRoomJoinMessagesToSocket(&roomMembersListChangedFrame, &allMessagesFrame, &roomDescriptionFrame, clSocket); err == nil {
-		writeRequestProcessedToSocketWithAdditInfo(clSocket, &room.StartedAt, frame.RequestId, &requestProcessingDetails, &room.Id, &roomUser.UserInRoomUUID, &config.BuildVersion)
+	if err := writeAfterRoomJoinMessagesToSocket(&roomMembersListChangedFrame, allMessagesFrame, userWhisperMessagesFrame, &roomDescriptionFrame, pinnedMessagesFrame, missedEventFramesJson, clSocket); err == nil {
+		writeRequestProcessedToSocketWithAdditInfo(clSocket, &room.StartedAt, frame.RequestId, &requestProcessingDetails, &room.Id, &roomUser.UserInRoomUUID, &config.BuildVersion, ownerRecoveryKey)
 	} else {
 		writeErrorMessageToSocket(clSocket, domain_structures.WsServerError, frame.RequestId)
 	}
@@ -1285,23 +1781,14 @@ func addNewMessageToRoom(
 	messageText string,
 	replyToUserId *string,
 	replyToMessageId *int64,
-
+	ttlSec int64,
+	isFlagged bool,
+	mentionedUserInRoomUUIDs []string,
 ) *domain_structures.RoomMessage {
-	newRoomMessage := &domain_structures.RoomMessage{
-		Id:               room.NextMessageId,
-		Text:             messageText,
-		SupportedCount:   0,
-		RejectedCount:    0,
-		UserInRoomUUID:   userInRoomUUID,
-		CreatedAtSec:     time.Now().Unix(),
-		ReplyToUserId:    replyToUserId,
-		ReplyToMessageId: replyToMessageId,
-	}
+	newRoomMessage := ActiveRoomStore.AddMessage(room, userInRoomUUID, messageText, replyToUserId, replyToMessageId, ttlSec, isFlagged,
+		mentionedUserInRoomUUIDs)
 
-	room.NextMessageId += 1
-
-	room.RoomMessages[newRoomMessage.Id] = newRoomMessage
-	room.RoomMessagesLen = len(room.RoomMessages)
+	scheduleMessageExpiryNonLocking(room, newRoomMessage)
 
 	return newRoomMessage
 }
@@ -1319,16 +1806,16 @@ func scheduleSendingNewMessageToActiveUsers(
 	if lowestMessageIdAfterShrink != int64(-1) {
 		lowestMessageIdAfterShrinkStr := strconv.FormatInt(lowestMessageIdAfterShrink, 10)
 
-		writeNotificationToActiveRoomMembers(domain_structures.NotifyMessagesLimitReached, room, roomActiveClientSocketsByUUID, &lowestMessageIdAfterShrinkStr)
+		writeNotificationToActiveRoomMembers(domain_structures.NotifyMessagesLimitReached, room, roomActiveClientSocketsByUUID, &lowestMessageIdAfterShrinkStr, true)
 
-	} else if util.ArrayContainsInt(RoomMessagesLimitApproachingWarningBreakpoints, room.RoomMessagesLen) {
-		writeNotificationToActiveRoomMembers(domain_structures.NotifyMessagesLimitApproaching, room, roomActiveClientSocketsByUUID, nil)
+	} else if isRoomMessagesLimitApproaching(room) {
+		writeNotificationToActiveRoomMembers(domain_structures.NotifyMessagesLimitApproaching, room, roomActiveClientSocketsByUUID, nil, false)
 	}
 }
 
 // must be executed under room lock
 func checkLimitAndShrinkMessagesList(room *domain_structures.Room) int64 {
-	if room.RoomMessagesLen >= RoomMessagesLimit {
+	if room.RoomMessagesLen >= room.Settings.MessagesLimit {
 		return shrinkRoomMessagesMap(room)
 	} else {
 		return -1
@@ -1337,7 +1824,7 @@ func checkLimitAndShrinkMessagesList(room *domain_structures.Room) int64 {
 
 // side method for room messages retrieval - used for direct http requests
 func RetrieveRoomMessagesDirectly(roomName string, roomPassword string, messagesLimit int,
-	targetMessageId int64, responseFormat string, quiteMode bool) []byte {
+	targetMessageId int64, responseFormat string, quiteMode bool, sessionUUID string, clientIp string) []byte {
 	room := ActiveRoomsByNameMap.Get(roomName)
 	newRoomCreated := false
 
@@ -1366,21 +1853,21 @@ func RetrieveRoomMessagesDirectly(roomName string, roomPassword string, messages
 		return util.BuildDirectRoomMessagesErrorResponse("error: internal error", responseFormat)
 	}
 
-	roomHasPassword := room.PasswordHash != ""
-
-	if roomHasPassword {
-		err := hasher.CheckHashEquality(room.PasswordHash, roomPassword)
-
-		if err != nil {
-			room.Unlock()
+	if errorResponse := checkDirectRoomPasswordNonLocking(room, roomPassword, clientIp, responseFormat); errorResponse != nil {
+		room.Unlock()
 
-			return util.BuildDirectRoomMessagesErrorResponse(
-				"error: wrong room password (use URL param 'p=myPassword')", responseFormat)
-		}
+		return errorResponse
 	}
 
 	allRoomMessagesDTOCopy := copyAllRoomMessagesAsDTOArray(&room.RoomMessages)
 
+	//whispers are added only for room user that requests messages with his own session (e.g. from browser)
+	roomUser, isRoomUser := room.AllRoomAuthorizedUsersBySessionUUID[sessionUUID]
+
+	if sessionUUID != "" && isRoomUser && !room.BannedUserInRoomUUIDs[roomUser.UserInRoomUUID] {
+		*allRoomMessagesDTOCopy = append(*allRoomMessagesDTOCopy, copyUserWhisperMessagesAsDTOArray(room, roomUser.UserInRoomUUID)...)
+	}
+
 	allRoomUsersCopy := copyAllRoomUsersList(room)
 	userNameByUserInRoomUUID := make(map[string]string)
 
@@ -1395,7 +1882,7 @@ func RetrieveRoomMessagesDirectly(roomName string, roomPassword string, messages
 	})
 
 	messagesToReturn := allRoomMessagesDTOCopy
-	messagesToReturnLen := room.RoomMessagesLen
+	messagesToReturnLen := len(*allRoomMessagesDTOCopy)
 
 	//if user requested messages starting from particular id - return only those
 	if messagesToReturnLen > 0 && targetMessageId > 0 {
@@ -1428,7 +1915,7 @@ func RetrieveRoomMessagesDirectly(roomName string, roomPassword string, messages
 		responseJsonStr := map[string]interface{}{
 			"createdNewRoom":         newRoomCreated,
 			"messagesCount":          messagesToReturnLen,
-			"totalRoomMessagesCount": room.RoomMessagesLen,
+			"totalRoomMessagesCount": len(*allRoomMessagesDTOCopy),
 		}
 
 		messagesArray := make([]map[string]interface{}, messagesToReturnLen)
@@ -1453,9 +1940,29 @@ func RetrieveRoomMessagesDirectly(roomName string, roomPassword string, messages
 			}
 
 			messagesArray[i] = map[string]interface{}{
-				"id":       messageId,
-				"text":     unescapedMessageText,
-				"userName": userName,
+				"id":        messageId,
+				"text":      unescapedMessageText,
+				"userName":  userName,
+				"reactions": message.Reactions,
+			}
+
+			if message.RecipientUserInRoomUUID != nil {
+				messagesArray[i]["whisperTo"] = unescapeDirectMessageUserName(userNameByUserInRoomUUID, *message.RecipientUserInRoomUUID)
+			}
+
+			//mentioned users are shown by their current names
+			if message.MentionedUserInRoomUUIDs != nil {
+				mentions := make([]string, len(*message.MentionedUserInRoomUUIDs))
+
+				for j, mentionedUserInRoomUUID := range *message.MentionedUserInRoomUUIDs {
+					mentions[j] = unescapeDirectMessageUserName(userNameByUserInRoomUUID, mentionedUserInRoomUUID)
+				}
+
+				messagesArray[i]["mentions"] = mentions
+			}
+
+			if message.RevisionsCount != nil {
+				messagesArray[i]["revisionsCount"] = *message.RevisionsCount
 			}
 		}
 
@@ -1485,7 +1992,7 @@ func RetrieveRoomMessagesDirectly(roomName string, roomPassword string, messages
 		}
 
 		sb.WriteString(fmt.Sprintf("Showing %d of %d universally accessible chat room messages below this line\n\n",
-			messagesToReturnLen, room.RoomMessagesLen))
+			messagesToReturnLen, len(*allRoomMessagesDTOCopy)))
 
 		if newRoomCreated {
 			sb.WriteString("system: you have just created this room\n")
@@ -1510,6 +2017,14 @@ func RetrieveRoomMessagesDirectly(roomName string, roomPassword string, messages
 				userName = "unknown"
 			}
 
+			if message.RecipientUserInRoomUUID != nil {
+				userName = fmt.Sprintf("%s (whisper to %s)", userName, unescapeDirectMessageUserName(userNameByUserInRoomUUID, *message.RecipientUserInRoomUUID))
+			}
+
+			if message.RevisionsCount != nil {
+				unescapedMessageText = fmt.Sprintf("%s (edits: %d)", unescapedMessageText, *message.RevisionsCount)
+			}
+
 			sb.WriteString(fmt.Sprintf("#%d %s: %s\n", messageId, userName, unescapedMessageText))
 		}
 
@@ -1519,8 +2034,32 @@ func RetrieveRoomMessagesDirectly(roomName string, roomPassword string, messages
 	}
 }
 
+func unescapeDirectMessageUserName(userNameByUserInRoomUUID map[string]string, userInRoomUUID string) string {
+	userName, found := userNameByUserInRoomUUID[userInRoomUUID]
+
+	if !found {
+		return "unknown"
+	}
+
+	userName, err := url.QueryUnescape(userName)
+
+	if err != nil {
+		return "unknown"
+	}
+
+	return userName
+}
+
 // side method for sending room message - used for direct http requests
-func SendRoomMessageDirectly(roomName string, roomPassword string, message string, responseFormat string) []byte {
+func SendRoomMessageDirectly(roomName string, roomPassword string, message string, responseFormat string, clientIp string) []byte {
+	//checked before room lookup - so flood can not create rooms either
+	if allowed, retryAfter := takeDirectSendingRateLimitToken(clientIp); !allowed {
+		util.LogInfo("failed to send direct message - client '%s' is rate limited for '%s'", clientIp, retryAfter)
+
+		return util.BuildDirectRoomMessagesErrorResponse(
+			fmt.Sprintf("error: too many messages, retry in %d seconds", int(retryAfter.Seconds())+1), responseFormat)
+	}
+
 	room := ActiveRoomsByNameMap.Get(roomName)
 	newRoomCreated := false
 
@@ -1538,17 +2077,10 @@ func SendRoomMessageDirectly(roomName string, roomPassword string, message strin
 		newRoomCreated = true
 	}
 
-	roomHasPassword := room.PasswordHash != ""
-
-	if roomHasPassword {
-		err := hasher.CheckHashEquality(room.PasswordHash, roomPassword)
-
-		if err != nil {
-			util.LogInfo("failed to send direct message - wrong password for room '%s'", room.Name)
+	if errorResponse := checkDirectRoomPassword(room.Id, room.PasswordHash, roomPassword, clientIp, responseFormat); errorResponse != nil {
+		util.LogInfo("failed to send direct message - wrong password or locked out attempts for room '%s', client '%s'", room.Name, clientIp)
 
-			return util.BuildDirectRoomMessagesErrorResponse(
-				"error: wrong room password (use HTTP param &p=myPassword)", responseFormat)
-		}
+		return errorResponse
 	}
 
 	room.Lock()
@@ -1563,28 +2095,64 @@ func SendRoomMessageDirectly(roomName string, roomPassword string, message strin
 		return util.BuildDirectRoomMessagesErrorResponse("error: room is deleted", responseFormat)
 	}
 
-	util.LogTrace("sending direct message of len '%d' to room '%s' / '%s'", len(message), room.Id, room.Name)
+	if room.IsFrozen {
+		room.Unlock()
+
+		util.LogInfo("failed to send direct message - room '%s' is being migrated", room.Name)
+
+		return util.BuildDirectRoomMessagesErrorResponse("error: room is being moved to another server, please retry shortly", responseFormat)
+	}
+
+	if !canPostToRoom(room, ExternalUserSessionUUID) {
+		room.Unlock()
+
+		util.LogInfo("failed to send direct message - room '%s' is in announcement mode", room.Name)
+
+		return util.BuildDirectRoomMessagesErrorResponse("error: only room owner and moderators can post to this room", responseFormat)
+	}
+
+	filterResult := filterMessageText(room, message)
+
+	if filterResult.RejectError != nil {
+		room.Unlock()
+
+		util.LogInfo("failed to send direct message - message was rejected in room '%s': %s", room.Name, filterResult.RejectReason)
+
+		return util.BuildDirectRoomMessagesErrorResponse(fmt.Sprintf("error: %s", filterResult.RejectReason), responseFormat)
+	}
+
+	util.LogTrace("sending direct message of len '%d' to room '%s' / '%s'", len(filterResult.Text), room.Id, room.Name)
+
+	mentionedUserInRoomUUIDs := resolveMessageMentionsNonLocking(room, filterResult.Text, ExternalUserUUID)
 
 	//transform message and add to room messages array
 	newRoomMessage := addNewMessageToRoom(
 		room,
 		ExternalUserUUID,
-		message,
+		filterResult.Text,
 		nil,
 		nil,
+		0,
+		filterResult.IsFlagged,
+		mentionedUserInRoomUUIDs,
 	)
 
 	//check room messages amount. if reached limit - cut messages list in half
 	lowestMessageIdAfterShrink := checkLimitAndShrinkMessagesList(room)
 
+	newRoomMessageDTO := copyMessageAsDTO(newRoomMessage)
+
 	messageDispatchingFrame := &domain_structures.OutMessageFrame{
 		Command: domain_structures.TextMessage,
-		Message: &[]domain_structures.RoomMessageDTO{copyMessageAsDTO(newRoomMessage)},
+		Message: &[]domain_structures.RoomMessageDTO{newRoomMessageDTO},
 	}
 
+	recordRoomEvent(room, messageDispatchingFrame)
+
 	//make copy of active client sockets connected to this room while under lock.
 	//After unlock - initial list may be updated at any point by parallel routines
 	roomActiveClientSocketsByUUID := room.CopyActiveClientSocketMapNonLocking()
+	mentionedUsersSocketsByUUID := copyMentionedUsersSocketMapNonLocking(room, mentionedUserInRoomUUIDs)
 
 	room.Unlock()
 
@@ -1596,6 +2164,8 @@ func SendRoomMessageDirectly(roomName string, roomPassword string, message strin
 		lowestMessageIdAfterShrink,
 	)
 
+	writeMentionNotificationToSockets(room, newRoomMessageDTO, mentionedUsersSocketsByUUID)
+
 	if responseFormat == "json" {
 		responseJsonStr := map[string]interface{}{
 			"responseText":   "message sent",
@@ -1629,7 +2199,14 @@ func createRoomByDirectMessageFlow(roomName string, roomPassword string, created
 		return ""
 	}
 
-	newRoom, err := createRoom(roomName, roomPassword, createdBySessionUUID)
+	if IsDraining() {
+		ActiveRoomsByNameMap.Unlock()
+
+		util.LogInfo("refused to create room '%s' for direct message flow - backend is draining", roomName)
+		return "error: server is not accepting new rooms at the moment"
+	}
+
+	newRoom, err := createRoom(roomName, roomPassword, createdBySessionUUID, nil)
 
 	if err != nil {
 		ActiveRoomsByNameMap.Unlock()
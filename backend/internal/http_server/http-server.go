@@ -5,6 +5,8 @@ import (
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math/rand"
@@ -46,10 +48,27 @@ const DirectMessagesLimitParam = "l"
 const DirectMessagesIdParam = "id"
 const DirectMessagesQuiteModeParam = "quite"
 const DirectMessagesResponseFormatParam = "format"
+const DirectSearchTextParam = "q"
+const DirectSearchUserNameParam = "u"
+const DirectSearchFromParam = "from"
+const DirectSearchToParam = "to"
+const DirectSearchBeforeIdParam = "before"
 const CtrlCommandURLParam = "ctrlCommand"
+const TargetBackendURLParam = "targetBackend"
 
 const CtrlCommandNotifyShutdown = "notify_shutdown"
 const CtrlCommandNotifyRestart = "notify_restart"
+const CtrlCommandDrain = "drain"
+const CtrlCommandUndrain = "undrain"
+
+const RoomMigrationCommandExport = "room_export"
+const RoomMigrationCommandImport = "room_import"
+const RoomMigrationCommandMigrated = "room_migrated"
+const RoomMigrationCommandRelease = "room_release"
+const RoomMigrationCommandUnfreeze = "room_unfreeze"
+const RoomTranscriptImportCommand = "room_transcript_import"
+
+const RoomImportMaxBodyBytes = 64 << 20
 
 var BackendInstanceNum string
 
@@ -71,12 +90,26 @@ var CtrlAuthPasswd = "password132"
 var Domain = "n/a"
 var HttpSchema = "n/a"
 
+var RoomStoreType = engine.RoomStoreTypeMemory
+var RoomStoreBoltFilePath = ""
+
+var RoomSnapshotEnabled = false
+
 type CtrlCommandResponse struct {
 	Command      string `json:"command"`
 	Result       string `json:"result"`
 	ErrorMessage string `json:"errorMessage"`
 }
 
+// owner recovery key lets person who imported room reclaim its ownership (imported room has no owner online)
+type RoomTranscriptImportResponse struct {
+	CtrlCommandResponse
+	RoomName         string `json:"roomName,omitempty"`
+	MessagesCount    int    `json:"messagesCount"`
+	AuthorsCount     int    `json:"authorsCount"`
+	OwnerRecoveryKey string `json:"ownerRecoveryKey,omitempty"`
+}
+
 func StartServer() {
 	rand.Seed(time.Now().UnixNano())
 
@@ -136,10 +169,18 @@ func StartServer() {
 	router.HandleFunc("/ctrl", middleware(ctrlHandler, basicAuthWrapper, loggingWrapper))
 	router.HandleFunc("/ctrl_rooms", middleware(roomsCtrlHandler, basicAuthWrapper))
 	router.HandleFunc("/ctrl_command", middleware(ctrlCommandHandler, basicAuthWrapper))
+	router.HandleFunc("/ctrl_room_export", middleware(roomExportCtrlHandler, basicAuthWrapper, loggingWrapper)).Methods(http.MethodPost)
+	router.HandleFunc("/ctrl_room_import", middleware(roomImportCtrlHandler, basicAuthWrapper, loggingWrapper)).Methods(http.MethodPost)
+	router.HandleFunc("/ctrl_room_migrated", middleware(roomMigratedCtrlHandler, basicAuthWrapper, loggingWrapper)).Methods(http.MethodPost)
+	router.HandleFunc("/ctrl_room_release", middleware(roomReleaseCtrlHandler, basicAuthWrapper, loggingWrapper)).Methods(http.MethodPost)
+	router.HandleFunc("/ctrl_room_transcript_import", middleware(roomTranscriptImportCtrlHandler, basicAuthWrapper, loggingWrapper)).Methods(http.MethodPost)
+	router.HandleFunc("/ctrl_room_unfreeze", middleware(roomUnfreezeCtrlHandler, basicAuthWrapper, loggingWrapper)).Methods(http.MethodPost)
 
 	router.HandleFunc("/ws_entry", middleware(websocketHandler, loggingWrapper))
 	router.HandleFunc("/direct_sending", middleware(directlySendRoomMessageHandler, loggingWrapper))
 	router.HandleFunc("/direct_retrieval", middleware(directlyRetrieveRoomMessagesHandler, loggingWrapper))
+	router.HandleFunc("/direct_search", middleware(directlySearchRoomMessagesHandler, loggingWrapper))
+	router.HandleFunc("/direct_export", middleware(directlyExportRoomTranscriptHandler, loggingWrapper))
 	router.HandleFunc("/hw", middleware(hwStatusHandler, loggingWrapper))
 
 	cert, err := tls.LoadX509KeyPair("/etc/ssl/ssl-bundle.crt", "/etc/ssl/cert.key")
@@ -171,6 +212,24 @@ func StartServer() {
 
 	startMeasuringHardwareStatus()
 
+	// Open room store and restore rooms it kept from previous run
+	if err := engine.InitRoomStore(RoomStoreType, RoomStoreBoltFilePath); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := engine.RestoreRoomsFromStore(); err != nil {
+		util.LogSevere("Failed to restore rooms from room store: '%s'", err)
+	}
+
+	// Restore rooms saved by previous run and start saving them periodically
+	if RoomSnapshotEnabled {
+		if err := engine.RestoreRoomsFromSnapshot(); err != nil {
+			util.LogSevere("Failed to restore rooms from snapshot: '%s'", err)
+		}
+
+		engine.StartRoomSnapshotTimer()
+	}
+
 	// Graceful Shutdown
 	waitForShutdown(srv)
 }
@@ -211,13 +270,105 @@ func directlyRetrieveRoomMessagesHandler(w http.ResponseWriter, r *http.Request)
 
 		quiteMode := util.GetUnescapedRequestParamValueUnsafe(r, DirectMessagesQuiteModeParam) == "true"
 
+		//session is optional here - it only gives access to user's whispers
+		var session util.HttpSession
+		sessionUUID := ""
+
+		if err := util.GetUserSession(r, &session); err == nil {
+			sessionUUID = session.SessionUUID
+		}
+
 		responseTextBytes = engine.RetrieveRoomMessagesDirectly(
-			roomName, roomPassword, messagesLimit, int64(messageId), responseFormat, quiteMode)
+			roomName, roomPassword, messagesLimit, int64(messageId), responseFormat, quiteMode, sessionUUID, util.GetClientIp(r))
 	}
 
 	writeDirectMessagesResponse(w, responseTextBytes, "directly retrieve messages", responseFormat)
 }
 
+func directlySearchRoomMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	var responseTextBytes []byte
+
+	responseFormat := util.GetUnescapedRequestParamValueUnsafe(r, DirectMessagesResponseFormatParam)
+
+	roomName := util.GetUnescapedRequestParamValueUnsafe(r, RoomNameURLParam)
+	roomName = strings.ToLower(roomName)
+
+	if roomName == "" {
+		responseTextBytes = util.BuildDirectRoomMessagesErrorResponse("error: bad room name", responseFormat)
+
+	} else {
+		roomPassword := util.GetUnescapedRequestParamValueUnsafe(r, DirectMessagesRoomPasswordURLParam)
+
+		searchQuery := domain_structures.MessageSearchQuery{
+			Text:     util.GetUnescapedRequestParamValueUnsafe(r, DirectSearchTextParam),
+			UserName: util.GetUnescapedRequestParamValueUnsafe(r, DirectSearchUserNameParam),
+		}
+
+		searchQuery.FromSec, _ = strconv.ParseInt(util.GetUnescapedRequestParamValueUnsafe(r, DirectSearchFromParam), 10, 64)
+		searchQuery.ToSec, _ = strconv.ParseInt(util.GetUnescapedRequestParamValueUnsafe(r, DirectSearchToParam), 10, 64)
+
+		searchLimit, err := strconv.Atoi(util.GetUnescapedRequestParamValueUnsafe(r, DirectMessagesLimitParam))
+		if err != nil {
+			searchLimit = 0
+		}
+
+		beforeMessageId, err := strconv.ParseInt(util.GetUnescapedRequestParamValueUnsafe(r, DirectSearchBeforeIdParam), 10, 64)
+		if err != nil {
+			beforeMessageId = 0
+		}
+
+		quiteMode := util.GetUnescapedRequestParamValueUnsafe(r, DirectMessagesQuiteModeParam) == "true"
+
+		responseTextBytes = engine.SearchRoomMessagesDirectly(
+			roomName, roomPassword, searchQuery, searchLimit, beforeMessageId, responseFormat, quiteMode, util.GetClientIp(r))
+	}
+
+	writeDirectMessagesResponse(w, responseTextBytes, "directly search messages", responseFormat)
+}
+
+func directlyExportRoomTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	transcriptFormat := util.GetUnescapedRequestParamValueUnsafe(r, DirectMessagesResponseFormatParam)
+
+	if transcriptFormat == "" {
+		transcriptFormat = engine.TranscriptFormatMarkdown
+	}
+
+	roomName := util.GetUnescapedRequestParamValueUnsafe(r, RoomNameURLParam)
+	roomName = strings.ToLower(roomName)
+
+	if roomName == "" {
+		writeDirectMessagesResponse(w, util.BuildDirectRoomMessagesErrorResponse("error: bad room name", ""), "directly export transcript", "")
+
+		return
+	}
+
+	if !engine.IsValidTranscriptFormat(transcriptFormat) {
+		writeDirectMessagesResponse(w, util.BuildDirectRoomMessagesErrorResponse(
+			"error: unknown transcript format (use URL param 'format=json', 'format=md' or 'format=html')", ""), "directly export transcript", "")
+
+		return
+	}
+
+	roomPassword := util.GetUnescapedRequestParamValueUnsafe(r, DirectMessagesRoomPasswordURLParam)
+
+	transcript, errorResponse := engine.PrepareRoomTranscriptDirectly(roomName, roomPassword, transcriptFormat, util.GetClientIp(r))
+
+	if errorResponse != nil {
+		writeDirectMessagesResponse(w, errorResponse, "directly export transcript", transcriptFormat)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", engine.GetTranscriptContentType(transcriptFormat))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", engine.GetTranscriptFileName(transcript, transcriptFormat)))
+
+	err := engine.WriteRoomTranscript(w, transcript, transcriptFormat)
+
+	if err != nil {
+		util.LogWarn("Failed to write response for 'directly export transcript' request. err: '%s'", err)
+	}
+}
+
 func directlySendRoomMessageHandler(w http.ResponseWriter, r *http.Request) {
 	var responseTextBytes []byte
 
@@ -242,7 +393,7 @@ func directlySendRoomMessageHandler(w http.ResponseWriter, r *http.Request) {
 			responseTextBytes = util.BuildDirectRoomMessagesErrorResponse("error: message is too long", responseFormat)
 
 		} else {
-			responseTextBytes = engine.SendRoomMessageDirectly(roomName, roomPassword, messageText, responseFormat)
+			responseTextBytes = engine.SendRoomMessageDirectly(roomName, roomPassword, messageText, responseFormat, util.GetClientIp(r))
 		}
 	}
 
@@ -278,6 +429,7 @@ func hwStatusHandler(w http.ResponseWriter, r *http.Request) {
 		lastRamUsagePerc,
 		metrics.UsersOnline,
 		requestedRoomFound,
+		engine.IsDraining(),
 	})
 
 	if err != nil {
@@ -325,6 +477,8 @@ func roomsCtrlHandler(w http.ResponseWriter, r *http.Request) {
 		room.ActiveRoomUsersLen = 0
 		room.IsDeleted = true
 
+		engine.ActiveRoomStore.DeleteRoom(room)
+
 		room.Unlock()
 
 		engine.ActiveRoomsByNameMap.Delete(room.Name)
@@ -342,11 +496,21 @@ func roomsCtrlHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		failedPasswordAttempts, passwordLockedUntil := engine.GetRoomPasswordFailedAttempts(room.Id)
+		passwordLockedUntilStr := ""
+
+		if passwordLockedUntil > time.Now().UnixNano() {
+			passwordLockedUntilStr = time.Unix(0, passwordLockedUntil).String()
+		}
+
 		activeRooms = append(activeRooms, domain_structures.RoomCtrlInfo{
-			Id:                 room.Id,
-			Name:               room.Name,
-			StartedAt:          time.Unix(0, room.StartedAt).String(),
-			ActiveRoomUsersNum: room.ActiveRoomUsersLen,
+			Id:                     room.Id,
+			Name:                   room.Name,
+			StartedAt:              time.Unix(0, room.StartedAt).String(),
+			ActiveRoomUsersNum:     room.ActiveRoomUsersLen,
+			IsFrozen:               room.IsFrozen,
+			FailedPasswordAttempts: failedPasswordAttempts,
+			PasswordLockedUntil:    passwordLockedUntilStr,
 		})
 	}
 
@@ -382,6 +546,12 @@ func roomsCtrlHandler(w http.ResponseWriter, r *http.Request) {
 			return activeRooms[i].ActiveRoomUsersNum < activeRooms[j].ActiveRoomUsersNum
 		}
 		break
+
+	case "by_failed_password_attempts":
+		roomsOrderFunc = func(i int, j int) bool {
+			return activeRooms[i].FailedPasswordAttempts > activeRooms[j].FailedPasswordAttempts
+		}
+		break
 	}
 
 	sort.SliceStable(activeRooms, roomsOrderFunc)
@@ -408,6 +578,7 @@ func renderCtrlPage(w http.ResponseWriter, r *http.Request, errorStr string) {
 		//reading prometheus metrics is expensive but for admin page its ok
 		"activeRooms": testutil.ToFloat64(engine.RoomsOnlineGauge),
 		"activeUsers": testutil.ToFloat64(engine.UsersOnlineGauge),
+		"isDraining":  engine.IsDraining(),
 	}
 
 	err := templates.CompiledTemplates.ExecuteTemplate(w, "tpl-ctrl.html", vars)
@@ -418,8 +589,15 @@ func renderCtrlPage(w http.ResponseWriter, r *http.Request, errorStr string) {
 }
 
 func renderRoomsCtrlPage(w http.ResponseWriter, activeRooms *[]domain_structures.RoomCtrlInfo) {
+	lockedOutClientIps := make(map[string]string)
+
+	for clientIp, lockedUntil := range engine.GetLockedOutClientIps() {
+		lockedOutClientIps[clientIp] = time.Unix(0, lockedUntil).String()
+	}
+
 	vars := map[string]interface{}{
-		"activeRooms": *activeRooms,
+		"activeRooms":        *activeRooms,
+		"lockedOutClientIps": lockedOutClientIps,
 	}
 
 	err := templates.CompiledTemplates.ExecuteTemplate(w, "tpl-rooms-ctrl.html", vars)
@@ -448,6 +626,16 @@ func ctrlCommandHandler(w http.ResponseWriter, r *http.Request) {
 			engine.SendControlCommandServerStatusChanged(util.ServerStatusRestarting)
 			result = "ok"
 			break
+		case CtrlCommandDrain:
+			util.LogInfo("Backend is draining - new rooms wont be created")
+			engine.SetDraining(true)
+			result = "ok"
+			break
+		case CtrlCommandUndrain:
+			util.LogInfo("Backend stopped draining")
+			engine.SetDraining(false)
+			result = "ok"
+			break
 		default:
 			errorMessage = "command_not_found"
 		}
@@ -477,6 +665,164 @@ func ctrlCommandHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/* room migration handlers (called by aux-srv) */
+
+// freezes room and returns its full state
+func roomExportCtrlHandler(w http.ResponseWriter, r *http.Request) {
+	roomName := util.GetUnescapedRequestParamValueUnsafe(r, RoomNameURLParam)
+
+	snapshotJson, err := engine.ExportRoomForMigration(roomName)
+
+	if err != nil {
+		writeRoomMigrationResponse(w, RoomMigrationCommandExport, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(snapshotJson)
+
+	if err != nil {
+		util.LogSevere("Failed to write response for 'room export' request. err: '%s'", err)
+	}
+}
+
+func roomImportCtrlHandler(w http.ResponseWriter, r *http.Request) {
+	snapshotJson, err := io.ReadAll(http.MaxBytesReader(w, r.Body, RoomImportMaxBodyBytes))
+
+	if err == nil {
+		err = engine.ImportMigratedRoom(snapshotJson)
+	}
+
+	writeRoomMigrationResponse(w, RoomMigrationCommandImport, err)
+}
+
+func roomMigratedCtrlHandler(w http.ResponseWriter, r *http.Request) {
+	roomName := util.GetUnescapedRequestParamValueUnsafe(r, RoomNameURLParam)
+
+	writeRoomMigrationResponse(w, RoomMigrationCommandMigrated, engine.MarkRoomMigrated(roomName))
+}
+
+func roomReleaseCtrlHandler(w http.ResponseWriter, r *http.Request) {
+	roomName := util.GetUnescapedRequestParamValueUnsafe(r, RoomNameURLParam)
+	targetBackend := util.GetUnescapedRequestParamValueUnsafe(r, TargetBackendURLParam)
+
+	var err error
+
+	if targetBackend == "" {
+		err = errors.New("target backend is not set")
+	} else {
+		err = engine.ReleaseMigratedRoom(roomName, targetBackend)
+	}
+
+	writeRoomMigrationResponse(w, RoomMigrationCommandRelease, err)
+}
+
+func roomUnfreezeCtrlHandler(w http.ResponseWriter, r *http.Request) {
+	roomName := util.GetUnescapedRequestParamValueUnsafe(r, RoomNameURLParam)
+
+	writeRoomMigrationResponse(w, RoomMigrationCommandUnfreeze, engine.UnfreezeRoom(roomName))
+}
+
+func writeRoomMigrationResponse(w http.ResponseWriter, command string, err error) {
+	response := CtrlCommandResponse{
+		Command: command,
+		Result:  "ok",
+	}
+
+	status := http.StatusOK
+
+	if err != nil {
+		util.LogWarn("room migration command '%s' failed: '%s'", command, err)
+
+		response.Result = ""
+		response.ErrorMessage = err.Error()
+
+		status = getRoomMigrationErrorStatus(err)
+	}
+
+	jsonData, err := json.Marshal(response)
+
+	if err != nil {
+		util.LogSevere("Failed to serialize structure for 'room migration' request. err: '%s'", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_, err = w.Write(jsonData)
+
+	if err != nil {
+		util.LogSevere("Failed to write response for 'room migration' request. err: '%s'", err)
+	}
+}
+
+func getRoomMigrationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, engine.MigrationRoomNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, engine.MigrationRoomAlreadyExists), errors.Is(err, engine.MigrationRoomNotFrozen),
+		errors.Is(err, engine.MigrationBackendDraining), errors.Is(err, engine.MigrationRoomAlreadyMigrated):
+		return http.StatusConflict
+	}
+
+	return http.StatusBadRequest
+}
+
+// imported room is handed to its future owner with owner recovery key from response
+func roomTranscriptImportCtrlHandler(w http.ResponseWriter, r *http.Request) {
+	roomName := strings.ToLower(util.GetUnescapedRequestParamValueUnsafe(r, RoomNameURLParam))
+	roomPassword := util.GetUnescapedRequestParamValueUnsafe(r, DirectMessagesRoomPasswordURLParam)
+
+	response := RoomTranscriptImportResponse{
+		CtrlCommandResponse: CtrlCommandResponse{
+			Command: RoomTranscriptImportCommand,
+			Result:  "ok",
+		},
+	}
+
+	status := http.StatusOK
+
+	transcriptJson, err := io.ReadAll(http.MaxBytesReader(w, r.Body, RoomImportMaxBodyBytes))
+
+	var importResult *engine.TranscriptImportResult
+
+	if err == nil {
+		importResult, err = engine.ImportRoomFromTranscript(roomName, roomPassword, transcriptJson)
+	}
+
+	if err != nil {
+		util.LogWarn("room transcript import failed: '%s'", err)
+
+		response.Result = ""
+		response.ErrorMessage = err.Error()
+
+		status = getRoomMigrationErrorStatus(err)
+	} else {
+		response.RoomName = importResult.RoomName
+		response.MessagesCount = importResult.MessagesCount
+		response.AuthorsCount = importResult.AuthorsCount
+		response.OwnerRecoveryKey = importResult.OwnerRecoveryKey
+	}
+
+	jsonData, err := json.Marshal(response)
+
+	if err != nil {
+		util.LogSevere("Failed to serialize structure for 'room transcript import' request. err: '%s'", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_, err = w.Write(jsonData)
+
+	if err != nil {
+		util.LogSevere("Failed to write response for 'room transcript import' request. err: '%s'", err)
+	}
+}
+
 /* middleware */
 
 // middleware interface for chaining middleware for single routes. Functions are simple HTTP handlers (w http.ResponseWriter, r *http.Request)
@@ -554,6 +900,23 @@ func waitForShutdown(srv *http.Server) {
 
 	srv.Shutdown(ctx)
 
+	//websocket connections are not closed by server shutdown - their commands are rejected from now on, so rooms are saved
+	//in final state
+	engine.FreezeRoomsForShutdown()
+
+	if RoomSnapshotEnabled {
+		engine.StopRoomSnapshotTimer()
+
+		if err := engine.SaveRoomsSnapshot(); err != nil {
+			util.LogSevere("Failed to save rooms snapshot on shutdown: '%s'", err)
+		}
+	}
+
+	//waits for queued writes
+	if err := engine.ActiveRoomStore.Close(); err != nil {
+		util.LogSevere("Failed to close room store: '%s'", err)
+	}
+
 	util.LogInfo("Shutting down")
 
 	os.Exit(0)
@@ -587,6 +950,51 @@ func loadAppConfigs() {
 	Domain = config.AppConfig.Domain
 	HttpSchema = config.AppConfig.HttpSchema
 
+	engine.DrawingFileBaseURL = HttpSchema + "://" + Domain
+
+	err = util.SetTrustedProxies(config.AppConfig.TrustedProxies)
+	if err != nil {
+		log.Printf("[SEVERE] Invalid trusted proxies in app config: '%s'", config.AppConfig.TrustedProxies)
+		panic(err)
+	}
+
+	RoomStoreType = config.AppConfig.RoomStore.Type
+	RoomStoreBoltFilePath = config.AppConfig.RoomStore.BoltFilePath
+
+	if len(config.AppConfig.MessageReactions) > 0 {
+		engine.AllowedMessageReactions = config.AppConfig.MessageReactions
+	}
+
+	if len(config.AppConfig.MessageFilters) > 0 {
+		messageFilters, err := engine.MakeMessageFilters(config.AppConfig.MessageFilters)
+		if err != nil {
+			log.Printf("[SEVERE] Invalid message filters in app config: '%+v'", config.AppConfig.MessageFilters)
+			panic(err)
+		}
+
+		engine.MessageFilters = messageFilters
+	}
+
+	engine.RoomMaxUsersBounds = getRoomSettingBounds("maxUsers", config.AppConfig.RoomSettings.MaxUsers, engine.RoomMaxUsersBounds, 1)
+	engine.RoomMessagesLimitBounds = getRoomSettingBounds("messagesLimit", config.AppConfig.RoomSettings.MessagesLimit, engine.RoomMessagesLimitBounds,
+		engine.RoomPinnedMessagesLimit+1)
+	engine.RoomInactiveTtlSecBounds = getRoomSettingBounds("inactiveTtlSec", config.AppConfig.RoomSettings.InactiveTtlSec, engine.RoomInactiveTtlSecBounds, 1)
+	engine.RoomMaxMessageLengthBounds = getRoomSettingBounds("maxMessageLength", config.AppConfig.RoomSettings.MaxMessageLength, engine.RoomMaxMessageLengthBounds, 1)
+
+	if config.AppConfig.MessageRateLimit.Burst > 0 && config.AppConfig.MessageRateLimit.RefillIntervalSec > 0 {
+		engine.MessageRateLimitBurst = config.AppConfig.MessageRateLimit.Burst
+		engine.MessageRateLimitRefillInterval = config.AppConfig.MessageRateLimit.RefillIntervalSec * time.Second
+	}
+
+	if config.AppConfig.DirectSendingRateLimit.Burst > 0 && config.AppConfig.DirectSendingRateLimit.RefillIntervalSec > 0 {
+		engine.DirectSendingRateLimitBurst = config.AppConfig.DirectSendingRateLimit.Burst
+		engine.DirectSendingRateLimitRefillInterval = config.AppConfig.DirectSendingRateLimit.RefillIntervalSec * time.Second
+	}
+
+	RoomSnapshotEnabled = config.AppConfig.RoomSnapshot.Enabled
+	engine.RoomSnapshotDirPath = config.AppConfig.RoomSnapshot.DirPath
+	engine.RoomSnapshotInterval = config.AppConfig.RoomSnapshot.IntervalSec * time.Second
+
 	envCtrlAuthLogin := os.Getenv("CTRL_AUTH_LOGIN")
 	if envCtrlAuthLogin != "" {
 		config.AppConfig.CtrlAuthLogin = envCtrlAuthLogin
@@ -608,10 +1016,54 @@ func loadAppConfigs() {
 	log.Printf("app config: HttpTimeout='%s'", HttpTimeout)
 	log.Printf("app config: HttpSchema='%s'", HttpSchema)
 	log.Printf("app config: Domain='%s'", Domain)
+	log.Printf("app config: DrawingFileBaseURL='%s'", engine.DrawingFileBaseURL)
+	log.Printf("app config: TrustedProxies='%s'", config.AppConfig.TrustedProxies)
 	log.Printf("app config: ShutdownWaitTimeout='%s'", ShutdownWaitTimeout)
 	log.Printf("app config: LogMaxSizeMb='%d'", LogMaxSizeMb)
 	log.Printf("app config: LogMaxFilesToKeep='%d'", LogMaxFilesToKeep)
 	log.Printf("app config: LogMaxFileAgeDays='%d'", LogMaxFileAgeDays)
+	log.Printf("app config: RoomStoreType='%s'", RoomStoreType)
+	log.Printf("app config: RoomStoreBoltFilePath='%s'", RoomStoreBoltFilePath)
+	log.Printf("app config: RoomSnapshotEnabled='%t'", RoomSnapshotEnabled)
+	log.Printf("app config: RoomSnapshotDirPath='%s'", engine.RoomSnapshotDirPath)
+	log.Printf("app config: RoomSnapshotInterval='%s'", engine.RoomSnapshotInterval)
+	log.Printf("app config: AllowedMessageReactions='%s'", engine.AllowedMessageReactions)
+	log.Printf("app config: MessageRateLimitBurst='%d'", engine.MessageRateLimitBurst)
+	log.Printf("app config: MessageRateLimitRefillInterval='%s'", engine.MessageRateLimitRefillInterval)
+	log.Printf("app config: DirectSendingRateLimitBurst='%d'", engine.DirectSendingRateLimitBurst)
+	log.Printf("app config: DirectSendingRateLimitRefillInterval='%s'", engine.DirectSendingRateLimitRefillInterval)
+	log.Printf("app config: MessageFilters='%s'", getMessageFilterNames())
+	log.Printf("app config: RoomMaxUsersBounds='%+v'", engine.RoomMaxUsersBounds)
+	log.Printf("app config: RoomMessagesLimitBounds='%+v'", engine.RoomMessagesLimitBounds)
+	log.Printf("app config: RoomInactiveTtlSecBounds='%+v'", engine.RoomInactiveTtlSecBounds)
+	log.Printf("app config: RoomMaxMessageLengthBounds='%+v'", engine.RoomMaxMessageLengthBounds)
+}
+
+// names of enabled message filters - for config log
+func getMessageFilterNames() []string {
+	var messageFilterNames []string
+
+	for _, messageFilter := range engine.MessageFilters {
+		messageFilterNames = append(messageFilterNames, messageFilter.Name())
+	}
+
+	return messageFilterNames
+}
+
+// missing or inconsistent bounds in config leave built-in ones. Messages limit must stay above pinned messages limit -
+// pinned messages are never dropped from history
+func getRoomSettingBounds(settingName string, configBounds config.RoomSettingBounds, builtInBounds config.RoomSettingBounds, lowestMin int) config.RoomSettingBounds {
+	if configBounds == (config.RoomSettingBounds{}) {
+		return builtInBounds
+	}
+
+	if configBounds.Min < lowestMin || configBounds.Min > configBounds.Default || configBounds.Default > configBounds.Max {
+		log.Printf("[SEVERE] Invalid room setting bounds in app config: '%s' = '%+v'. Using built-in ones", settingName, configBounds)
+
+		return builtInBounds
+	}
+
+	return configBounds
 }
 
 func setupMetrics() {
@@ -639,6 +1091,12 @@ func setupMetrics() {
 		})
 	prometheus.MustRegister(engine.AvgMessagesPerRoomGauge)
 
+	engine.RoomPasswordFailedAttemptsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "room_password_failed_attempts",
+		})
+	prometheus.MustRegister(engine.RoomPasswordFailedAttemptsCounter)
+
 	metrics.StartUsersOnlineGaugeTimer(&engine.UsersOnlineGauge, &engine.AvgUsersOnlineGauge, &engine.ActiveRoomsByNameMap)
 	metrics.StartAvgRoomMessagesGaugeTimer(&engine.AvgMessagesPerRoomGauge, &engine.ActiveRoomsByNameMap)
 }
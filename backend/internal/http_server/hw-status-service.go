@@ -15,6 +15,7 @@ type HwStatusInfo struct {
 	LastRamUsagePerc      float64 `json:"ram"`
 	UsersOnline           int64   `json:"uo"`
 	RequestedRoomFound    bool    `json:"rf"`
+	IsDraining            bool    `json:"dr"`
 }
 
 const MeasurementsMaxTicks = 3
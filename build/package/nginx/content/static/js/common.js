@@ -8,6 +8,8 @@ const ERROR_CODE_ROOM_INVALID_PASSWORD = 203;
 const ERROR_CODE_ROOM_INVALID_CREDS_LENGTH = 301;
 const ERROR_CODE_ROOM_INVALID_DESCRIPTION_LENGTH = 304;
 const ERROR_CODE_ROOM_USER_DUPLICATION = 209;
+const ERROR_CODE_ROOM_USER_KICKED = 211;
+const ERROR_CODE_ROOM_USER_BANNED = 212;
 
 const ERROR_CODE_INVALID_USER_NAME_LENGTH = 205;
 const ERROR_CODE_ROOM_NAME_CONTAINS_BAD_CHARS = 303;
@@ -47,6 +49,7 @@ const RECENT_ROOMS_EMPTY_BLOCK_ID = "recent-rooms-empty";
 const WEBVIEW_CHANGE_WINDOW_MODE_KEY_DEFAULT = 144;
 
 const VISITED_ROOMS_LOCAL_STORAGE_KEY = "VISITED_ROOMS";
+const OWNER_RECOVERY_KEYS_LOCAL_STORAGE_KEY = "OWNER_RECOVERY_KEYS";
 const COOKIES_ACCEPTED_LOCAL_STORAGE_KEY = "COOKIES_ACCEPTED";
 const BOTS_LIST_LOCAL_STORAGE_KEY = "BOTS_LIST";
 
@@ -94,6 +97,7 @@ const REQUEST_PROCESSING_DETAILS_ROOM_JOINED_MESSAGE  = "joined existing room";
 
 const NOTIFICATION_TEXT_MESSAGE_LIMIT_APPROACHING     = "room is approaching messages limit, old messages will be removed soon";
 const NOTIFICATION_TEXT_MESSAGE_LIMIT_REACHED         = "room messages limit reached, old messages were removed";
+const NOTIFICATION_TEXT_MENTIONED                     = "you were mentioned in a message";
 
 const NOTIFICATION_TEXT_USE_SHARE_BTN                 = 'to share room - please use \'share\' button';
 const NOTIFICATION_TEXT_ROOM_CREATOR_SELF_VOTE        = 'as a room creator, you can \'support\' own messages - to pin them';
@@ -118,6 +122,7 @@ const BUSINESS_ERRORS = {
     101: {name: "WsServerError",                             code: 101, text: "server error"},
     102: {name: "WsConnectionError",                         code: 102, text: "connection error"},
     103: {name: "WsInvalidInput",                            code: 103, text: "invalid input"},
+    104: {name: "WsServerDraining",                          code: 104, text: "server is not accepting new rooms at the moment, please try again in a minute"},
 
     201: {name: "WsRoomExists",                              code: 201, text: "room with this name already exists"},
     202: {name: "WsRoomNotFound",                            code: 202, text: "room not found"},
@@ -128,11 +133,25 @@ const BUSINESS_ERRORS = {
     207: {name: "WsRoomMessageTooLargeError",                code: 207, text: "message is too long"},
     208: {name: "WsRoomIsFullError",                         code: 208, text: "room is full"},
     209: {name: "WsRoomUserDuplication",                     code: 209, text: "user connected to this room from another browser tab"},
+    210: {name: "WsRoomIsMigrating",                         code: 210, text: "room is being moved to another server, please wait"},
+    211: {name: "WsRoomUserKicked",                          code: 211, text: "you were removed from this room by its owner or moderator"},
+    212: {name: "WsRoomUserBanned",                          code: 212, text: "you are banned from this room"},
+    213: {name: "WsRoomUserNotFound",                        code: 213, text: "user not found in this room"},
+    214: {name: "WsRoomNotPermitted",                        code: 214, text: "not enough rights in this room"},
+    215: {name: "WsRoomInvalidOwnerRecoveryKey",             code: 215, text: "invalid room owner recovery key"},
+    216: {name: "WsRoomPinnedMessagesLimit",                 code: 216, text: "pinned messages limit reached"},
+    217: {name: "WsRoomAnnouncementOnly",                    code: 217, text: "only room owner and moderators can post to this room"},
+    218: {name: "WsRoomRateLimited",                         code: 218, text: "too many messages, please slow down"},
+    219: {name: "WsRoomPasswordAttemptsLocked",              code: 219, text: "too many wrong password attempts, please try again later"},
+    220: {name: "WsRoomMessageRejected",                     code: 220, text: "message was rejected by content filter"},
+    221: {name: "WsRoomMessageEditDisabled",                 code: 221, text: "message editing is disabled in this room"},
+    222: {name: "WsRoomMessageEditWindowExpired",            code: 222, text: "message can not be edited anymore"},
 
     301: {name: "WsRoomCredsValidationErrorBadLength",       code: 301, text: "invalid room name length"},
     302: {name: "WsRoomCredsValidationErrorNameForbidden",   code: 302, text: "room name is forbidden"},
     303: {name: "WsRoomCredsValidationErrorNameHasBadChars", code: 303, text: "room name contains bad characters"},
     304: {name: "WsRoomValidationErrorBadDescriptionLength", code: 304, text: "invalid room description length"},
+    305: {name: "WsRoomValidationErrorBadSettings",          code: 305, text: "room settings are out of allowed bounds"},
 };
 
 const COMMANDS = {
@@ -142,21 +161,47 @@ const COMMANDS = {
     RoomJoin: "R_J",
     RoomChangeUserName: "R_CH_UN",
     RoomChangeDescription: "R_CH_D",
+    RoomChangeSettings: "R_CH_S",
+    RoomAnnouncementMode: "R_ANNOUNCE",
+    RoomSlowMode: "R_SLOW_MODE",
+    RoomMessageEditPolicy: "R_EDIT_POLICY",
     RoomMembersChanged: "R_M_CH",
+    RoomMoved: "R_MOVED",
+    RoomKickUser: "R_KICK",
+    RoomBanUser: "R_BAN",
+    RoomUnbanUser: "R_UNBAN",
+    RoomBannedUsers: "R_BANNED",
+    RoomGrantModerator: "R_MOD_GRANT",
+    RoomRevokeModerator: "R_MOD_REVOKE",
+    RoomTransferOwnership: "R_OWNER_TRANSFER",
+    RoomReclaimOwnership: "R_OWNER_RECLAIM",
+    RoomOwnerRecoveryKey: "R_OWNER_KEY",
+    RoomPinnedMessages: "R_PINNED",
 
     TextMessage: "TM",
     TextMessageEdit: "TM_E",
     TextMessageDelete: "TM_D",
     TextMessageSupportOrReject: "TM_S_R",
     AllTextMessages: "ALL_TM",
+    TextMessageHistory: "TM_HISTORY",
+    TextMessagePin: "TM_PIN",
+    TextMessageUnpin: "TM_UNPIN",
+    TextMessageReaction: "TM_REACT",
+    TextMessageWhisper: "TM_W",
+    TextMessageSearch: "TM_SEARCH",
+    TextMessageRevisions: "TM_REVISIONS",
 
     UserDrawingMessage: "DM",
+    UserTyping: "TYPING",
+    UserReadMarker: "READ",
+    UserPresence: "PRESENCE",
 
     Error: "ER",
     RequestProcessed: "RP",
 
     NotifyMessagesLimitApproaching: "N_M_LIMIT_A",
     NotifyMessagesLimitReached: "N_M_LIMIT_R",
+    NotifyMentioned: "N_MENTION",
 };
 
 const allowedRoomNameSpecialChars = {
@@ -399,6 +444,15 @@ function storeVisitedRoomsArray(newVisitedRooms) {
     LOCAL_STORAGE.setItem(VISITED_ROOMS_LOCAL_STORAGE_KEY, JSON.stringify(newVisitedRooms));
 }
 
+//room owner gets one-time key to reclaim ownership from other session (previous key stops working once new one is issued)
+function storeOwnerRecoveryKey(roomName, ownerRecoveryKey) {
+    const ownerRecoveryKeys = JSON.parse(LOCAL_STORAGE.getItem(OWNER_RECOVERY_KEYS_LOCAL_STORAGE_KEY) || "{}");
+
+    ownerRecoveryKeys[roomName] = ownerRecoveryKey;
+
+    LOCAL_STORAGE.setItem(OWNER_RECOVERY_KEYS_LOCAL_STORAGE_KEY, JSON.stringify(ownerRecoveryKeys));
+}
+
 function formatRoomNameInput(roomNameInput) {
     return roomNameInput.toLowerCase().trim()
         .replace(/\s+/g, '-');
@@ -225,6 +225,10 @@ function initHomepageWebSocket (roomName, backendInstanceAddr, alternativeRoomNa
 
                 shutdownSocket();
 
+                if (message.oK) {
+                    storeOwnerRecoveryKey($roomNameInput.val(), message.oK);
+                }
+
                 //save info about room was created/joined into local storage before redirect
                 const isCreatedRoom = message.pd === REQUEST_PROCESSING_DETAILS_ROOM_CREATED;
                 LOCAL_STORAGE.setItem(REDIRECT_VARIABLE_LOCAL_STORAGE_KEY, JSON.stringify({
@@ -38,6 +38,8 @@ let userIdToMessageIdsWithUnknownReplyToUserName;
 let roomMessageIdToDOMElem;
 let folkPicksMessageIdToDOMElem;
 let messageIdToTextSearchInfo;
+//pinned messages are kept by server when room messages limit is reached
+let pinnedMessageIds;
 
 let roomUUID;
 let roomHasPassword;
@@ -72,6 +74,10 @@ let textSearchDataChangedAt;
 
 let deletedMessageIds;
 
+//mentions received while room tab was in background - shown in page title until user returns
+let unseenMentionsCount;
+let documentTitleWithoutMentionsBadge;
+
 let wsReconnectTimeout;
 let onSelectionChangeTimeout;
 let onScrollBodyTimeout;
@@ -107,6 +113,7 @@ function initializeVariables () {
     roomMessageIdToDOMElem = {};
     folkPicksMessageIdToDOMElem = {};
     messageIdToTextSearchInfo = {};
+    pinnedMessageIds = {};
 
     roomUUID = null;
     roomHasPassword = false;
@@ -140,6 +147,9 @@ function initializeVariables () {
 
     deletedMessageIds = {};
 
+    unseenMentionsCount = 0;
+    documentTitleWithoutMentionsBadge = null;
+
     wsReconnectTimeout = -1;
     onSelectionChangeTimeout = -1;
     onScrollBodyTimeout = -1;
@@ -168,6 +178,8 @@ function doInit () {
 
     keepAlive();
 
+    document.addEventListener("visibilitychange", onDocumentVisibilityChange);
+
     //get redirect variable (if any). If this page loaded after redirect from home page (using room form) - must draw notification
     const redirectVariableStr = LOCAL_STORAGE.getItem(REDIRECT_VARIABLE_LOCAL_STORAGE_KEY);
 
@@ -677,8 +689,21 @@ function initRoomPageWebSocket (roomName, backendInstanceAddr, alternativeRoomNa
 
             case COMMANDS.NotifyMessagesLimitApproaching:
             case COMMANDS.NotifyMessagesLimitReached:
+            case COMMANDS.NotifyMentioned:
                 processRoomNotificationCommand(message);
                 break;
+
+            case COMMANDS.RoomMoved:
+                processRoomMovedCommand(message);
+                break;
+
+            case COMMANDS.RoomPinnedMessages:
+                processRoomPinnedMessagesCommand(message);
+                break;
+
+            case COMMANDS.RoomOwnerRecoveryKey:
+                storeOwnerRecoveryKey(ROOM_NAME, message.oK);
+                break;
         }
     }
 }
@@ -2309,7 +2334,7 @@ function processRoomNotificationCommand (message) {
             const lowestRemainingMessageId = parseInt(message.m[0].t);
 
             for (let messageId in roomMessageIdToDOMElem) {
-                if (messageId < lowestRemainingMessageId) {
+                if (messageId < lowestRemainingMessageId && !pinnedMessageIds[messageId]) {
                     const $roomMessage = findMessageBlockById(messageId);
                     if ($roomMessage) {
                         deleteRoomMessage(messageId, $roomMessage, false);
@@ -2318,10 +2343,53 @@ function processRoomNotificationCommand (message) {
             }
 
             break;
+
+        case COMMANDS.NotifyMentioned:
+            showTopNotification(NOTIFICATION_TEXT_MENTIONED, TOP_NOTIFICATION_SHOW_MS * 2, true);
+
+            if (document.hidden) {
+                alertAboutMentionInBackground();
+            }
+
+            break;
+    }
+}
+
+//top notification is gone by the time user returns to background tab - so mentions are counted in page title
+function alertAboutMentionInBackground () {
+    if (unseenMentionsCount === 0) {
+        documentTitleWithoutMentionsBadge = document.title;
+    }
+
+    unseenMentionsCount++;
+    document.title = "(" + unseenMentionsCount + ") @ " + documentTitleWithoutMentionsBadge;
+
+    //system notification is shown only if user allowed them for this site
+    if ("Notification" in window && Notification.permission === "granted") {
+        new Notification(NOTIFICATION_TEXT_MENTIONED, {body: documentTitleWithoutMentionsBadge, tag: "instantchat-mention"});
+    }
+}
+
+function onDocumentVisibilityChange () {
+    if (!document.hidden && unseenMentionsCount > 0) {
+        document.title = documentTitleWithoutMentionsBadge;
+        unseenMentionsCount = 0;
+    }
+}
+
+function processRoomPinnedMessagesCommand (message) {
+    pinnedMessageIds = {};
+
+    for (let i = 0; i < message.m.length; i++) {
+        pinnedMessageIds[message.m[i].id] = true;
     }
 }
 
 function processRequestProcessedCommand (message) {
+    if (message.oK) {
+        storeOwnerRecoveryKey(ROOM_NAME, message.oK);
+    }
+
     //happens when initial room page info fetch completes
     if (message.rq === "room_c_j_done") {
 
@@ -2480,6 +2548,13 @@ function processErrorCommand (message, alternativeRoomNamePostfixes) {
         return;
     }
 
+    //user was removed from room by its creator - must not reconnect automatically
+    if (businessError.code === ERROR_CODE_ROOM_USER_KICKED || businessError.code === ERROR_CODE_ROOM_USER_BANNED) {
+        redirectToHomePageWithError(ROOM_TO_HOME_PG_REDIRECT_ERROR_BUSINESS, null, businessError, alternativeRoomNamePostfixes);
+
+        return;
+    }
+
     if (isLoggedIn) {
         showError(businessError.text);
     } else {
@@ -3007,6 +3082,11 @@ function isWsOpen() {
     return ws.readyState === ws.OPEN
 }
 
+//room was moved to another backend - reconnect, new backend address will be returned by 'pick backend'
+function processRoomMovedCommand (message) {
+    onConnectionBroken();
+}
+
 function onConnectionBroken () {
     if (isLoggedIn || isRoomReconnectInProgress) {
         //store last state of messageIdToTextSearchInfo until reconnect and new all-messages command arrival